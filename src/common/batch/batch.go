@@ -0,0 +1,61 @@
+package batch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Header marks a datagram as a batch of length-prefixed messages. A single
+// signed message starts with an HMAC, so the chance of it starting with these
+// bytes by accident is negligible.
+var Header = []byte{0xff, 'D', 'S', 'B', 'A', 'T', 'C', 'H'}
+
+const lengthPrefixSize = 4
+
+var ErrTruncated = errors.New("batch is truncated")
+
+// IsBatch reports whether the given datagram is a batch.
+func IsBatch(data []byte) bool {
+	return bytes.HasPrefix(data, Header)
+}
+
+// EncodedSize returns how many bytes a message takes up inside a batch.
+func EncodedSize(message []byte) int {
+	return lengthPrefixSize + len(message)
+}
+
+// Append adds a length-prefixed message to a batch buffer. The buffer must
+// already start with Header.
+func Append(buffer *bytes.Buffer, message []byte) {
+	var prefix [lengthPrefixSize]byte
+	binary.LittleEndian.PutUint32(prefix[:], uint32(len(message)))
+	buffer.Write(prefix[:])
+	buffer.Write(message)
+}
+
+// Split returns the messages contained in a batch.
+func Split(data []byte) ([][]byte, error) {
+	if !IsBatch(data) {
+		return nil, errors.New("missing batch header")
+	}
+
+	var messages [][]byte
+	remaining := data[len(Header):]
+	for len(remaining) > 0 {
+		if len(remaining) < lengthPrefixSize {
+			return messages, ErrTruncated
+		}
+
+		size := int(binary.LittleEndian.Uint32(remaining))
+		remaining = remaining[lengthPrefixSize:]
+		if size > len(remaining) {
+			return messages, ErrTruncated
+		}
+
+		messages = append(messages, remaining[:size])
+		remaining = remaining[size:]
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,51 @@
+package batch_test
+
+import (
+	"bytes"
+
+	"common/batch"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+	var buffer *bytes.Buffer
+
+	BeforeEach(func() {
+		buffer = bytes.NewBuffer(nil)
+		buffer.Write(batch.Header)
+	})
+
+	It("round trips messages", func() {
+		batch.Append(buffer, []byte("one"))
+		batch.Append(buffer, []byte{})
+		batch.Append(buffer, []byte("three"))
+
+		Expect(buffer.Len()).To(Equal(len(batch.Header) + batch.EncodedSize([]byte("one")) + batch.EncodedSize(nil) + batch.EncodedSize([]byte("three"))))
+
+		messages, err := batch.Split(buffer.Bytes())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(Equal([][]byte{[]byte("one"), []byte{}, []byte("three")}))
+	})
+
+	It("recognizes batches by their header", func() {
+		Expect(batch.IsBatch(buffer.Bytes())).To(BeTrue())
+		Expect(batch.IsBatch([]byte("signature and payload"))).To(BeFalse())
+	})
+
+	It("returns an error for data without a header", func() {
+		_, err := batch.Split([]byte("not a batch"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns the complete messages of a truncated batch", func() {
+		batch.Append(buffer, []byte("complete"))
+		batch.Append(buffer, []byte("truncated"))
+		data := buffer.Bytes()
+
+		messages, err := batch.Split(data[:len(data)-2])
+		Expect(err).To(Equal(batch.ErrTruncated))
+		Expect(messages).To(Equal([][]byte{[]byte("complete")}))
+	})
+})
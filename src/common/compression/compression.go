@@ -0,0 +1,123 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// Header marks a message as compressed; the byte following it identifies the
+// algorithm. A marshalled dropsonde envelope never starts with 0xff, so
+// receivers can tell compressed and uncompressed messages apart.
+var Header = []byte{0xff, 'D', 'S', 'Z'}
+
+// MaxDecompressedSize bounds how large a decompressed message may get, so that
+// a small malicious message can't exhaust the receiver's memory.
+var MaxDecompressedSize = 1 << 20
+
+var ErrTooLarge = errors.New("decompressed message is too large")
+
+var algorithmIds = map[string]byte{
+	Gzip:    'g',
+	Deflate: 'd',
+}
+
+// IsCompressed reports whether the given message was compressed by a
+// Compressor.
+func IsCompressed(data []byte) bool {
+	return len(data) > len(Header) && bytes.HasPrefix(data, Header)
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// A Compressor compresses messages with one algorithm, reusing its internal
+// state between messages. It is safe for concurrent use.
+type Compressor struct {
+	id     byte
+	buffer bytes.Buffer
+	writer resetWriter
+	lock   sync.Mutex
+}
+
+func NewCompressor(algorithm string) (*Compressor, error) {
+	c := &Compressor{}
+
+	switch algorithm {
+	case Gzip:
+		c.writer = gzip.NewWriter(&c.buffer)
+	case Deflate:
+		writer, err := flate.NewWriter(&c.buffer, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		c.writer = writer
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q, must be %s or %s", algorithm, Gzip, Deflate)
+	}
+
+	c.id = algorithmIds[algorithm]
+	return c, nil
+}
+
+// Compress returns the message compressed and prefixed with Header.
+func (c *Compressor) Compress(message []byte) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.buffer.Reset()
+	c.buffer.Write(Header)
+	c.buffer.WriteByte(c.id)
+
+	c.writer.Reset(&c.buffer)
+	c.writer.Write(message)
+	c.writer.Close()
+
+	compressed := make([]byte, c.buffer.Len())
+	copy(compressed, c.buffer.Bytes())
+	return compressed
+}
+
+// Decompress returns the original message of one returned by Compress.
+func Decompress(data []byte) ([]byte, error) {
+	if !IsCompressed(data) {
+		return nil, errors.New("missing compression header")
+	}
+
+	compressed := bytes.NewReader(data[len(Header)+1:])
+	var reader io.ReadCloser
+	switch data[len(Header)] {
+	case algorithmIds[Gzip]:
+		gzipReader, err := gzip.NewReader(compressed)
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+	case algorithmIds[Deflate]:
+		reader = flate.NewReader(compressed)
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", data[len(Header)])
+	}
+	defer reader.Close()
+
+	message, err := ioutil.ReadAll(io.LimitReader(reader, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return message, nil
+}
@@ -0,0 +1,80 @@
+package compression_test
+
+import (
+	"bytes"
+
+	"common/compression"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	message := bytes.Repeat([]byte("a highly compressible log line "), 100)
+
+	for _, algorithm := range []string{compression.Gzip, compression.Deflate} {
+		algorithm := algorithm
+
+		Context("with "+algorithm, func() {
+			It("round trips messages", func() {
+				compressor, err := compression.NewCompressor(algorithm)
+				Expect(err).NotTo(HaveOccurred())
+
+				compressed := compressor.Compress(message)
+				Expect(len(compressed)).To(BeNumerically("<", len(message)))
+				Expect(compression.IsCompressed(compressed)).To(BeTrue())
+
+				decompressed, err := compression.Decompress(compressed)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decompressed).To(Equal(message))
+			})
+
+			It("reuses its state between messages", func() {
+				compressor, err := compression.NewCompressor(algorithm)
+				Expect(err).NotTo(HaveOccurred())
+
+				first := compressor.Compress([]byte("first"))
+				second := compressor.Compress([]byte("second"))
+
+				decompressed, err := compression.Decompress(first)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decompressed).To(Equal([]byte("first")))
+
+				decompressed, err = compression.Decompress(second)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decompressed).To(Equal([]byte("second")))
+			})
+		})
+	}
+
+	It("rejects unknown algorithms", func() {
+		_, err := compression.NewCompressor("snappy")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not mistake uncompressed messages for compressed ones", func() {
+		Expect(compression.IsCompressed([]byte{0x0a, 0x03, 'f', 'o', 'o'})).To(BeFalse())
+		Expect(compression.IsCompressed(compression.Header)).To(BeFalse())
+
+		_, err := compression.Decompress([]byte("uncompressed"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for corrupt messages", func() {
+		compressor, _ := compression.NewCompressor(compression.Gzip)
+		compressed := compressor.Compress(message)
+
+		_, err := compression.Decompress(compressed[:len(compressed)/2])
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to decompress messages beyond the maximum size", func() {
+		original := compression.MaxDecompressedSize
+		compression.MaxDecompressedSize = len(message) - 1
+		defer func() { compression.MaxDecompressedSize = original }()
+
+		compressor, _ := compression.NewCompressor(compression.Deflate)
+		_, err := compression.Decompress(compressor.Compress(message))
+		Expect(err).To(Equal(compression.ErrTooLarge))
+	})
+})
@@ -0,0 +1,143 @@
+package logfilter
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// FirehoseParameters are the names of the query parameters a FirehoseFilter
+// is parsed from.
+var FirehoseParameters = []string{"event_type", "origin", "deployment", "job", "metric_prefix"}
+
+// A FirehoseFilter selects the envelopes of a firehose subscription by the
+// query parameters of the request. Every parameter may be repeated or hold a
+// comma separated list:
+//
+//	event_type     only envelopes of the given types, e.g. ValueMetric
+//	origin         only envelopes from the given origins
+//	deployment     only envelopes tagged with the given deployments
+//	job            only envelopes tagged with the given jobs
+//	metric_prefix  only ValueMetrics and CounterEvents whose names start with
+//	               one of the given prefixes
+//
+// metric_prefix doesn't apply to other events than metrics. The zero
+// FirehoseFilter selects everything.
+type FirehoseFilter struct {
+	EventTypes     map[events.Envelope_EventType]bool
+	Origins        map[string]bool
+	Deployments    map[string]bool
+	Jobs           map[string]bool
+	MetricPrefixes []string
+}
+
+// ParseFirehose returns the FirehoseFilter described by the query, or an
+// error naming the first invalid parameter.
+func ParseFirehose(query url.Values) (*FirehoseFilter, error) {
+	eventTypes, err := parseEventTypes(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FirehoseFilter{
+		EventTypes:     eventTypes,
+		Origins:        valueSet(query, "origin"),
+		Deployments:    valueSet(query, "deployment"),
+		Jobs:           valueSet(query, "job"),
+		MetricPrefixes: listValues(query, "metric_prefix"),
+	}, nil
+}
+
+// Matches reports whether the envelope is selected by every parameter.
+func (f *FirehoseFilter) Matches(envelope *events.Envelope) bool {
+	switch {
+	case f.EventTypes != nil && !f.EventTypes[envelope.GetEventType()]:
+		return false
+	case f.Origins != nil && !f.Origins[envelope.GetOrigin()]:
+		return false
+	case f.Deployments != nil && !f.Deployments[envelope.GetDeployment()]:
+		return false
+	case f.Jobs != nil && !f.Jobs[envelope.GetJob()]:
+		return false
+	}
+
+	if f.MetricPrefixes == nil {
+		return true
+	}
+
+	var name string
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric:
+		name = envelope.GetValueMetric().GetName()
+	case events.Envelope_CounterEvent:
+		name = envelope.GetCounterEvent().GetName()
+	default:
+		return true
+	}
+
+	for _, prefix := range f.MetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEmpty reports whether the filter selects everything.
+func (f *FirehoseFilter) IsEmpty() bool {
+	return f.EventTypes == nil && f.Origins == nil && f.Deployments == nil && f.Jobs == nil && f.MetricPrefixes == nil
+}
+
+// Key returns a canonical encoding of the filter: filters selecting the same
+// envelopes have the same key, regardless of how their parameters were
+// ordered or spelled.
+func (f *FirehoseFilter) Key() string {
+	query := url.Values{}
+
+	var types []string
+	for eventType := range f.EventTypes {
+		types = append(types, eventType.String())
+	}
+	setSorted(query, "event_type", types)
+
+	setSorted(query, "origin", keys(f.Origins))
+	setSorted(query, "deployment", keys(f.Deployments))
+	setSorted(query, "job", keys(f.Jobs))
+	setSorted(query, "metric_prefix", keys(valueMap(f.MetricPrefixes)))
+
+	return query.Encode()
+}
+
+func valueSet(query url.Values, name string) map[string]bool {
+	values := listValues(query, name)
+	if values == nil {
+		return nil
+	}
+	return valueMap(values)
+}
+
+func valueMap(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+func keys(set map[string]bool) []string {
+	var values []string
+	for value := range set {
+		values = append(values, value)
+	}
+	return values
+}
+
+func setSorted(query url.Values, name string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	sort.Strings(values)
+	query[name] = values
+}
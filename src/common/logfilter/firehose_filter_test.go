@@ -0,0 +1,84 @@
+package logfilter_test
+
+import (
+	"net/url"
+
+	"common/logfilter"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FirehoseFilter", func() {
+	parse := func(query string) *logfilter.FirehoseFilter {
+		values, err := url.ParseQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+
+		filter, err := logfilter.ParseFirehose(values)
+		Expect(err).NotTo(HaveOccurred())
+		return filter
+	}
+
+	envelope := func(origin, deployment, job string) *events.Envelope {
+		return &events.Envelope{
+			Origin:     proto.String(origin),
+			EventType:  events.Envelope_LogMessage.Enum(),
+			Deployment: proto.String(deployment),
+			Job:        proto.String(job),
+			LogMessage: &events.LogMessage{
+				Message:     []byte("message"),
+				MessageType: events.LogMessage_OUT.Enum(),
+				Timestamp:   proto.Int64(1),
+			},
+		}
+	}
+
+	valueMetric := func(name string) *events.Envelope {
+		return &events.Envelope{
+			Origin:      proto.String("gorouter"),
+			EventType:   events.Envelope_ValueMetric.Enum(),
+			ValueMetric: &events.ValueMetric{Name: proto.String(name), Value: proto.Float64(1), Unit: proto.String("unit")},
+		}
+	}
+
+	It("selects everything without parameters", func() {
+		filter := parse("")
+		Expect(filter.IsEmpty()).To(BeTrue())
+		Expect(filter.Matches(envelope("origin", "cf", "router"))).To(BeTrue())
+		Expect(filter.Key()).To(BeEmpty())
+	})
+
+	It("selects by event type", func() {
+		filter := parse("event_type=ValueMetric,CounterEvent")
+		Expect(filter.Matches(valueMetric("latency"))).To(BeTrue())
+		Expect(filter.Matches(envelope("origin", "cf", "router"))).To(BeFalse())
+	})
+
+	It("selects by origin, deployment and job", func() {
+		filter := parse("origin=gorouter&origin=uaa&deployment=cf&job=router_z1,router_z2")
+		Expect(filter.Matches(envelope("gorouter", "cf", "router_z2"))).To(BeTrue())
+		Expect(filter.Matches(envelope("dea", "cf", "router_z2"))).To(BeFalse())
+		Expect(filter.Matches(envelope("uaa", "other", "router_z1"))).To(BeFalse())
+		Expect(filter.Matches(envelope("uaa", "cf", "uaa_z1"))).To(BeFalse())
+	})
+
+	It("selects metrics by name prefix", func() {
+		filter := parse("metric_prefix=gorouter.,uaa.")
+		Expect(filter.Matches(valueMetric("gorouter.latency"))).To(BeTrue())
+		Expect(filter.Matches(valueMetric("dea.memory"))).To(BeFalse())
+		Expect(filter.Matches(envelope("origin", "cf", "router"))).To(BeTrue())
+	})
+
+	It("has the same key for equivalent filters", func() {
+		Expect(parse("origin=b,a&event_type=valuemetric").Key()).To(Equal(parse("event_type=ValueMetric&origin=a&origin=b").Key()))
+		Expect(parse("origin=a").Key()).NotTo(Equal(parse("origin=b").Key()))
+	})
+
+	It("rejects unknown event types", func() {
+		_, err := logfilter.ParseFirehose(url.Values{"event_type": {"Unknown"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
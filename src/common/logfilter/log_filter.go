@@ -0,0 +1,145 @@
+package logfilter
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// A Filter selects log messages by the query parameters of a recentlogs
+// request:
+//
+//	since, until   only messages logged at or after, respectively at or before,
+//	               the given time, as Unix nanoseconds or in RFC 3339 format
+//	source_type    only messages from the given source, e.g. APP, RTR or STG
+//	message_type   only OUT or ERR messages
+//	instance       only messages from the given instance index
+//	limit          at most the given number of the most recent messages
+//
+// The zero Filter selects everything.
+type Filter struct {
+	Since       int64
+	Until       int64
+	SourceType  string
+	MessageType *events.LogMessage_MessageType
+	Instance    string
+	Limit       int
+}
+
+// Parameters are the names of the query parameters a Filter is parsed from.
+var Parameters = []string{"since", "until", "source_type", "message_type", "instance", "limit"}
+
+// Parse returns the Filter described by the query, or an error naming the
+// first invalid parameter.
+func Parse(query url.Values) (*Filter, error) {
+	filter := &Filter{
+		SourceType: query.Get("source_type"),
+	}
+
+	var err error
+	if filter.Since, err = parseTime(query, "since"); err != nil {
+		return nil, err
+	}
+	if filter.Until, err = parseTime(query, "until"); err != nil {
+		return nil, err
+	}
+	if filter.Until != 0 && filter.Until < filter.Since {
+		return nil, fmt.Errorf("until must not be before since")
+	}
+
+	if value := query.Get("message_type"); value != "" {
+		messageType, ok := events.LogMessage_MessageType_value[strings.ToUpper(value)]
+		if !ok {
+			return nil, fmt.Errorf("invalid message_type %q, must be OUT or ERR", value)
+		}
+		filter.MessageType = events.LogMessage_MessageType(messageType).Enum()
+	}
+
+	if value := query.Get("instance"); value != "" {
+		if index, err := strconv.Atoi(value); err != nil || index < 0 {
+			return nil, fmt.Errorf("invalid instance %q, must be an instance index", value)
+		}
+		filter.Instance = value
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q, must be a positive number", value)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// Matches reports whether the envelope is a log message selected by every
+// parameter except limit.
+func (f *Filter) Matches(envelope *events.Envelope) bool {
+	if envelope.GetEventType() != events.Envelope_LogMessage {
+		return false
+	}
+
+	logMessage := envelope.GetLogMessage()
+	timestamp := logMessage.GetTimestamp()
+	switch {
+	case f.Since != 0 && timestamp < f.Since:
+		return false
+	case f.Until != 0 && timestamp > f.Until:
+		return false
+	case f.SourceType != "" && !strings.EqualFold(logMessage.GetSourceType(), f.SourceType):
+		return false
+	case f.MessageType != nil && logMessage.GetMessageType() != *f.MessageType:
+		return false
+	case f.Instance != "" && logMessage.GetSourceInstance() != f.Instance:
+		return false
+	}
+	return true
+}
+
+// Apply returns the matching envelopes, oldest first. With a limit, only the
+// most recent ones are kept.
+func (f *Filter) Apply(envelopes []*events.Envelope) []*events.Envelope {
+	matching := []*events.Envelope{}
+	for _, envelope := range envelopes {
+		if f.Matches(envelope) {
+			matching = append(matching, envelope)
+		}
+	}
+
+	sort.Stable(byTimestamp(matching))
+	if f.Limit > 0 && len(matching) > f.Limit {
+		matching = matching[len(matching)-f.Limit:]
+	}
+	return matching
+}
+
+func parseTime(query url.Values, name string) (int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return nanos, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q, must be Unix nanoseconds or an RFC 3339 time", name, value)
+	}
+	return t.UnixNano(), nil
+}
+
+type byTimestamp []*events.Envelope
+
+func (b byTimestamp) Len() int { return len(b) }
+func (b byTimestamp) Less(i, j int) bool {
+	return b[i].GetLogMessage().GetTimestamp() < b[j].GetLogMessage().GetTimestamp()
+}
+func (b byTimestamp) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,101 @@
+package logfilter_test
+
+import (
+	"net/url"
+
+	"common/logfilter"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	parse := func(query string) *logfilter.Filter {
+		values, err := url.ParseQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+
+		filter, err := logfilter.Parse(values)
+		Expect(err).NotTo(HaveOccurred())
+		return filter
+	}
+
+	var logs []*events.Envelope
+
+	BeforeEach(func() {
+		logs = []*events.Envelope{
+			logMessage("one", 10, "APP", "0", events.LogMessage_OUT),
+			logMessage("two", 20, "RTR", "1", events.LogMessage_OUT),
+			logMessage("three", 30, "APP", "1", events.LogMessage_ERR),
+			logMessage("four", 40, "STG", "0", events.LogMessage_OUT),
+		}
+	})
+
+	It("selects everything without parameters", func() {
+		Expect(messages(parse("").Apply(logs))).To(Equal([]string{"one", "two", "three", "four"}))
+	})
+
+	It("selects by time", func() {
+		Expect(messages(parse("since=20&until=30").Apply(logs))).To(Equal([]string{"two", "three"}))
+		Expect(messages(parse("since=1970-01-01T00:00:00.000000035Z").Apply(logs))).To(Equal([]string{"four"}))
+	})
+
+	It("selects by source type, message type and instance", func() {
+		Expect(messages(parse("source_type=app").Apply(logs))).To(Equal([]string{"one", "three"}))
+		Expect(messages(parse("message_type=ERR").Apply(logs))).To(Equal([]string{"three"}))
+		Expect(messages(parse("instance=0").Apply(logs))).To(Equal([]string{"one", "four"}))
+		Expect(messages(parse("source_type=APP&instance=0").Apply(logs))).To(Equal([]string{"one"}))
+	})
+
+	It("returns the messages oldest first", func() {
+		shuffled := []*events.Envelope{logs[3], logs[0], logs[2], logs[1]}
+		Expect(messages(parse("").Apply(shuffled))).To(Equal([]string{"one", "two", "three", "four"}))
+	})
+
+	It("keeps the most recent messages up to the limit", func() {
+		shuffled := []*events.Envelope{logs[3], logs[0], logs[2], logs[1]}
+		Expect(messages(parse("limit=2").Apply(shuffled))).To(Equal([]string{"three", "four"}))
+	})
+
+	It("ignores other events", func() {
+		metric := &events.Envelope{
+			Origin:      proto.String("origin"),
+			EventType:   events.Envelope_ValueMetric.Enum(),
+			ValueMetric: &events.ValueMetric{Name: proto.String("metric"), Value: proto.Float64(1), Unit: proto.String("unit")},
+		}
+		Expect(parse("").Matches(metric)).To(BeFalse())
+	})
+
+	It("rejects invalid parameters", func() {
+		for _, query := range []string{"since=yesterday", "since=20&until=10", "message_type=DEBUG", "instance=first", "limit=0", "limit=-1"} {
+			values, _ := url.ParseQuery(query)
+			_, err := logfilter.Parse(values)
+			Expect(err).To(HaveOccurred(), query)
+		}
+	})
+})
+
+func logMessage(message string, timestamp int64, sourceType string, instance string, messageType events.LogMessage_MessageType) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String("origin"),
+		EventType: events.Envelope_LogMessage.Enum(),
+		LogMessage: &events.LogMessage{
+			Message:        []byte(message),
+			MessageType:    messageType.Enum(),
+			Timestamp:      proto.Int64(timestamp),
+			AppId:          proto.String("app-id"),
+			SourceType:     proto.String(sourceType),
+			SourceInstance: proto.String(instance),
+		},
+	}
+}
+
+func messages(envelopes []*events.Envelope) []string {
+	result := []string{}
+	for _, envelope := range envelopes {
+		result = append(result, string(envelope.GetLogMessage().GetMessage()))
+	}
+	return result
+}
@@ -0,0 +1,13 @@
+package logfilter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLogfilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logfilter Suite")
+}
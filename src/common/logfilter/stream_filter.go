@@ -0,0 +1,139 @@
+package logfilter
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// StreamParameters are the names of the query parameters a StreamFilter is
+// parsed from.
+var StreamParameters = []string{"event_type", "source_type", "message_type", "instance"}
+
+// A StreamFilter selects the envelopes of an app stream by the query
+// parameters of the request. event_type, source_type and instance may be
+// repeated or hold comma separated lists:
+//
+//	event_type     only envelopes of the given types, e.g. LogMessage or
+//	               ContainerMetric
+//	source_type    only log messages from the given sources, e.g. APP or RTR
+//	message_type   only OUT or ERR log messages
+//	instance       only log messages and container metrics of the given
+//	               instance indexes
+//
+// source_type and message_type don't apply to other events than log
+// messages. The zero StreamFilter selects everything.
+type StreamFilter struct {
+	EventTypes  map[events.Envelope_EventType]bool
+	SourceTypes map[string]bool
+	MessageType *events.LogMessage_MessageType
+	Instances   map[string]bool
+}
+
+// ParseStream returns the StreamFilter described by the query, or an error
+// naming the first invalid parameter.
+func ParseStream(query url.Values) (*StreamFilter, error) {
+	filter := &StreamFilter{}
+
+	var err error
+	if filter.EventTypes, err = parseEventTypes(query); err != nil {
+		return nil, err
+	}
+
+	for _, value := range listValues(query, "source_type") {
+		if filter.SourceTypes == nil {
+			filter.SourceTypes = make(map[string]bool)
+		}
+		filter.SourceTypes[strings.ToUpper(value)] = true
+	}
+
+	if value := query.Get("message_type"); value != "" {
+		messageType, ok := events.LogMessage_MessageType_value[strings.ToUpper(value)]
+		if !ok {
+			return nil, fmt.Errorf("invalid message_type %q, must be OUT or ERR", value)
+		}
+		filter.MessageType = events.LogMessage_MessageType(messageType).Enum()
+	}
+
+	for _, value := range listValues(query, "instance") {
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("invalid instance %q, must be an instance index", value)
+		}
+		if filter.Instances == nil {
+			filter.Instances = make(map[string]bool)
+		}
+		filter.Instances[strconv.Itoa(index)] = true
+	}
+
+	return filter, nil
+}
+
+// Matches reports whether the envelope is selected by every parameter.
+func (f *StreamFilter) Matches(envelope *events.Envelope) bool {
+	if f.EventTypes != nil && !f.EventTypes[envelope.GetEventType()] {
+		return false
+	}
+
+	switch envelope.GetEventType() {
+	case events.Envelope_LogMessage:
+		logMessage := envelope.GetLogMessage()
+		if f.SourceTypes != nil && !f.SourceTypes[strings.ToUpper(logMessage.GetSourceType())] {
+			return false
+		}
+		if f.MessageType != nil && logMessage.GetMessageType() != *f.MessageType {
+			return false
+		}
+		if f.Instances != nil && !f.Instances[logMessage.GetSourceInstance()] {
+			return false
+		}
+	case events.Envelope_ContainerMetric:
+		if f.Instances != nil && !f.Instances[strconv.Itoa(int(envelope.GetContainerMetric().GetInstanceIndex()))] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsEmpty reports whether the filter selects everything.
+func (f *StreamFilter) IsEmpty() bool {
+	return f.EventTypes == nil && f.SourceTypes == nil && f.MessageType == nil && f.Instances == nil
+}
+
+var eventTypes = func() map[string]events.Envelope_EventType {
+	types := make(map[string]events.Envelope_EventType)
+	for name, value := range events.Envelope_EventType_value {
+		types[strings.ToLower(name)] = events.Envelope_EventType(value)
+	}
+	return types
+}()
+
+func parseEventTypes(query url.Values) (map[events.Envelope_EventType]bool, error) {
+	var types map[events.Envelope_EventType]bool
+	for _, value := range listValues(query, "event_type") {
+		eventType, ok := eventTypes[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("invalid event_type %q", value)
+		}
+		if types == nil {
+			types = make(map[events.Envelope_EventType]bool)
+		}
+		types[eventType] = true
+	}
+	return types, nil
+}
+
+func listValues(query url.Values, name string) []string {
+	var values []string
+	for _, value := range query[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
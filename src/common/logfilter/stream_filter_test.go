@@ -0,0 +1,76 @@
+package logfilter_test
+
+import (
+	"net/url"
+
+	"common/logfilter"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StreamFilter", func() {
+	parse := func(query string) *logfilter.StreamFilter {
+		values, err := url.ParseQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+
+		filter, err := logfilter.ParseStream(values)
+		Expect(err).NotTo(HaveOccurred())
+		return filter
+	}
+
+	appOut := logMessage("app out", 10, "APP", "0", events.LogMessage_OUT)
+	routerOut := logMessage("router out", 20, "RTR", "1", events.LogMessage_OUT)
+	appErr := logMessage("app err", 30, "APP", "1", events.LogMessage_ERR)
+	containerMetric := &events.Envelope{
+		Origin:    proto.String("origin"),
+		EventType: events.Envelope_ContainerMetric.Enum(),
+		ContainerMetric: &events.ContainerMetric{
+			ApplicationId: proto.String("app-id"),
+			InstanceIndex: proto.Int32(1),
+			CpuPercentage: proto.Float64(1),
+			MemoryBytes:   proto.Uint64(1),
+			DiskBytes:     proto.Uint64(1),
+		},
+	}
+
+	It("selects everything without parameters", func() {
+		filter := parse("")
+		Expect(filter.IsEmpty()).To(BeTrue())
+		for _, envelope := range []*events.Envelope{appOut, routerOut, appErr, containerMetric} {
+			Expect(filter.Matches(envelope)).To(BeTrue())
+		}
+	})
+
+	It("selects by event type", func() {
+		filter := parse("event_type=containermetric")
+		Expect(filter.Matches(containerMetric)).To(BeTrue())
+		Expect(filter.Matches(appOut)).To(BeFalse())
+	})
+
+	It("selects log messages by source type and message type", func() {
+		filter := parse("source_type=app,stg&message_type=OUT")
+		Expect(filter.Matches(appOut)).To(BeTrue())
+		Expect(filter.Matches(routerOut)).To(BeFalse())
+		Expect(filter.Matches(appErr)).To(BeFalse())
+		Expect(filter.Matches(containerMetric)).To(BeTrue())
+	})
+
+	It("selects log messages and container metrics by instance", func() {
+		filter := parse("instance=1&instance=2")
+		Expect(filter.Matches(routerOut)).To(BeTrue())
+		Expect(filter.Matches(containerMetric)).To(BeTrue())
+		Expect(filter.Matches(appOut)).To(BeFalse())
+	})
+
+	It("rejects invalid parameters", func() {
+		for _, query := range []string{"event_type=Unknown", "message_type=DEBUG", "instance=first", "instance=-1"} {
+			values, _ := url.ParseQuery(query)
+			_, err := logfilter.ParseStream(values)
+			Expect(err).To(HaveOccurred(), query)
+		}
+	})
+})
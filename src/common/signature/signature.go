@@ -0,0 +1,43 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Size is the length of the HMAC prepended to every signed message.
+const Size = sha256.Size
+
+// A Key is a shared secret together with the identifier operators use to
+// refer to it while rotating secrets. The identifier never goes over the
+// wire, so signed messages stay compatible with components that only know
+// about a single secret.
+type Key struct {
+	Id     string
+	Secret string
+}
+
+// Sign prepends an HMAC-SHA256 of message, computed with secret.
+func Sign(message []byte, secret string) []byte {
+	return append(mac(message, secret), message...)
+}
+
+// Verify reports whether signedMessage was signed with secret and, if so,
+// returns the message without its signature.
+func Verify(signedMessage []byte, secret string) ([]byte, bool) {
+	if len(signedMessage) < Size {
+		return nil, false
+	}
+
+	message := signedMessage[Size:]
+	if !hmac.Equal(signedMessage[:Size], mac(message, secret)) {
+		return nil, false
+	}
+	return message, true
+}
+
+func mac(message []byte, secret string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(message)
+	return h.Sum(nil)
+}
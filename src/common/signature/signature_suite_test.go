@@ -0,0 +1,13 @@
+package signature_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Signature Suite")
+}
@@ -0,0 +1,38 @@
+package signature_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+
+	"common/signature"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Signature", func() {
+	It("prepends an HMAC-SHA256 of the message", func() {
+		signed := signature.Sign([]byte("message"), "secret")
+
+		h := hmac.New(sha256.New, []byte("secret"))
+		h.Write([]byte("message"))
+		Expect(signed).To(Equal(append(h.Sum(nil), []byte("message")...)))
+	})
+
+	It("verifies messages signed with the same secret", func() {
+		message, ok := signature.Verify(signature.Sign([]byte("message"), "secret"), "secret")
+
+		Expect(ok).To(BeTrue())
+		Expect(message).To(Equal([]byte("message")))
+	})
+
+	It("rejects messages signed with another secret", func() {
+		_, ok := signature.Verify(signature.Sign([]byte("message"), "secret"), "other-secret")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("rejects messages too short to carry a signature", func() {
+		_, ok := signature.Verify([]byte("short"), "secret")
+		Expect(ok).To(BeFalse())
+	})
+})
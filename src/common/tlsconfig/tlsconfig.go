@@ -0,0 +1,62 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// NewServerConfig builds a tls.Config for a listener that presents the given
+// certificate and only accepts clients whose certificates are signed by the
+// given CA.
+func NewServerConfig(certFile, keyFile, caCertFile string) (*tls.Config, error) {
+	certificate, caCertPool, err := load(certFile, keyFile, caCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    caCertPool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// NewClientConfig builds a tls.Config for a client that presents the given
+// certificate and only trusts servers whose certificates are signed by the
+// given CA.
+func NewClientConfig(certFile, keyFile, caCertFile, serverName string) (*tls.Config, error) {
+	certificate, caCertPool, err := load(certFile, keyFile, caCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      caCertPool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func load(certFile, keyFile, caCertFile string) (tls.Certificate, *x509.CertPool, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to load keypair: %s", err)
+	}
+
+	caCertBytes, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to read ca cert file: %s", err)
+	}
+
+	caCertPool := x509.NewCertPool()
+	if ok := caCertPool.AppendCertsFromPEM(caCertBytes); !ok {
+		return tls.Certificate{}, nil, errors.New("unable to load ca cert file")
+	}
+
+	return certificate, caCertPool, nil
+}
@@ -0,0 +1,48 @@
+package tlsconfig_test
+
+import (
+	"common/tlsconfig"
+	"crypto/tls"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS config", func() {
+	Describe("NewServerConfig", func() {
+		It("requires and verifies client certificates", func() {
+			config, err := tlsconfig.NewServerConfig("fixtures/doppler.crt", "fixtures/doppler.key", "fixtures/loggregator-ca.crt")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.Certificates).To(HaveLen(1))
+			Expect(config.ClientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+			Expect(config.ClientCAs).NotTo(BeNil())
+		})
+
+		It("returns an error when the keypair cannot be loaded", func() {
+			_, err := tlsconfig.NewServerConfig("fixtures/missing.crt", "fixtures/doppler.key", "fixtures/loggregator-ca.crt")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error when the CA is not a certificate", func() {
+			_, err := tlsconfig.NewServerConfig("fixtures/doppler.crt", "fixtures/doppler.key", "fixtures/doppler.key")
+			Expect(err).To(MatchError("unable to load ca cert file"))
+		})
+	})
+
+	Describe("NewClientConfig", func() {
+		It("trusts the given CA and sets the server name", func() {
+			config, err := tlsconfig.NewClientConfig("fixtures/metron.crt", "fixtures/metron.key", "fixtures/loggregator-ca.crt", "doppler")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.Certificates).To(HaveLen(1))
+			Expect(config.RootCAs).NotTo(BeNil())
+			Expect(config.ServerName).To(Equal("doppler"))
+		})
+
+		It("returns an error when the CA file is missing", func() {
+			_, err := tlsconfig.NewClientConfig("fixtures/metron.crt", "fixtures/metron.key", "fixtures/missing.crt", "doppler")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
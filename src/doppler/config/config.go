@@ -1,6 +1,7 @@
 package config
 
 import (
+	"common/signature"
 	"doppler/iprange"
 	"errors"
 	"time"
@@ -20,8 +21,13 @@ type Config struct {
 	OutgoingPort                  uint32
 	LogFilePath                   string
 	MaxRetainedLogMessages        uint32
+	RecentLogsDirectory           string
+	RecentLogsMaxBytes            int64
+	RecentLogsCompactionSeconds   uint
+	RecentLogsMaxOpenFiles        int
 	MessageDrainBufferSize        uint
 	SharedSecret                  string
+	SharedSecrets                 []signature.Key
 	SkipCertVerify                bool
 	BlackListIps                  []iprange.IPRange
 	JobName                       string
@@ -33,6 +39,16 @@ type Config struct {
 	MetronAddress                 string
 	MonitorIntervalSeconds        uint
 	SinkDialTimeoutSeconds        int
+	SlowConsumerTimeoutSeconds    uint
+	EnableTLSTransport            bool
+	TLSListenerConfig             TLSListenerConfig
+}
+
+type TLSListenerConfig struct {
+	Port     uint32
+	CertFile string
+	KeyFile  string
+	CAFile   string
 }
 
 func (c *Config) Validate(logger *gosteno.Logger) (err error) {
@@ -40,6 +56,18 @@ func (c *Config) Validate(logger *gosteno.Logger) (err error) {
 		return errors.New("Need max number of log messages to retain per application")
 	}
 
+	if c.RecentLogsDirectory != "" {
+		if c.RecentLogsMaxBytes <= 0 {
+			return errors.New("Need the maximum size of the recent logs store when it is enabled")
+		}
+		if c.RecentLogsCompactionSeconds == 0 {
+			c.RecentLogsCompactionSeconds = 60
+		}
+		if c.RecentLogsMaxOpenFiles <= 0 {
+			c.RecentLogsMaxOpenFiles = 256
+		}
+	}
+
 	if c.BlackListIps != nil {
 		err = iprange.ValidateIpAddresses(c.BlackListIps)
 		if err != nil {
@@ -47,6 +75,27 @@ func (c *Config) Validate(logger *gosteno.Logger) (err error) {
 		}
 	}
 
+	if c.EnableTLSTransport {
+		if c.TLSListenerConfig.CertFile == "" || c.TLSListenerConfig.KeyFile == "" || c.TLSListenerConfig.CAFile == "" {
+			return errors.New("Need cert, key and ca files when TLS transport is enabled")
+		}
+		if c.TLSListenerConfig.Port == 0 {
+			return errors.New("Need a port for the TLS listener when TLS transport is enabled")
+		}
+	}
+
+	keyIds := make(map[string]bool)
+	for _, key := range c.SharedSecretKeys() {
+		if key.Id == "" || keyIds[key.Id] {
+			return errors.New("Need a unique id for each shared secret")
+		}
+		keyIds[key.Id] = true
+	}
+
+	if c.SlowConsumerTimeoutSeconds == 0 {
+		c.SlowConsumerTimeoutSeconds = 5
+	}
+
 	if c.UnmarshallerCount == 0 {
 		c.UnmarshallerCount = 1
 	}
@@ -58,3 +107,12 @@ func (c *Config) Validate(logger *gosteno.Logger) (err error) {
 	err = c.Config.Validate(logger)
 	return
 }
+
+// SharedSecretKeys returns the secrets Doppler accepts signatures from. A
+// lone SharedSecret is treated as a list with a single "default" key.
+func (c *Config) SharedSecretKeys() []signature.Key {
+	if len(c.SharedSecrets) == 0 && c.SharedSecret != "" {
+		return []signature.Key{{Id: "default", Secret: c.SharedSecret}}
+	}
+	return c.SharedSecrets
+}
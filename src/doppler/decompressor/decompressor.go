@@ -0,0 +1,76 @@
+package decompressor
+
+import (
+	"sync/atomic"
+
+	"common/compression"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// A Decompressor restores messages that Metron compressed before signing
+// them. Messages that are not compressed are passed through untouched so
+// that Metrons without compression keep working.
+type Decompressor struct {
+	logger *gosteno.Logger
+
+	decompressedMessageCount uint64
+	invalidMessageCount      uint64
+	compressedByteCount      uint64
+	decompressedByteCount    uint64
+}
+
+func New(logger *gosteno.Logger) *Decompressor {
+	return &Decompressor{
+		logger: logger,
+	}
+}
+
+func (d *Decompressor) Run(inputChan <-chan []byte, outputChan chan<- []byte) {
+	for data := range inputChan {
+		if !compression.IsCompressed(data) {
+			outputChan <- data
+			continue
+		}
+
+		message, err := compression.Decompress(data)
+		if err != nil {
+			atomic.AddUint64(&d.invalidMessageCount, 1)
+			metrics.BatchIncrementCounter("decompressor.invalidMessages")
+			d.logger.Warnf("Decompressor: can't decompress message of %d bytes: %s", len(data), err)
+			continue
+		}
+
+		atomic.AddUint64(&d.decompressedMessageCount, 1)
+		atomic.AddUint64(&d.compressedByteCount, uint64(len(data)))
+		atomic.AddUint64(&d.decompressedByteCount, uint64(len(message)))
+		metrics.BatchIncrementCounter("decompressor.decompressedMessages")
+		metrics.BatchAddCounter("decompressor.compressedBytes", uint64(len(data)))
+		metrics.BatchAddCounter("decompressor.decompressedBytes", uint64(len(message)))
+
+		outputChan <- message
+	}
+}
+
+func (d *Decompressor) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "decompressor",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "decompressedMessages", Value: atomic.LoadUint64(&d.decompressedMessageCount)},
+			instrumentation.Metric{Name: "invalidMessages", Value: atomic.LoadUint64(&d.invalidMessageCount)},
+			instrumentation.Metric{Name: "compressedBytes", Value: atomic.LoadUint64(&d.compressedByteCount)},
+			instrumentation.Metric{Name: "decompressedBytes", Value: atomic.LoadUint64(&d.decompressedByteCount)},
+			instrumentation.Metric{Name: "compressionRatio", Value: d.compressionRatio()},
+		},
+	}
+}
+
+func (d *Decompressor) compressionRatio() float64 {
+	compressed := atomic.LoadUint64(&d.compressedByteCount)
+	if compressed == 0 {
+		return 1
+	}
+	return float64(atomic.LoadUint64(&d.decompressedByteCount)) / float64(compressed)
+}
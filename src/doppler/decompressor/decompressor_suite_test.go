@@ -0,0 +1,13 @@
+package decompressor_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDecompressor(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Decompressor Suite")
+}
@@ -0,0 +1,58 @@
+package decompressor_test
+
+import (
+	"bytes"
+
+	"common/compression"
+	"doppler/decompressor"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decompressor", func() {
+	var (
+		d          *decompressor.Decompressor
+		inputChan  chan []byte
+		outputChan chan []byte
+	)
+
+	BeforeEach(func() {
+		d = decompressor.New(loggertesthelper.Logger())
+		inputChan = make(chan []byte, 10)
+		outputChan = make(chan []byte, 10)
+		go d.Run(inputChan, outputChan)
+	})
+
+	AfterEach(func() {
+		close(inputChan)
+	})
+
+	It("passes through messages that are not compressed", func() {
+		inputChan <- []byte("plain message")
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("plain message"))))
+	})
+
+	It("decompresses compressed messages", func() {
+		message := bytes.Repeat([]byte("log line "), 100)
+		compressor, err := compression.NewCompressor(compression.Deflate)
+		Expect(err).NotTo(HaveOccurred())
+		inputChan <- compressor.Compress(message)
+
+		Eventually(outputChan).Should(Receive(Equal(message)))
+		testhelpers.EventuallyExpectMetric(d, "decompressedMessages", 1)
+		testhelpers.EventuallyExpectMetric(d, "decompressedBytes", uint64(len(message)))
+	})
+
+	It("drops messages that can't be decompressed", func() {
+		inputChan <- append(append([]byte{}, compression.Header...), 'g', 0x01, 0x02)
+		inputChan <- []byte("next message")
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("next message"))))
+		testhelpers.EventuallyExpectMetric(d, "invalidMessages", 1)
+	})
+})
@@ -6,15 +6,21 @@ import (
 	"time"
 
 	"doppler/config"
+	"doppler/decompressor"
+	"doppler/groupedsinks/firehose_group"
+	"doppler/listeners"
+	"doppler/logstore"
+	"doppler/signatureverifier"
 	"doppler/sinkserver"
 	"doppler/sinkserver/blacklist"
 	"doppler/sinkserver/sinkmanager"
 	"doppler/sinkserver/websocketserver"
+	"doppler/unbatcher"
 
 	"common/monitor"
+	"common/tlsconfig"
 
 	"github.com/cloudfoundry/dropsonde/dropsonde_unmarshaller"
-	"github.com/cloudfoundry/dropsonde/signature"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/agentlistener"
 	"github.com/cloudfoundry/loggregatorlib/appservice"
@@ -31,27 +37,36 @@ type Doppler struct {
 
 	errChan           chan error
 	dropsondeListener agentlistener.AgentListener
+	tlsListener       *listeners.TLSListener
 	sinkManager       *sinkmanager.SinkManager
+	recentLogsStore   *logstore.Store
 	messageRouter     *sinkserver.MessageRouter
 	websocketServer   *websocketserver.WebsocketServer
 
 	dropsondeUnmarshallerCollection dropsonde_unmarshaller.DropsondeUnmarshallerCollection
 	dropsondeBytesChan              <-chan []byte
+	unbatchedBytesChan              chan []byte
 	dropsondeVerifiedBytesChan      chan []byte
+	decompressedBytesChan           chan []byte
 	envelopeChan                    chan *events.Envelope
 	wrappedEnvelopeChan             chan *events.Envelope
-	signatureVerifier               *signature.Verifier
+	signatureVerifier               *signatureverifier.Verifier
+	unbatcher                       *unbatcher.Unbatcher
+	decompressor                    *decompressor.Decompressor
 
 	storeAdapter storeadapter.StoreAdapter
 
 	uptimeMonitor monitor.Monitor
 
+	recentLogsCompactionInterval time.Duration
+
 	newAppServiceChan, deletedAppServiceChan <-chan appservice.AppService
 	wg                                       sync.WaitGroup
 }
 
-func New(host string, config *config.Config, logger *gosteno.Logger, storeAdapter storeadapter.StoreAdapter, messageDrainBufferSize uint, dropsondeOrigin string, dialTimeout time.Duration) *Doppler {
+func New(host string, config *config.Config, logger *gosteno.Logger, storeAdapter storeadapter.StoreAdapter, messageDrainBufferSize uint, dropsondeOrigin string, dialTimeout time.Duration) (*Doppler, error) {
 	cfcomponent.Logger = logger
+	firehose_group.SlowConsumerTimeout = time.Duration(config.SlowConsumerTimeoutSeconds) * time.Second
 	keepAliveInterval := 30 * time.Second
 
 	appStoreCache := cache.NewAppServiceCache()
@@ -59,7 +74,7 @@ func New(host string, config *config.Config, logger *gosteno.Logger, storeAdapte
 
 	dropsondeListener, dropsondeBytesChan := agentlistener.NewAgentListener(fmt.Sprintf("%s:%d", host, config.DropsondeIncomingMessagesPort), logger, "dropsondeListener")
 
-	signatureVerifier := signature.NewVerifier(logger, config.SharedSecret)
+	signatureVerifier := signatureverifier.New(config.SharedSecretKeys(), logger)
 
 	unmarshallerCollection := dropsonde_unmarshaller.NewDropsondeUnmarshallerCollection(logger, config.UnmarshallerCount)
 
@@ -67,12 +82,38 @@ func New(host string, config *config.Config, logger *gosteno.Logger, storeAdapte
 	metricTTL := time.Duration(config.ContainerMetricTTLSeconds) * time.Second
 	sinkTimeout := time.Duration(config.SinkInactivityTimeoutSeconds) * time.Second
 	sinkIOTimeout := time.Duration(config.SinkIOTimeoutSeconds) * time.Second
-	sinkManager := sinkmanager.New(config.MaxRetainedLogMessages, config.SkipCertVerify, blacklist, logger, messageDrainBufferSize, dropsondeOrigin, sinkTimeout, sinkIOTimeout, metricTTL, dialTimeout)
+
+	var recentLogsStore *logstore.Store
+	var sinkManagerStore sinkmanager.RecentLogsStore
+	if config.RecentLogsDirectory != "" {
+		var err error
+		recentLogsStore, err = logstore.New(config.RecentLogsDirectory, int(config.MaxRetainedLogMessages), config.RecentLogsMaxBytes, config.RecentLogsMaxOpenFiles, logger)
+		if err != nil {
+			return nil, err
+		}
+		sinkManagerStore = recentLogsStore
+	}
+
+	sinkManager := sinkmanager.New(config.MaxRetainedLogMessages, config.SkipCertVerify, blacklist, logger, messageDrainBufferSize, dropsondeOrigin, sinkTimeout, sinkIOTimeout, metricTTL, dialTimeout, sinkManagerStore)
+
+	envelopeChan := make(chan *events.Envelope)
+
+	var tlsListener *listeners.TLSListener
+	if config.EnableTLSTransport {
+		tlsConfig, err := tlsconfig.NewServerConfig(config.TLSListenerConfig.CertFile, config.TLSListenerConfig.KeyFile, config.TLSListenerConfig.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsListener = listeners.NewTLSListener("tlsListener", fmt.Sprintf("%s:%d", host, config.TLSListenerConfig.Port), tlsConfig, envelopeChan, logger)
+	}
 
 	return &Doppler{
 		Logger:                          logger,
 		dropsondeListener:               dropsondeListener,
+		tlsListener:                     tlsListener,
 		sinkManager:                     sinkManager,
+		recentLogsStore:                 recentLogsStore,
+		recentLogsCompactionInterval:    time.Duration(config.RecentLogsCompactionSeconds) * time.Second,
 		messageRouter:                   sinkserver.NewMessageRouter(sinkManager, logger),
 		websocketServer:                 websocketserver.New(fmt.Sprintf("%s:%d", host, config.OutgoingPort), sinkManager, keepAliveInterval, config.MessageDrainBufferSize, dropsondeOrigin, logger),
 		newAppServiceChan:               newAppServiceChan,
@@ -80,19 +121,23 @@ func New(host string, config *config.Config, logger *gosteno.Logger, storeAdapte
 		appStoreWatcher:                 appStoreWatcher,
 		storeAdapter:                    storeAdapter,
 		dropsondeBytesChan:              dropsondeBytesChan,
+		unbatchedBytesChan:              make(chan []byte),
+		unbatcher:                       unbatcher.New(logger),
 		dropsondeUnmarshallerCollection: unmarshallerCollection,
-		envelopeChan:                    make(chan *events.Envelope),
+		envelopeChan:                    envelopeChan,
 		wrappedEnvelopeChan:             make(chan *events.Envelope),
 		signatureVerifier:               signatureVerifier,
 		dropsondeVerifiedBytesChan:      make(chan []byte),
+		decompressor:                    decompressor.New(logger),
+		decompressedBytesChan:           make(chan []byte),
 		uptimeMonitor:                   monitor.NewUptimeMonitor(time.Duration(config.MonitorIntervalSeconds) * time.Second),
-	}
+	}, nil
 }
 
 func (doppler *Doppler) Start() {
 	doppler.errChan = make(chan error)
 
-	doppler.wg.Add(6 + doppler.dropsondeUnmarshallerCollection.Size())
+	doppler.wg.Add(8 + doppler.dropsondeUnmarshallerCollection.Size())
 
 	go func() {
 		defer doppler.wg.Done()
@@ -104,12 +149,32 @@ func (doppler *Doppler) Start() {
 		doppler.dropsondeListener.Start()
 	}()
 
-	doppler.dropsondeUnmarshallerCollection.Run(doppler.dropsondeVerifiedBytesChan, doppler.envelopeChan, &doppler.wg)
+	if doppler.tlsListener != nil {
+		doppler.wg.Add(1)
+		go func() {
+			defer doppler.wg.Done()
+			doppler.tlsListener.Start()
+		}()
+	}
+
+	doppler.dropsondeUnmarshallerCollection.Run(doppler.decompressedBytesChan, doppler.envelopeChan, &doppler.wg)
+
+	go func() {
+		defer doppler.wg.Done()
+		defer close(doppler.unbatchedBytesChan)
+		doppler.unbatcher.Run(doppler.dropsondeBytesChan, doppler.unbatchedBytesChan)
+	}()
 
 	go func() {
 		defer doppler.wg.Done()
 		defer close(doppler.dropsondeVerifiedBytesChan)
-		doppler.signatureVerifier.Run(doppler.dropsondeBytesChan, doppler.dropsondeVerifiedBytesChan)
+		doppler.signatureVerifier.Run(doppler.unbatchedBytesChan, doppler.dropsondeVerifiedBytesChan)
+	}()
+
+	go func() {
+		defer doppler.wg.Done()
+		defer close(doppler.decompressedBytesChan)
+		doppler.decompressor.Run(doppler.dropsondeVerifiedBytesChan, doppler.decompressedBytesChan)
 	}()
 
 	go func() {
@@ -128,6 +193,14 @@ func (doppler *Doppler) Start() {
 		doppler.websocketServer.Start()
 	}()
 
+	if doppler.recentLogsStore != nil {
+		doppler.wg.Add(1)
+		go func() {
+			defer doppler.wg.Done()
+			doppler.recentLogsStore.Run(doppler.recentLogsCompactionInterval)
+		}()
+	}
+
 	go doppler.uptimeMonitor.Start()
 
 	// The following runs forever. Put all startup functions above here.
@@ -138,10 +211,17 @@ func (doppler *Doppler) Start() {
 
 func (doppler *Doppler) Stop() {
 	doppler.dropsondeListener.Stop()
+	if doppler.tlsListener != nil {
+		doppler.tlsListener.Stop()
+	}
 	doppler.sinkManager.Stop()
 	doppler.messageRouter.Stop()
 	doppler.websocketServer.Stop()
 	doppler.storeAdapter.Disconnect()
+	// sinkManager.Stop has waited for the queued recent logs to be written.
+	if doppler.recentLogsStore != nil {
+		doppler.recentLogsStore.Stop()
+	}
 
 	doppler.wg.Wait()
 	close(doppler.errChan)
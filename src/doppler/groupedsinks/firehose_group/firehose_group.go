@@ -4,10 +4,16 @@ import (
 	"doppler/groupedsinks/sink_wrapper"
 	"doppler/sinks"
 	"sync"
+	"time"
 
+	"github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/cloudfoundry/sonde-go/events"
 )
 
+// SlowConsumerTimeout is how long a sink's input channel may stay full before
+// the group considers its consumer too slow and disconnects it.
+var SlowConsumerTimeout = 5 * time.Second
+
 type FirehoseGroup interface {
 	AddSink(sink sinks.Sink, in chan<- *events.Envelope) bool
 	Exists(sink sinks.Sink) bool
@@ -17,23 +23,48 @@ type FirehoseGroup interface {
 	BroadcastMessage(msg *events.Envelope)
 }
 
+// A slowConsumerSink is told when the group gives up on its consumer, so that
+// it can disconnect it.
+type slowConsumerSink interface {
+	DisconnectSlowConsumer()
+}
+
+// A droppedMessagesSink is told how many messages the group dropped because
+// all of its sinks were full, so that it can let its consumer know.
+type droppedMessagesSink interface {
+	NotifyDroppedMessages(count uint64)
+}
+
+type firehoseSink struct {
+	*sink_wrapper.SinkWrapper
+	fullSince time.Time
+	slow      bool
+}
+
+// firehoseGroup sends each message to one of its sinks, picking the one with
+// the most room left in its input channel. It never blocks: when every sink
+// is full, the message is dropped. A sink whose input channel stays full for
+// longer than SlowConsumerTimeout no longer gets messages and is told to
+// disconnect its consumer. The sink that gets the next message after some
+// were dropped is told how many.
 type firehoseGroup struct {
-	sinkWrappers      []*sink_wrapper.SinkWrapper
+	sinks             []*firehoseSink
 	lastUsedSinkIndex int
+	droppedMessages   uint64
 	sync.RWMutex
 }
 
 func NewFirehoseGroup() *firehoseGroup {
 	return &firehoseGroup{
-		sinkWrappers: make([]*sink_wrapper.SinkWrapper, 0),
+		sinks: make([]*firehoseSink, 0),
 	}
 }
 
 func (group *firehoseGroup) Exists(sink sinks.Sink) bool {
-	group.Lock()
-	defer group.Unlock()
-	for _, sinkWrapper := range group.sinkWrappers {
-		if sink.Identifier() == sinkWrapper.Sink.Identifier() {
+	group.RLock()
+	defer group.RUnlock()
+	for _, fsink := range group.sinks {
+		if sink.Identifier() == fsink.Sink.Identifier() {
 			return true
 		}
 	}
@@ -49,19 +80,19 @@ func (group *firehoseGroup) AddSink(sink sinks.Sink, in chan<- *events.Envelope)
 	defer group.Unlock()
 
 	sinkWrapper := sink_wrapper.SinkWrapper{InputChan: in, Sink: sink}
-	group.sinkWrappers = append(group.sinkWrappers, &sinkWrapper)
+	group.sinks = append(group.sinks, &firehoseSink{SinkWrapper: &sinkWrapper})
 	return true
 }
 
-func (group *firehoseGroup) RemoveSink(fsink sinks.Sink) bool {
-	for i, sinkWrapper := range group.sinkWrappers {
-		if sinkWrapper.Sink == fsink {
-			group.Lock()
-			defer group.Unlock()
+func (group *firehoseGroup) RemoveSink(sink sinks.Sink) bool {
+	group.Lock()
+	defer group.Unlock()
 
-			close(sinkWrapper.InputChan)
-			s := group.sinkWrappers
-			group.sinkWrappers = s[:i+copy(s[i:], s[i+1:])]
+	for i, fsink := range group.sinks {
+		if fsink.Sink == sink {
+			close(fsink.InputChan)
+			s := group.sinks
+			group.sinks = s[:i+copy(s[i:], s[i+1:])]
 
 			return true
 		}
@@ -71,9 +102,13 @@ func (group *firehoseGroup) RemoveSink(fsink sinks.Sink) bool {
 }
 
 func (group *firehoseGroup) RemoveAllSinks() {
-	for _, sinkWrapper := range group.sinkWrappers {
-		group.RemoveSink(sinkWrapper.Sink)
+	group.Lock()
+	defer group.Unlock()
+
+	for _, fsink := range group.sinks {
+		close(fsink.InputChan)
 	}
+	group.sinks = group.sinks[:0]
 }
 
 func (group *firehoseGroup) IsEmpty() bool {
@@ -84,20 +119,86 @@ func (group *firehoseGroup) BroadcastMessage(msg *events.Envelope) {
 	group.Lock()
 	defer group.Unlock()
 
-	l := len(group.sinkWrappers)
-	lastUsed := group.lastUsedSinkIndex
-	if lastUsed >= l {
-		group.lastUsedSinkIndex = 0
+	l := len(group.sinks)
+	if l == 0 {
+		return
+	}
+
+	now := time.Now()
+	chosen := -1
+	mostRoom := 0
+	// Start after the last used sink, so that sinks with equal room take
+	// turns.
+	for i := 1; i <= l; i++ {
+		index := (group.lastUsedSinkIndex + i) % l
+		fsink := group.sinks[index]
+		if fsink.slow {
+			continue
+		}
+
+		room := cap(fsink.InputChan) - len(fsink.InputChan)
+		if room > 0 {
+			fsink.fullSince = time.Time{}
+		} else if fsink.fullSince.IsZero() {
+			fsink.fullSince = now
+		} else if now.Sub(fsink.fullSince) > SlowConsumerTimeout {
+			group.disconnectSlowConsumer(fsink)
+			continue
+		}
+
+		if room > mostRoom {
+			chosen = index
+			mostRoom = room
+		}
+	}
+
+	if chosen < 0 {
+		group.dropMessage()
+		return
+	}
+
+	fsink := group.sinks[chosen]
+	select {
+	case fsink.InputChan <- msg:
+		group.notifyDroppedMessages(fsink)
+	default:
+		group.dropMessage()
 	}
+	group.lastUsedSinkIndex = chosen
+}
 
-	group.sinkWrappers[group.lastUsedSinkIndex].InputChan <- msg
+// dropMessage must be called with the lock held.
+func (group *firehoseGroup) dropMessage() {
+	group.droppedMessages++
+	metrics.BatchIncrementCounter("firehoseGroup.droppedMessages")
+}
 
-	group.lastUsedSinkIndex += 1
+// notifyDroppedMessages must be called with the lock held.
+func (group *firehoseGroup) notifyDroppedMessages(fsink *firehoseSink) {
+	if group.droppedMessages == 0 {
+		return
+	}
+
+	if sink, ok := fsink.Sink.(droppedMessagesSink); ok {
+		sink.NotifyDroppedMessages(group.droppedMessages)
+	}
+	group.droppedMessages = 0
+}
+
+// disconnectSlowConsumer must be called with the lock held. The sink stays in
+// the group until it is removed, which closes its input channel.
+func (group *firehoseGroup) disconnectSlowConsumer(fsink *firehoseSink) {
+	fsink.slow = true
+	metrics.BatchIncrementCounter("firehoseGroup.slowConsumers")
+
+	if sink, ok := fsink.Sink.(slowConsumerSink); ok {
+		sink.DisconnectSlowConsumer()
+	}
 }
 
 func (group *firehoseGroup) length() int {
 	group.RLock()
 	defer group.RUnlock()
 
-	return len(group.sinkWrappers)
+	return len(group.sinks)
 }
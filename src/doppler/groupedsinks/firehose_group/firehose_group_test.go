@@ -2,6 +2,7 @@ package firehose_group_test
 
 import (
 	"doppler/sinks"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde/emitter"
 	"github.com/cloudfoundry/dropsonde/factories"
@@ -14,8 +15,18 @@ import (
 )
 
 type fakeSink struct {
-	sinkId string
-	appId  string
+	sinkId          string
+	appId           string
+	disconnected    bool
+	droppedMessages uint64
+}
+
+func (f *fakeSink) NotifyDroppedMessages(count uint64) {
+	f.droppedMessages += count
+}
+
+func (f *fakeSink) DisconnectSlowConsumer() {
+	f.disconnected = true
 }
 
 func (f *fakeSink) StreamId() string {
@@ -92,6 +103,128 @@ var _ = Describe("FirehoseGroup", func() {
 		Expect(receiveChan1).To(Receive(&msg))
 	})
 
+	It("does nothing when the group is empty", func() {
+		group := firehose_group.NewFirehoseGroup()
+
+		msg, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "test message", "234", "App"), "origin")
+		Expect(func() { group.BroadcastMessage(msg) }).NotTo(Panic())
+	})
+
+	It("sends messages to the sink with the most room", func() {
+		receiveChan1 := make(chan *events.Envelope, 10)
+		receiveChan2 := make(chan *events.Envelope, 10)
+
+		group := firehose_group.NewFirehoseGroup()
+		group.AddSink(&fakeSink{appId: "firehose-a", sinkId: "sink-a"}, receiveChan1)
+		group.AddSink(&fakeSink{appId: "firehose-a", sinkId: "sink-b"}, receiveChan2)
+
+		msg, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "test message", "234", "App"), "origin")
+		for i := 0; i < 5; i++ {
+			receiveChan1 <- msg
+		}
+
+		for i := 0; i < 4; i++ {
+			group.BroadcastMessage(msg)
+		}
+
+		Expect(receiveChan1).To(HaveLen(5))
+		Expect(receiveChan2).To(HaveLen(4))
+	})
+
+	It("drops messages instead of blocking when every sink is full", func(done Done) {
+		defer close(done)
+		receiveChan := make(chan *events.Envelope, 1)
+
+		group := firehose_group.NewFirehoseGroup()
+		group.AddSink(&fakeSink{appId: "firehose-a", sinkId: "sink-a"}, receiveChan)
+
+		msg, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "test message", "234", "App"), "origin")
+		group.BroadcastMessage(msg)
+		group.BroadcastMessage(msg)
+
+		Expect(receiveChan).To(HaveLen(1))
+	})
+
+	It("tells the sink that gets the next message how many messages were dropped", func() {
+		receiveChan := make(chan *events.Envelope, 1)
+		sink := &fakeSink{appId: "firehose-a", sinkId: "sink-a"}
+
+		group := firehose_group.NewFirehoseGroup()
+		group.AddSink(sink, receiveChan)
+
+		msg, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "test message", "234", "App"), "origin")
+		group.BroadcastMessage(msg)
+		group.BroadcastMessage(msg)
+		group.BroadcastMessage(msg)
+		Expect(sink.droppedMessages).To(BeZero())
+
+		<-receiveChan
+		group.BroadcastMessage(msg)
+		Expect(sink.droppedMessages).To(BeEquivalentTo(2))
+
+		<-receiveChan
+		group.BroadcastMessage(msg)
+		Expect(sink.droppedMessages).To(BeEquivalentTo(2))
+	})
+
+	Context("with a slow consumer", func() {
+		var originalTimeout time.Duration
+
+		BeforeEach(func() {
+			originalTimeout = firehose_group.SlowConsumerTimeout
+			firehose_group.SlowConsumerTimeout = 10 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			firehose_group.SlowConsumerTimeout = originalTimeout
+		})
+
+		It("disconnects a sink that stays full and stops sending to it", func() {
+			slowChan := make(chan *events.Envelope, 1)
+			fastChan := make(chan *events.Envelope, 10)
+			slowSink := &fakeSink{appId: "firehose-a", sinkId: "slow"}
+
+			group := firehose_group.NewFirehoseGroup()
+			group.AddSink(slowSink, slowChan)
+			group.AddSink(&fakeSink{appId: "firehose-a", sinkId: "fast"}, fastChan)
+
+			msg, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "test message", "234", "App"), "origin")
+			slowChan <- msg
+			group.BroadcastMessage(msg)
+			Expect(slowSink.disconnected).To(BeFalse())
+
+			time.Sleep(20 * time.Millisecond)
+			group.BroadcastMessage(msg)
+			Expect(slowSink.disconnected).To(BeTrue())
+
+			<-slowChan
+			for i := 0; i < 3; i++ {
+				group.BroadcastMessage(msg)
+			}
+			Expect(slowChan).To(BeEmpty())
+			Expect(fastChan).To(HaveLen(5))
+		})
+
+		It("keeps a sink that catches up", func() {
+			receiveChan := make(chan *events.Envelope, 1)
+			sink := &fakeSink{appId: "firehose-a", sinkId: "sink-a"}
+
+			group := firehose_group.NewFirehoseGroup()
+			group.AddSink(sink, receiveChan)
+
+			msg, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "test message", "234", "App"), "origin")
+			group.BroadcastMessage(msg)
+			group.BroadcastMessage(msg)
+
+			time.Sleep(20 * time.Millisecond)
+			<-receiveChan
+			group.BroadcastMessage(msg)
+
+			Expect(sink.disconnected).To(BeFalse())
+			Expect(receiveChan).To(HaveLen(1))
+		})
+	})
+
 	Describe("IsEmpty", func() {
 		It("is true when the group is empty", func() {
 			group := firehose_group.NewFirehoseGroup()
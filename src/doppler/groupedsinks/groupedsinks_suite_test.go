@@ -25,6 +25,10 @@ func (fake *fakeMessageWriter) WriteMessage(messageType int, data []byte) error
 	return nil
 }
 
+func (fake *fakeMessageWriter) Close() error {
+	return nil
+}
+
 type fakeAddr struct {
 	remoteAddress string
 }
@@ -0,0 +1,226 @@
+package listeners
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"common/compression"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+const maxFrameSize = 65535
+
+// A TLSListener accepts mutually authenticated TLS connections from Metron and
+// reads length-prefixed dropsonde envelopes from them. The connection is
+// authenticated, so envelopes are not expected to carry a signature. Envelopes
+// Metron compressed are decompressed.
+type TLSListener struct {
+	address      string
+	tlsConfig    *tls.Config
+	envelopeChan chan<- *events.Envelope
+	contextName  string
+	logger       *gosteno.Logger
+
+	listener    net.Listener
+	connections map[net.Conn]struct{}
+	lock        sync.Mutex
+	wg          sync.WaitGroup
+	stopChan    chan struct{}
+	doneChan    chan struct{}
+
+	receivedMessageCount uint64
+	receivedByteCount    uint64
+	receiveErrorCount    uint64
+}
+
+func NewTLSListener(contextName string, address string, tlsConfig *tls.Config, envelopeChan chan<- *events.Envelope, logger *gosteno.Logger) *TLSListener {
+	return &TLSListener{
+		address:      address,
+		tlsConfig:    tlsConfig,
+		envelopeChan: envelopeChan,
+		contextName:  contextName,
+		logger:       logger,
+		connections:  make(map[net.Conn]struct{}),
+		stopChan:     make(chan struct{}),
+		doneChan:     make(chan struct{}),
+	}
+}
+
+func (t *TLSListener) Start() {
+	listener, err := tls.Listen("tcp", t.address, t.tlsConfig)
+	if err != nil {
+		t.logger.Fatalf("TLSListener: failed to listen on %s: %s", t.address, err)
+		return
+	}
+
+	t.lock.Lock()
+	select {
+	case <-t.stopChan:
+		t.lock.Unlock()
+		listener.Close()
+		return
+	default:
+	}
+	t.listener = listener
+	t.lock.Unlock()
+	defer close(t.doneChan)
+
+	t.logger.Infof("TLSListener: listening on %s", t.address)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.logger.Debugf("TLSListener: stopped accepting connections: %s", err)
+			break
+		}
+
+		if !t.addConnection(conn) {
+			conn.Close()
+			continue
+		}
+		go t.handleConnection(conn)
+	}
+
+	t.wg.Wait()
+}
+
+// Stop closes the listener and every connection and, if the listener was
+// started, waits until its connection handlers have returned. Envelopes read
+// but not yet taken from the envelope channel are dropped, so the channel may
+// be closed once Stop returns.
+func (t *TLSListener) Stop() {
+	t.lock.Lock()
+	select {
+	case <-t.stopChan:
+	default:
+		close(t.stopChan)
+	}
+	started := t.listener != nil
+	if started {
+		t.listener.Close()
+	}
+
+	for conn := range t.connections {
+		conn.Close()
+	}
+	t.lock.Unlock()
+
+	if started {
+		<-t.doneChan
+	}
+}
+
+func (t *TLSListener) Address() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.listener == nil {
+		return ""
+	}
+	return t.listener.Addr().String()
+}
+
+func (t *TLSListener) handleConnection(conn net.Conn) {
+	defer t.wg.Done()
+	defer t.removeConnection(conn)
+	defer conn.Close()
+
+	t.logger.Debugf("TLSListener: accepted connection from %s", conn.RemoteAddr())
+
+	for {
+		var size uint32
+		err := binary.Read(conn, binary.LittleEndian, &size)
+		if err != nil {
+			if err != io.EOF {
+				t.logger.Debugf("TLSListener: error reading frame size from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		if size > maxFrameSize {
+			t.logger.Warnf("TLSListener: frame of %d bytes from %s exceeds maximum, closing connection", size, conn.RemoteAddr())
+			t.incrementReceiveErrors()
+			return
+		}
+
+		buffer := make([]byte, size)
+		if _, err := io.ReadFull(conn, buffer); err != nil {
+			t.logger.Debugf("TLSListener: error reading frame from %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		atomic.AddUint64(&t.receivedMessageCount, 1)
+		atomic.AddUint64(&t.receivedByteCount, uint64(size))
+		metrics.BatchIncrementCounter(t.contextName + ".receivedMessageCount")
+		metrics.BatchAddCounter(t.contextName+".receivedByteCount", uint64(size))
+
+		if compression.IsCompressed(buffer) {
+			buffer, err = compression.Decompress(buffer)
+			if err != nil {
+				t.logger.Debugf("TLSListener: decompression error %v from %s", err, conn.RemoteAddr())
+				t.incrementReceiveErrors()
+				continue
+			}
+		}
+
+		envelope := &events.Envelope{}
+		if err := proto.Unmarshal(buffer, envelope); err != nil {
+			t.logger.Debugf("TLSListener: unmarshal error %v from %s", err, conn.RemoteAddr())
+			t.incrementReceiveErrors()
+			continue
+		}
+
+		select {
+		case t.envelopeChan <- envelope:
+		case <-t.stopChan:
+			return
+		}
+	}
+}
+
+// addConnection tracks a new connection and reports whether it may be read
+// from, which it may not once the listener is stopped.
+func (t *TLSListener) addConnection(conn net.Conn) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	select {
+	case <-t.stopChan:
+		return false
+	default:
+	}
+	t.connections[conn] = struct{}{}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *TLSListener) removeConnection(conn net.Conn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.connections, conn)
+}
+
+func (t *TLSListener) incrementReceiveErrors() {
+	atomic.AddUint64(&t.receiveErrorCount, 1)
+	metrics.BatchIncrementCounter(t.contextName + ".receiveErrors")
+}
+
+func (t *TLSListener) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: t.contextName,
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "receivedMessageCount", Value: atomic.LoadUint64(&t.receivedMessageCount)},
+			instrumentation.Metric{Name: "receivedByteCount", Value: atomic.LoadUint64(&t.receivedByteCount)},
+			instrumentation.Metric{Name: "receiveErrors", Value: atomic.LoadUint64(&t.receiveErrorCount)},
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package listeners_test
+
+import (
+	"common/compression"
+	"common/tlsconfig"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+
+	"doppler/listeners"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLSListener", func() {
+	var (
+		listener     *listeners.TLSListener
+		envelopeChan chan *events.Envelope
+		clientTLS    *tls.Config
+		stopped      chan struct{}
+	)
+
+	BeforeEach(func() {
+		serverTLS, err := tlsconfig.NewServerConfig("../../common/tlsconfig/fixtures/doppler.crt", "../../common/tlsconfig/fixtures/doppler.key", "../../common/tlsconfig/fixtures/loggregator-ca.crt")
+		Expect(err).NotTo(HaveOccurred())
+
+		clientTLS, err = tlsconfig.NewClientConfig("../../common/tlsconfig/fixtures/metron.crt", "../../common/tlsconfig/fixtures/metron.key", "../../common/tlsconfig/fixtures/loggregator-ca.crt", "doppler")
+		Expect(err).NotTo(HaveOccurred())
+
+		envelopeChan = make(chan *events.Envelope, 10)
+		listener = listeners.NewTLSListener("tlsListener", "127.0.0.1:0", serverTLS, envelopeChan, loggertesthelper.Logger())
+
+		stopped = make(chan struct{})
+		go func() {
+			listener.Start()
+			close(stopped)
+		}()
+		Eventually(listener.Address).ShouldNot(BeEmpty())
+	})
+
+	AfterEach(func() {
+		listener.Stop()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("reads length-prefixed envelopes onto the envelope channel", func() {
+		conn, err := tls.Dial("tcp", listener.Address(), clientTLS)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "app-id", "App"), "origin")
+		writeFrame(conn, envelope)
+
+		var received *events.Envelope
+		Eventually(envelopeChan).Should(Receive(&received))
+		Expect(received).To(Equal(envelope))
+		testhelpers.EventuallyExpectMetric(listener, "receivedMessageCount", 1)
+	})
+
+	It("stops without waiting for room on a full envelope channel", func(done Done) {
+		defer close(done)
+
+		conn, err := tls.Dial("tcp", listener.Address(), clientTLS)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "app-id", "App"), "origin")
+		for i := 0; i < cap(envelopeChan)+1; i++ {
+			writeFrame(conn, envelope)
+		}
+		testhelpers.EventuallyExpectMetric(listener, "receivedMessageCount", uint64(cap(envelopeChan)+1))
+
+		listener.Stop()
+		Eventually(stopped).Should(BeClosed())
+
+		close(envelopeChan)
+		Consistently(stopped).Should(BeClosed())
+	})
+
+	It("decompresses compressed envelopes", func() {
+		conn, err := tls.Dial("tcp", listener.Address(), clientTLS)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "app-id", "App"), "origin")
+		data, err := proto.Marshal(envelope)
+		Expect(err).NotTo(HaveOccurred())
+		compressor, err := compression.NewCompressor(compression.Gzip)
+		Expect(err).NotTo(HaveOccurred())
+		compressed := compressor.Compress(data)
+		binary.Write(conn, binary.LittleEndian, uint32(len(compressed)))
+		conn.Write(compressed)
+
+		var received *events.Envelope
+		Eventually(envelopeChan).Should(Receive(&received))
+		Expect(received).To(Equal(envelope))
+	})
+
+	It("counts frames that can't be unmarshalled and keeps reading", func() {
+		conn, err := tls.Dial("tcp", listener.Address(), clientTLS)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		garbage := []byte{1, 2, 3}
+		binary.Write(conn, binary.LittleEndian, uint32(len(garbage)))
+		conn.Write(garbage)
+
+		envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "app-id", "App"), "origin")
+		writeFrame(conn, envelope)
+
+		Eventually(envelopeChan).Should(Receive())
+		testhelpers.EventuallyExpectMetric(listener, "receiveErrors", 1)
+	})
+
+	It("rejects clients without a trusted certificate", func() {
+		conn, err := tls.Dial("tcp", listener.Address(), &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "app-id", "App"), "origin")
+			writeFrame(conn, envelope)
+			conn.Close()
+		}
+
+		Consistently(envelopeChan).ShouldNot(Receive())
+	})
+})
+
+func writeFrame(conn net.Conn, envelope *events.Envelope) {
+	bytes, err := proto.Marshal(envelope)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = binary.Write(conn, binary.LittleEndian, uint32(len(bytes)))
+	Expect(err).NotTo(HaveOccurred())
+	_, err = conn.Write(bytes)
+	Expect(err).NotTo(HaveOccurred())
+}
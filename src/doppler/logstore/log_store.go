@@ -0,0 +1,590 @@
+package logstore
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	segmentSuffix    = ".seg"
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 20
+)
+
+// A Store keeps the most recent log messages of every app on disk, so that
+// they survive Doppler restarts. Each app has a directory of append-only
+// segment files holding up to maxMessagesPerApp messages each, and only as
+// many segments are kept as are needed for the app's most recent
+// maxMessagesPerApp messages. Every record carries a checksum; a segment
+// whose tail was torn or corrupted, e.g. by a crash, is truncated to its last
+// good record when the store is opened.
+//
+// Compact, which Run calls periodically, evicts the oldest segments of any
+// app while the store is larger than maxBytes and closes the files of apps
+// that stopped logging. At most maxOpenFiles segment files are kept open for
+// appending; the file of the app that was written to least recently is
+// closed to make room for another.
+type Store struct {
+	dir               string
+	maxMessagesPerApp int
+	maxBytes          int64
+	maxOpenFiles      int
+	logger            *gosteno.Logger
+
+	apps map[string]*appLog
+	lock sync.Mutex
+
+	// openWriters holds the apps with an open writer, most recently written
+	// first. Its lock may be taken while holding an app's lock, never the
+	// other way around.
+	openWriters *list.List
+	writersLock sync.Mutex
+
+	nextSegmentID uint64
+	bytes         int64
+
+	appendedMessageCount uint64
+	evictedSegmentCount  uint64
+	corruptSegmentCount  uint64
+	writeErrorCount      uint64
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type appLog struct {
+	dir        string
+	segments   []*segment
+	writer     *os.File
+	lruElement *list.Element
+	written    bool
+	removed    bool
+	lock       sync.Mutex
+}
+
+type segment struct {
+	id       uint64
+	path     string
+	bytes    int64
+	messages int
+}
+
+func New(dir string, maxMessagesPerApp int, maxBytes int64, maxOpenFiles int, logger *gosteno.Logger) (*Store, error) {
+	if maxMessagesPerApp <= 0 || maxBytes <= 0 || maxOpenFiles <= 0 {
+		return nil, fmt.Errorf("recent logs store needs a positive number of messages per app, size and open files, got %d, %d and %d", maxMessagesPerApp, maxBytes, maxOpenFiles)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:               dir,
+		maxMessagesPerApp: maxMessagesPerApp,
+		maxBytes:          maxBytes,
+		maxOpenFiles:      maxOpenFiles,
+		logger:            logger,
+		apps:              make(map[string]*appLog),
+		openWriters:       list.New(),
+		stopChan:          make(chan struct{}),
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append stores a log message of the given app.
+func (s *Store) Append(appId string, envelope *events.Envelope) error {
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+
+	for {
+		app := s.appFor(appId)
+
+		app.lock.Lock()
+		if app.removed {
+			// Compact removed the app after we looked it up.
+			app.lock.Unlock()
+			continue
+		}
+		err := s.appendTo(app, record)
+		var idle []*appLog
+		if err == nil {
+			idle = s.markWritten(app)
+		}
+		app.lock.Unlock()
+
+		for _, idleApp := range idle {
+			idleApp.lock.Lock()
+			s.closeWriter(idleApp)
+			idleApp.lock.Unlock()
+		}
+
+		if err != nil {
+			atomic.AddUint64(&s.writeErrorCount, 1)
+			metrics.BatchIncrementCounter("recentLogsStore.writeErrors")
+			return err
+		}
+
+		atomic.AddUint64(&s.appendedMessageCount, 1)
+		return nil
+	}
+}
+
+// RecentLogs returns the most recent log messages of the given app, oldest
+// first.
+func (s *Store) RecentLogs(appId string) ([]*events.Envelope, error) {
+	s.lock.Lock()
+	app := s.apps[appId]
+	s.lock.Unlock()
+
+	envelopes := []*events.Envelope{}
+	if app == nil {
+		return envelopes, nil
+	}
+
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	skip := app.messages() - s.maxMessagesPerApp
+	for _, seg := range app.segments {
+		_, _, err := readSegment(seg.path, func(data []byte) {
+			if skip > 0 {
+				skip--
+				return
+			}
+
+			envelope := &events.Envelope{}
+			if err := proto.Unmarshal(data, envelope); err != nil {
+				s.logger.Debugf("LogStore: can't unmarshal stored message of app %s: %s", appId, err)
+				return
+			}
+			envelopes = append(envelopes, envelope)
+		})
+		if err != nil {
+			return envelopes, err
+		}
+	}
+
+	return envelopes, nil
+}
+
+// Run compacts the store every interval until Stop is called.
+func (s *Store) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Compact()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Stop ends Run and closes the open segment files.
+func (s *Store) Stop() {
+	s.stopOnce.Do(func() { close(s.stopChan) })
+
+	for _, app := range s.snapshot() {
+		app.lock.Lock()
+		s.closeWriter(app)
+		app.lock.Unlock()
+	}
+}
+
+// Compact closes the files of apps that were not written to since the
+// previous compaction, evicts the oldest segments while the store is too
+// large and removes apps that have nothing stored anymore.
+func (s *Store) Compact() {
+	for _, app := range s.snapshot() {
+		app.lock.Lock()
+		if !app.written {
+			s.closeWriter(app)
+		}
+		app.written = false
+		app.lock.Unlock()
+	}
+
+	for atomic.LoadInt64(&s.bytes) > s.maxBytes {
+		if !s.evictOldestSegment() {
+			break
+		}
+	}
+
+	s.removeEmptyApps()
+}
+
+func (s *Store) Emit() instrumentation.Context {
+	s.lock.Lock()
+	appCount := len(s.apps)
+	s.lock.Unlock()
+
+	s.writersLock.Lock()
+	openFileCount := s.openWriters.Len()
+	s.writersLock.Unlock()
+
+	return instrumentation.Context{
+		Name: "recentLogsStore",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "apps", Value: appCount},
+			instrumentation.Metric{Name: "openFiles", Value: openFileCount},
+			instrumentation.Metric{Name: "storedBytes", Value: atomic.LoadInt64(&s.bytes)},
+			instrumentation.Metric{Name: "appendedMessages", Value: atomic.LoadUint64(&s.appendedMessageCount)},
+			instrumentation.Metric{Name: "evictedSegments", Value: atomic.LoadUint64(&s.evictedSegmentCount)},
+			instrumentation.Metric{Name: "corruptSegments", Value: atomic.LoadUint64(&s.corruptSegmentCount)},
+			instrumentation.Metric{Name: "writeErrors", Value: atomic.LoadUint64(&s.writeErrorCount)},
+		},
+	}
+}
+
+func (s *Store) appFor(appId string) *appLog {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	app, ok := s.apps[appId]
+	if !ok {
+		app = &appLog{dir: filepath.Join(s.dir, hex.EncodeToString([]byte(appId)))}
+		s.apps[appId] = app
+	}
+	return app
+}
+
+func (s *Store) snapshot() []*appLog {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	apps := make([]*appLog, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, app)
+	}
+	return apps
+}
+
+// appendTo must be called with the app's lock held.
+func (s *Store) appendTo(app *appLog, record []byte) error {
+	if err := s.ensureWriter(app); err != nil {
+		return err
+	}
+
+	current := app.segments[len(app.segments)-1]
+	n, err := app.writer.Write(record)
+	current.bytes += int64(n)
+	atomic.AddInt64(&s.bytes, int64(n))
+	if err != nil {
+		// A partial record would be dropped on recovery, but nothing may be
+		// appended after it, so start a new segment with the next message.
+		current.messages = s.maxMessagesPerApp
+		s.closeWriter(app)
+		return err
+	}
+
+	current.messages++
+	app.written = true
+	return nil
+}
+
+func (s *Store) ensureWriter(app *appLog) error {
+	if app.writer != nil && app.segments[len(app.segments)-1].messages < s.maxMessagesPerApp {
+		return nil
+	}
+
+	if app.writer == nil && len(app.segments) > 0 {
+		last := app.segments[len(app.segments)-1]
+		if last.messages < s.maxMessagesPerApp {
+			writer, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return err
+			}
+			app.writer = writer
+			return nil
+		}
+	}
+
+	return s.rotate(app)
+}
+
+func (s *Store) rotate(app *appLog) error {
+	s.closeWriter(app)
+
+	if err := os.MkdirAll(app.dir, 0700); err != nil {
+		return err
+	}
+
+	id := atomic.AddUint64(&s.nextSegmentID, 1)
+	path := filepath.Join(app.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+	writer, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	app.writer = writer
+	app.segments = append(app.segments, &segment{id: id, path: path})
+
+	// Older segments are no longer needed once the newer ones hold enough
+	// messages on their own.
+	for len(app.segments) > 1 && app.messages()-app.segments[0].messages >= s.maxMessagesPerApp {
+		s.removeOldest(app)
+	}
+	return nil
+}
+
+// evictOldestSegment removes the oldest segment of any app and reports
+// whether there was one.
+func (s *Store) evictOldestSegment() bool {
+	var oldest *appLog
+	var oldestID uint64
+	for _, app := range s.snapshot() {
+		app.lock.Lock()
+		if len(app.segments) > 0 && (oldest == nil || app.segments[0].id < oldestID) {
+			oldest = app
+			oldestID = app.segments[0].id
+		}
+		app.lock.Unlock()
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	oldest.lock.Lock()
+	defer oldest.lock.Unlock()
+	if len(oldest.segments) > 0 && oldest.segments[0].id == oldestID {
+		s.removeOldest(oldest)
+		atomic.AddUint64(&s.evictedSegmentCount, 1)
+		metrics.BatchIncrementCounter("recentLogsStore.evictedSegments")
+	}
+	return true
+}
+
+// removeOldest must be called with the app's lock held.
+func (s *Store) removeOldest(app *appLog) {
+	oldest := app.segments[0]
+	if len(app.segments) == 1 {
+		s.closeWriter(app)
+	}
+
+	if err := os.Remove(oldest.path); err != nil {
+		s.logger.Warnf("LogStore: can't remove segment %s: %s", oldest.path, err)
+	}
+	atomic.AddInt64(&s.bytes, -oldest.bytes)
+	app.segments = app.segments[1:]
+}
+
+func (s *Store) removeEmptyApps() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for appId, app := range s.apps {
+		app.lock.Lock()
+		if len(app.segments) == 0 {
+			app.removed = true
+			s.closeWriter(app)
+			os.Remove(app.dir)
+			delete(s.apps, appId)
+		}
+		app.lock.Unlock()
+	}
+}
+
+func (s *Store) recover() error {
+	dirs, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		appId, err := hex.DecodeString(dir.Name())
+		if err != nil {
+			s.logger.Warnf("LogStore: ignoring unexpected directory %s", dir.Name())
+			continue
+		}
+
+		app := &appLog{dir: filepath.Join(s.dir, dir.Name())}
+		if err := s.recoverApp(app); err != nil {
+			return err
+		}
+
+		if len(app.segments) == 0 {
+			os.Remove(app.dir)
+			continue
+		}
+		s.apps[string(appId)] = app
+	}
+
+	s.logger.Infof("LogStore: recovered %d bytes of recent logs for %d apps", atomic.LoadInt64(&s.bytes), len(s.apps))
+	return nil
+}
+
+func (s *Store) recoverApp(app *appLog) error {
+	files, err := ioutil.ReadDir(app.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(app.dir, name)
+		validBytes, messages, err := readSegment(path, func([]byte) {})
+		if err != nil {
+			return err
+		}
+
+		if validBytes < file.Size() {
+			s.logger.Warnf("LogStore: segment %s has a torn or corrupt tail, truncating it to %d bytes", path, validBytes)
+			atomic.AddUint64(&s.corruptSegmentCount, 1)
+			if err := os.Truncate(path, validBytes); err != nil {
+				return err
+			}
+		}
+
+		if messages == 0 {
+			os.Remove(path)
+			continue
+		}
+
+		app.segments = append(app.segments, &segment{id: id, path: path, bytes: validBytes, messages: messages})
+		atomic.AddInt64(&s.bytes, validBytes)
+		if id > s.nextSegmentID {
+			s.nextSegmentID = id
+		}
+	}
+
+	sort.Sort(byID(app.segments))
+	return nil
+}
+
+func (app *appLog) messages() int {
+	total := 0
+	for _, seg := range app.segments {
+		total += seg.messages
+	}
+	return total
+}
+
+// markWritten must be called with the app's lock held, after appending to
+// its writer. It returns the apps whose writers have to be closed to stay
+// within maxOpenFiles.
+func (s *Store) markWritten(app *appLog) []*appLog {
+	s.writersLock.Lock()
+	defer s.writersLock.Unlock()
+
+	if app.lruElement != nil {
+		s.openWriters.MoveToFront(app.lruElement)
+	} else {
+		app.lruElement = s.openWriters.PushFront(app)
+	}
+
+	var idle []*appLog
+	for s.openWriters.Len() > s.maxOpenFiles {
+		oldest := s.openWriters.Remove(s.openWriters.Back()).(*appLog)
+		oldest.lruElement = nil
+		idle = append(idle, oldest)
+	}
+	return idle
+}
+
+// closeWriter must be called with the app's lock held.
+func (s *Store) closeWriter(app *appLog) {
+	if app.writer == nil {
+		return
+	}
+	app.writer.Close()
+	app.writer = nil
+
+	s.writersLock.Lock()
+	if app.lruElement != nil {
+		s.openWriters.Remove(app.lruElement)
+		app.lruElement = nil
+	}
+	s.writersLock.Unlock()
+}
+
+// readSegment calls fn with every intact record of a segment, stopping at
+// the first torn or corrupt one. It returns the size and number of the
+// intact records.
+func readSegment(path string, fn func(data []byte)) (int64, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, recordHeaderSize)
+	var validBytes int64
+	var messages int
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return validBytes, messages, nil
+		}
+
+		size := binary.LittleEndian.Uint32(header)
+		if size > maxRecordSize {
+			return validBytes, messages, nil
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return validBytes, messages, nil
+		}
+		if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:]) {
+			return validBytes, messages, nil
+		}
+
+		fn(data)
+		validBytes += int64(recordHeaderSize + len(data))
+		messages++
+	}
+}
+
+type byID []*segment
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Less(i, j int) bool { return b[i].id < b[j].id }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
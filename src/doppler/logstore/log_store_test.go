@@ -0,0 +1,161 @@
+package logstore_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"doppler/logstore"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		dir   string
+		store *logstore.Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "doppler-logstore")
+		Expect(err).NotTo(HaveOccurred())
+
+		store, err = logstore.New(dir, 3, 1<<20, 16, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		store.Stop()
+		os.RemoveAll(dir)
+	})
+
+	appendLogs := func(s *logstore.Store, appId string, messages ...string) {
+		for _, message := range messages {
+			Expect(s.Append(appId, logMessage(appId, message))).To(Succeed())
+		}
+	}
+
+	recentLogs := func(s *logstore.Store, appId string) []string {
+		envelopes, err := s.RecentLogs(appId)
+		Expect(err).NotTo(HaveOccurred())
+
+		messages := []string{}
+		for _, envelope := range envelopes {
+			messages = append(messages, string(envelope.GetLogMessage().GetMessage()))
+		}
+		return messages
+	}
+
+	It("returns the most recent messages of an app, oldest first", func() {
+		appendLogs(store, "app-1", "one", "two", "three", "four", "five")
+		appendLogs(store, "app-2", "other")
+
+		Expect(recentLogs(store, "app-1")).To(Equal([]string{"three", "four", "five"}))
+		Expect(recentLogs(store, "app-2")).To(Equal([]string{"other"}))
+		Expect(recentLogs(store, "app-3")).To(BeEmpty())
+	})
+
+	It("keeps only the segments needed for the most recent messages", func() {
+		appendLogs(store, "app-1", "1", "2", "3", "4", "5", "6", "7", "8")
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+		Expect(segments).To(HaveLen(2))
+		Expect(recentLogs(store, "app-1")).To(Equal([]string{"6", "7", "8"}))
+	})
+
+	It("keeps the messages across restarts", func() {
+		appendLogs(store, "app-1", "one", "two")
+		store.Stop()
+
+		reopened, err := logstore.New(dir, 3, 1<<20, 16, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		defer reopened.Stop()
+
+		appendLogs(reopened, "app-1", "three", "four")
+		Expect(recentLogs(reopened, "app-1")).To(Equal([]string{"two", "three", "four"}))
+	})
+
+	It("truncates a torn segment tail on recovery", func() {
+		appendLogs(store, "app-1", "one", "two")
+		store.Stop()
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+		Expect(segments).To(HaveLen(1))
+		file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		file.Write([]byte{42, 0, 0, 0, 1, 2})
+		file.Close()
+
+		reopened, err := logstore.New(dir, 3, 1<<20, 16, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		defer reopened.Stop()
+
+		testhelpers.EventuallyExpectMetric(reopened, "corruptSegments", uint64(1))
+		appendLogs(reopened, "app-1", "three")
+		Expect(recentLogs(reopened, "app-1")).To(Equal([]string{"one", "two", "three"}))
+	})
+
+	It("evicts the oldest segments when the store is too large", func() {
+		store.Stop()
+
+		var err error
+		store, err = logstore.New(dir, 2, 200, 16, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+
+		for i := 0; i < 4; i++ {
+			appendLogs(store, fmt.Sprintf("app-%d", i), "a message", "another message")
+		}
+		store.Compact()
+
+		Expect(recentLogs(store, "app-0")).To(BeEmpty())
+		Expect(recentLogs(store, "app-3")).To(Equal([]string{"a message", "another message"}))
+		testhelpers.EventuallyExpectMetric(store, "evictedSegments", uint64(2))
+		testhelpers.EventuallyExpectMetric(store, "apps", 2)
+	})
+
+	It("keeps only a limited number of files open", func() {
+		store.Stop()
+
+		var err error
+		store, err = logstore.New(dir, 3, 1<<20, 2, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+
+		appendLogs(store, "app-1", "one")
+		appendLogs(store, "app-2", "one")
+		appendLogs(store, "app-3", "one")
+		testhelpers.EventuallyExpectMetric(store, "openFiles", 2)
+
+		appendLogs(store, "app-1", "two")
+		testhelpers.EventuallyExpectMetric(store, "openFiles", 2)
+		Expect(recentLogs(store, "app-1")).To(Equal([]string{"one", "two"}))
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+		Expect(segments).To(HaveLen(3))
+	})
+
+	It("rejects a store without room for messages", func() {
+		_, err := logstore.New(dir, 0, 1<<20, 16, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func logMessage(appId string, message string) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String("test"),
+		EventType: events.Envelope_LogMessage.Enum(),
+		LogMessage: &events.LogMessage{
+			Message:     []byte(message),
+			MessageType: events.LogMessage_OUT.Enum(),
+			Timestamp:   proto.Int64(1),
+			AppId:       proto.String(appId),
+		},
+	}
+}
@@ -107,7 +107,7 @@ func main() {
 	}
 
 	storeAdapter := NewStoreAdapter(conf.EtcdUrls, conf.EtcdMaxConcurrentRequests)
-	doppler := New(localIp, conf, logger, storeAdapter, conf.MessageDrainBufferSize, "doppler", time.Duration(conf.SinkDialTimeoutSeconds)*time.Second)
+	doppler, err := New(localIp, conf, logger, storeAdapter, conf.MessageDrainBufferSize, "doppler", time.Duration(conf.SinkDialTimeoutSeconds)*time.Second)
 
 	if err != nil {
 		panic(err)
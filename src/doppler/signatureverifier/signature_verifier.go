@@ -0,0 +1,93 @@
+package signatureverifier
+
+import (
+	"sync/atomic"
+
+	"common/signature"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// A Verifier checks the signature of each message against a list of shared
+// secrets and strips it. Accepting several secrets lets operators rotate the
+// one Metron signs with without dropping messages: add the new key to
+// Doppler, switch Metron over, then remove the old key.
+type Verifier struct {
+	keys   []signature.Key
+	logger *gosteno.Logger
+
+	// lastKey is the index of the key that verified the previous message.
+	// It is tried first since, outside of a rotation, every message is
+	// signed with the same key.
+	lastKey int
+
+	verifiedCounts        []uint64
+	missingSignatureCount uint64
+	invalidSignatureCount uint64
+}
+
+func New(keys []signature.Key, logger *gosteno.Logger) *Verifier {
+	return &Verifier{
+		keys:           keys,
+		logger:         logger,
+		verifiedCounts: make([]uint64, len(keys)),
+	}
+}
+
+func (v *Verifier) Run(inputChan <-chan []byte, outputChan chan<- []byte) {
+	for signedMessage := range inputChan {
+		if len(signedMessage) < signature.Size {
+			atomic.AddUint64(&v.missingSignatureCount, 1)
+			metrics.BatchIncrementCounter("signatureVerifier.missingSignatureErrors")
+			v.logger.Warnf("signatureVerifier: missing signature for message %v", signedMessage)
+			continue
+		}
+
+		message, ok := v.verify(signedMessage)
+		if !ok {
+			atomic.AddUint64(&v.invalidSignatureCount, 1)
+			metrics.BatchIncrementCounter("signatureVerifier.invalidSignatureErrors")
+			v.logger.Warnf("signatureVerifier: invalid signature for message %v", signedMessage)
+			continue
+		}
+
+		outputChan <- message
+	}
+}
+
+func (v *Verifier) verify(signedMessage []byte) ([]byte, bool) {
+	for i := range v.keys {
+		index := (v.lastKey + i) % len(v.keys)
+		message, ok := signature.Verify(signedMessage, v.keys[index].Secret)
+		if !ok {
+			continue
+		}
+
+		v.lastKey = index
+		atomic.AddUint64(&v.verifiedCounts[index], 1)
+		metrics.BatchIncrementCounter("signatureVerifier.validSignatures")
+		metrics.BatchIncrementCounter("signatureVerifier." + v.keys[index].Id + ".validSignatures")
+		return message, true
+	}
+	return nil, false
+}
+
+func (v *Verifier) Emit() instrumentation.Context {
+	metrics := []instrumentation.Metric{
+		instrumentation.Metric{Name: "missingSignatureErrors", Value: atomic.LoadUint64(&v.missingSignatureCount)},
+		instrumentation.Metric{Name: "invalidSignatureErrors", Value: atomic.LoadUint64(&v.invalidSignatureCount)},
+	}
+	for i, key := range v.keys {
+		metrics = append(metrics, instrumentation.Metric{
+			Name:  key.Id + ".validSignatures",
+			Value: atomic.LoadUint64(&v.verifiedCounts[i]),
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "signatureVerifier",
+		Metrics: metrics,
+	}
+}
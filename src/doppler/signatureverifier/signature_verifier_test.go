@@ -0,0 +1,68 @@
+package signatureverifier_test
+
+import (
+	"common/signature"
+	"doppler/signatureverifier"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SignatureVerifier", func() {
+	var (
+		verifier   *signatureverifier.Verifier
+		inputChan  chan []byte
+		outputChan chan []byte
+	)
+
+	BeforeEach(func() {
+		keys := []signature.Key{
+			{Id: "old", Secret: "old-secret"},
+			{Id: "new", Secret: "new-secret"},
+		}
+		verifier = signatureverifier.New(keys, loggertesthelper.Logger())
+		inputChan = make(chan []byte, 10)
+		outputChan = make(chan []byte, 10)
+		go verifier.Run(inputChan, outputChan)
+	})
+
+	AfterEach(func() {
+		close(inputChan)
+	})
+
+	It("accepts messages signed with any configured key", func() {
+		inputChan <- signature.Sign([]byte("one"), "old-secret")
+		inputChan <- signature.Sign([]byte("two"), "new-secret")
+		inputChan <- signature.Sign([]byte("three"), "old-secret")
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("one"))))
+		Eventually(outputChan).Should(Receive(Equal([]byte("two"))))
+		Eventually(outputChan).Should(Receive(Equal([]byte("three"))))
+	})
+
+	It("counts which key verified each message", func() {
+		inputChan <- signature.Sign([]byte("one"), "old-secret")
+		inputChan <- signature.Sign([]byte("two"), "new-secret")
+		inputChan <- signature.Sign([]byte("three"), "new-secret")
+
+		testhelpers.EventuallyExpectMetric(verifier, "old.validSignatures", 1)
+		testhelpers.EventuallyExpectMetric(verifier, "new.validSignatures", 2)
+	})
+
+	It("drops messages signed with an unknown key", func() {
+		inputChan <- signature.Sign([]byte("one"), "unknown-secret")
+
+		testhelpers.EventuallyExpectMetric(verifier, "invalidSignatureErrors", 1)
+		Consistently(outputChan).ShouldNot(Receive())
+	})
+
+	It("drops messages without a signature", func() {
+		inputChan <- []byte("short")
+
+		testhelpers.EventuallyExpectMetric(verifier, "missingSignatureErrors", 1)
+		Consistently(outputChan).ShouldNot(Receive())
+	})
+})
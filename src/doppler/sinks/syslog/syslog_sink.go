@@ -116,7 +116,7 @@ func (s *SyslogSink) Run(inputChan <-chan *events.Envelope) {
 
 			// Some metrics will not be filter and can get to here (i.e.: TruncatingBuffer dropped message metrics)
 			if messageEnvelope.GetEventType() == events.Envelope_LogMessage {
-				err := s.sendLogMessage(messageEnvelope.GetLogMessage())
+				err := s.sendLogMessage(messageEnvelope)
 				if err == nil {
 					numberOfTries = 0
 					connected = true
@@ -146,8 +146,9 @@ func (s *SyslogSink) ShouldReceiveErrors() bool {
 	return false
 }
 
-func (s *SyslogSink) sendLogMessage(logMessage *events.LogMessage) error {
-	_, err := s.syslogWriter.Write(messagePriorityValue(logMessage), logMessage.GetMessage(), logMessage.GetSourceType(), logMessage.GetSourceInstance(), *logMessage.Timestamp)
+func (s *SyslogSink) sendLogMessage(envelope *events.Envelope) error {
+	logMessage := envelope.GetLogMessage()
+	_, err := s.syslogWriter.Write(messagePriorityValue(logMessage), logMessage.GetMessage(), logMessage.GetSourceType(), logMessage.GetSourceInstance(), *logMessage.Timestamp, envelope.GetTags())
 	return err
 }
 
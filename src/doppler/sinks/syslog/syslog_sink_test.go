@@ -340,7 +340,7 @@ func (r *SyslogWriterRecorder) Connect() error {
 	}
 }
 
-func (r *SyslogWriterRecorder) Write(p int, b []byte, source, sourceId string, timestamp int64) (int, error) {
+func (r *SyslogWriterRecorder) Write(p int, b []byte, source, sourceId string, timestamp int64, tags map[string]string) (int, error) {
 	r.Lock()
 	defer r.Unlock()
 
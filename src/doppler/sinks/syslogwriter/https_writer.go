@@ -65,8 +65,8 @@ func (w *httpsWriter) Connect() error {
 	return nil
 }
 
-func (w *httpsWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64) (int, error) {
-	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp)
+func (w *httpsWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64, tags map[string]string) (int, error) {
+	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp, tags)
 	bytesWritten, err := w.writeHttp(syslogMsg)
 	w.mu.Lock()
 	w.lastError = err
@@ -55,7 +55,7 @@ var _ = Describe("HttpsWriter", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			parsedTime, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
-			byteCount, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano())
+			byteCount, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano(), nil)
 			Expect(byteCount).To(Equal(76))
 			Expect(err).ToNot(HaveOccurred())
 
@@ -64,11 +64,27 @@ var _ = Describe("HttpsWriter", func() {
 			}).Should(ContainSubstring("loggregator appId [just a test] - - Message"))
 		})
 
+		It("includes envelope tags as structured data", func() {
+			outputUrl, _ := url.Parse(server.URL + "/234-bxg-234/")
+
+			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", true, dialer, timeout)
+			err := w.Connect()
+			Expect(err).ToNot(HaveOccurred())
+
+			tags := map[string]string{"env": "prod", "az": `z"1]`, "bad key": "dropped"}
+			_, err = w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano(), tags)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() string {
+				return string(<-requestChan)
+			}).Should(ContainSubstring(`loggregator appId [just a test] - [tags@47450 az="z\"1\]" env="prod"] Message`))
+		})
+
 		It("returns an error when unable to HTTP POST the log message", func() {
 			outputUrl, _ := url.Parse("https://")
 
 			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", true, dialer, timeout)
-			_, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano())
+			_, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano(), nil)
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -76,7 +92,7 @@ var _ = Describe("HttpsWriter", func() {
 			outputUrl, _ := url.Parse("https://")
 
 			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", true, dialer, timeout)
-			_, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano())
+			_, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano(), nil)
 
 			conErr := w.Connect()
 			Expect(conErr).To(Equal(err))
@@ -92,7 +108,7 @@ var _ = Describe("HttpsWriter", func() {
 
 			parsedTime, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
 			for i := 0; i < 10; i++ {
-				_, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano())
+				_, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano(), nil)
 				Expect(err).To(HaveOccurred())
 			}
 
@@ -108,7 +124,7 @@ var _ = Describe("HttpsWriter", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			parsedTime, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
-			_, err = w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano())
+			_, err = w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano(), nil)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
@@ -134,7 +150,7 @@ var _ = Describe("HttpsWriter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				parsedTime, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
-				_, err = w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano())
+				_, err = w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano(), nil)
 				Expect(err).To(BeAssignableToTypeOf(&url.Error{}))
 				urlErr := err.(*url.Error)
 				Expect(urlErr.Err).To(MatchError("net/http: TLS handshake timeout"))
@@ -163,7 +179,7 @@ var _ = Describe("HttpsWriter", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				parsedTime, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
-				_, err = w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano())
+				_, err = w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", parsedTime.UnixNano(), nil)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -262,7 +278,7 @@ func (c *concurrentWriteRequestSimulator) concurrentWriteRequests(count int, wri
 		c.serverWG.Add(1)
 		wg.Add(1)
 		go func() {
-			writer.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano())
+			writer.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano(), nil)
 			wg.Done()
 		}()
 	}
@@ -64,8 +64,8 @@ func (w *syslogWriter) Connect() error {
 	return nil
 }
 
-func (w *syslogWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64) (byteCount int, err error) {
-	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp)
+func (w *syslogWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64, tags map[string]string) (byteCount int, err error) {
+	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp, tags)
 	// Frame msg with Octet Counting: https://tools.ietf.org/html/rfc6587#section-3.4.1
 	finalMsg := []byte(fmt.Sprintf("%d %s", len(syslogMsg), syslogMsg))
 
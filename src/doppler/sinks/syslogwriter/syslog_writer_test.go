@@ -44,14 +44,14 @@ var _ = Describe("SyslogWriter", func() {
 
 	Context("Message Format", func() {
 		It("sends messages in the proper format", func(done Done) {
-			sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+			sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 
 			Eventually(syslogServerSession, 5).Should(gbytes.Say(`\d <\d+>1 \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{1,6}([-+]\d{2}:\d{2}) loggregator appId \[App/2\] - - just a test\n`))
 			close(done)
 		}, 10)
 
 		It("strips null termination char from message", func(done Done) {
-			sysLogWriter.Write(standardOutPriority, []byte(string(0)+" hi"), "appId", "", time.Now().UnixNano())
+			sysLogWriter.Write(standardOutPriority, []byte(string(0)+" hi"), "appId", "", time.Now().UnixNano(), nil)
 
 			Expect(syslogServerSession).ToNot(gbytes.Say("\000"))
 
@@ -64,14 +64,14 @@ var _ = Describe("SyslogWriter", func() {
 			syslogServerSession.Kill().Wait()
 
 			Eventually(func() error {
-				_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+				_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 				return err
 			}).Should(HaveOccurred())
 		})
 
 		It("returns an error if not connected", func() {
 			sysLogWriter.Close()
-			_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+			_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -97,14 +97,14 @@ var _ = Describe("SyslogWriter", func() {
 
 	Context("Message Format", func() {
 		It("sends messages in the proper format", func(done Done) {
-			sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+			sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 
 			Eventually(syslogServerSession, 5).Should(gbytes.Say(`\d <\d+>1 \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{1,6}([-+]\d{2}:\d{2}) loggregator appId \[App/2\] - - just a test\n`))
 			close(done)
 		}, 10)
 
 		It("strips null termination char from message", func(done Done) {
-			sysLogWriter.Write(standardOutPriority, []byte(string(0)+" hi"), "appId", "", time.Now().UnixNano())
+			sysLogWriter.Write(standardOutPriority, []byte(string(0)+" hi"), "appId", "", time.Now().UnixNano(), nil)
 
 			Expect(syslogServerSession).ToNot(gbytes.Say("\000"))
 
@@ -117,14 +117,14 @@ var _ = Describe("SyslogWriter", func() {
 			syslogServerSession.Kill().Wait()
 
 			Eventually(func() error {
-				_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+				_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 				return err
 			}).Should(HaveOccurred())
 		})
 
 		It("returns an error if not connected", func() {
 			sysLogWriter.Close()
-			_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+			_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -169,7 +169,7 @@ var _ = Describe("SyslogWriter", func() {
 			})
 
 			It("returns an error after the write deadline expires", func() {
-				_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+				_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 				opErr := err.(*net.OpError)
 				Expect(opErr.Timeout()).To(BeTrue())
 			})
@@ -177,7 +177,7 @@ var _ = Describe("SyslogWriter", func() {
 
 		Context("when the server connection closes", func() {
 			It("gets detected by watch connection", func() {
-				written, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+				written, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(written).NotTo(Equal(0))
 
@@ -188,14 +188,14 @@ var _ = Describe("SyslogWriter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				Eventually(func() error {
-					_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+					_, err := sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 					return err
 				}).Should(MatchError("Connection to syslog sink lost"))
 
 				err = sysLogWriter.Connect()
 				Expect(err).NotTo(HaveOccurred())
 
-				written, err = sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+				written, err = sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(written).NotTo(Equal(0))
 			})
@@ -73,8 +73,8 @@ func (w *tlsWriter) Close() error {
 	return nil
 }
 
-func (w *tlsWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64) (byteCount int, err error) {
-	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp)
+func (w *tlsWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64, tags map[string]string) (byteCount int, err error) {
+	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp, tags)
 	// Frame msg with Octet Counting: https://tools.ietf.org/html/rfc6587#section-3.4.1
 	finalMsg := []byte(fmt.Sprintf("%d %s", len(syslogMsg), syslogMsg))
 
@@ -80,7 +80,7 @@ var _ = Describe("TLSWriter", func() {
 				return err
 			}, 5, 1).ShouldNot(HaveOccurred())
 
-			_, err := syslogWriter.Write(standardOutPriority, []byte("just a test"), "test", "", ts)
+			_, err := syslogWriter.Write(standardOutPriority, []byte("just a test"), "test", "", ts, nil)
 			Expect(err).ToNot(HaveOccurred())
 
 			Eventually(syslogServerSession, 3).Should(gbytes.Say("just a test"))
@@ -97,7 +97,7 @@ var _ = Describe("TLSWriter", func() {
 				err := syslogWriter.Connect()
 				Expect(err).NotTo(HaveOccurred())
 
-				_, err = syslogWriter.Write(standardOutPriority, []byte("just a test"), "test", "", time.Now().UnixNano())
+				_, err = syslogWriter.Write(standardOutPriority, []byte("just a test"), "test", "", time.Now().UnixNano(), nil)
 				Expect(err).To(HaveOccurred())
 				netErr := err.(*net.OpError)
 				Expect(netErr.Timeout()).To(BeTrue())
@@ -109,7 +109,7 @@ var _ = Describe("TLSWriter", func() {
 				syslogServerSession.Kill().Wait()
 
 				Eventually(func() error {
-					_, err := syslogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+					_, err := syslogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 					return err
 				}, 5).Should(HaveOccurred())
 				close(done)
@@ -117,7 +117,7 @@ var _ = Describe("TLSWriter", func() {
 
 			It("returns an error if not connected", func(done Done) {
 				syslogWriter.Close()
-				_, err := syslogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+				_, err := syslogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano(), nil)
 				Expect(err).To(HaveOccurred())
 				close(done)
 			}, 5)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 )
@@ -14,13 +15,18 @@ const (
 	rfc5424 = "2006-01-02T15:04:05.999999Z07:00"
 )
 
+// tagsSDID identifies the structured data element carrying envelope tags.
+const tagsSDID = "tags@47450"
+
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
 var badBytes = []byte("\000")
 var emptyBytes = []byte{}
 var newLine = []byte("\n")
 
 type Writer interface {
 	Connect() error
-	Write(p int, b []byte, source, sourceId string, timestamp int64) (int, error)
+	Write(p int, b []byte, source, sourceId string, timestamp int64, tags map[string]string) (int, error)
 	Close() error
 }
 
@@ -42,7 +48,7 @@ func clean(in []byte) []byte {
 	return bytes.Replace(in, badBytes, emptyBytes, -1)
 }
 
-func createMessage(p int, appId string, source string, sourceId string, msg []byte, timestamp int64) string {
+func createMessage(p int, appId string, source string, sourceId string, msg []byte, timestamp int64, tags map[string]string) string {
 	// ensure it ends in a \n
 	nl := ""
 	if !bytes.HasSuffix(msg, newLine) {
@@ -61,5 +67,40 @@ func createMessage(p int, appId string, source string, sourceId string, msg []by
 	}
 
 	// syslog format https://tools.ietf.org/html/rfc5424#section-6
-	return fmt.Sprintf("<%d>1 %s %s %s %s - - %s%s", p, timeString, "loggregator", appId, formattedSource, msg, nl)
+	return fmt.Sprintf("<%d>1 %s %s %s %s - %s %s%s", p, timeString, "loggregator", appId, formattedSource, structuredData(tags), msg, nl)
+}
+
+// structuredData renders envelope tags as an RFC 5424 SD-ELEMENT, sorted by
+// key. Keys that are not valid SD-NAMEs are left out.
+func structuredData(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		if validParamName(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "-"
+	}
+	sort.Strings(keys)
+
+	var buffer bytes.Buffer
+	buffer.WriteString("[" + tagsSDID)
+	for _, key := range keys {
+		fmt.Fprintf(&buffer, ` %s="%s"`, key, sdParamEscaper.Replace(tags[key]))
+	}
+	buffer.WriteString("]")
+	return buffer.String()
+}
+
+func validParamName(name string) bool {
+	if len(name) == 0 || len(name) > 32 {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
 }
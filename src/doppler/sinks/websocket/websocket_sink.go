@@ -2,8 +2,12 @@ package websocket
 
 import (
 	"doppler/sinks"
+	"doppler/truncatingbuffer"
 	"net"
+	"sync/atomic"
 
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/envelope_extensions"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/gogo/protobuf/proto"
@@ -12,9 +16,17 @@ import (
 
 const FIREHOSE_APP_ID = "firehose"
 
+const slowConsumerMessage = "Doppler dropped the connection because the consumer didn't keep up with the firehose"
+
 type remoteMessageWriter interface {
 	RemoteAddr() net.Addr
 	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// An EnvelopeFilter selects the envelopes a WebsocketSink sends.
+type EnvelopeFilter interface {
+	Matches(envelope *events.Envelope) bool
 }
 
 type WebsocketSink struct {
@@ -24,9 +36,16 @@ type WebsocketSink struct {
 	clientAddress          net.Addr
 	messageDrainBufferSize uint
 	dropsondeOrigin        string
+	filter                 EnvelopeFilter
+	unbuffered             bool
+	slowConsumer           chan struct{}
+	droppedMessages        uint64
+	totalDroppedMessages   int64
 }
 
-func NewWebsocketSink(streamId string, givenLogger *gosteno.Logger, ws remoteMessageWriter, messageDrainBufferSize uint, dropsondeOrigin string) *WebsocketSink {
+// NewWebsocketSink returns a sink sending the envelopes selected by filter to
+// the websocket. A nil filter selects every envelope.
+func NewWebsocketSink(streamId string, givenLogger *gosteno.Logger, ws remoteMessageWriter, messageDrainBufferSize uint, dropsondeOrigin string, filter EnvelopeFilter) *WebsocketSink {
 	return &WebsocketSink{
 		logger:                 givenLogger,
 		streamId:               streamId,
@@ -34,9 +53,21 @@ func NewWebsocketSink(streamId string, givenLogger *gosteno.Logger, ws remoteMes
 		clientAddress:          ws.RemoteAddr(),
 		messageDrainBufferSize: messageDrainBufferSize,
 		dropsondeOrigin:        dropsondeOrigin,
+		filter:                 filter,
+		slowConsumer:           make(chan struct{}, 1),
 	}
 }
 
+// NewFirehoseSink returns a sink for a firehose subscription. Unlike an app
+// stream sink it doesn't buffer envelopes itself, so that its firehose group
+// sees how far behind its consumer is. When the group drops messages, the
+// sink sends its consumer the same notice a full buffer would.
+func NewFirehoseSink(subscriptionId string, givenLogger *gosteno.Logger, ws remoteMessageWriter, dropsondeOrigin string, filter EnvelopeFilter) *WebsocketSink {
+	sink := NewWebsocketSink(subscriptionId, givenLogger, ws, 0, dropsondeOrigin, filter)
+	sink.unbuffered = true
+	return sink
+}
+
 func (sink *WebsocketSink) Identifier() string {
 	return sink.ws.RemoteAddr().String()
 }
@@ -52,16 +83,45 @@ func (sink *WebsocketSink) ShouldReceiveErrors() bool {
 func (sink *WebsocketSink) Run(inputChan <-chan *events.Envelope) {
 	sink.logger.Debugf("Websocket Sink %s: Running for streamId [%s]", sink.clientAddress, sink.streamId)
 
-	buffer := sinks.RunTruncatingBuffer(inputChan, sink.messageDrainBufferSize, sink.logger, sink.dropsondeOrigin, sink.Identifier())
+	done := make(chan struct{})
+	defer close(done)
+
+	if sink.filter != nil {
+		// Filter before buffering, so that envelopes the client doesn't want
+		// never take up buffer space.
+		inputChan = filterEnvelopes(inputChan, sink.filter, done)
+	}
+
+	messages := func() <-chan *events.Envelope { return inputChan }
+	if !sink.unbuffered {
+		buffer := sinks.RunTruncatingBuffer(inputChan, sink.messageDrainBufferSize, sink.logger, sink.dropsondeOrigin, sink.Identifier())
+		messages = buffer.GetOutputChannel
+	}
+
 	for {
 		sink.logger.Debugf("Websocket Sink %s: Waiting for activity", sink.clientAddress)
-		messageEnvelope, ok := <-buffer.GetOutputChannel()
+
+		var messageEnvelope *events.Envelope
+		var ok bool
+		select {
+		case messageEnvelope, ok = <-messages():
+		case <-sink.slowConsumer:
+			sink.disconnectSlowConsumer()
+			return
+		}
 
 		if !ok {
 			sink.logger.Debugf("Websocket Sink %s: Closed listener channel detected. Closing websocket", sink.clientAddress)
 			return
 		}
 
+		if dropped := atomic.SwapUint64(&sink.droppedMessages, 0); dropped > 0 {
+			if err := sink.sendDroppedMessagesNotice(dropped, messageEnvelope); err != nil {
+				sink.logger.Debugf("Websocket Sink %s: Error when trying to send dropped messages notice. Requesting close. Err: %v", sink.clientAddress, err)
+				return
+			}
+		}
+
 		messageBytes, err := proto.Marshal(messageEnvelope)
 
 		if err != nil {
@@ -79,3 +139,76 @@ func (sink *WebsocketSink) Run(inputChan <-chan *events.Envelope) {
 		sink.logger.Debugf("Websocket Sink %s: Successfully sent data", sink.clientAddress)
 	}
 }
+
+// NotifyDroppedMessages makes the sink tell its consumer, before the next
+// envelope, that count envelopes were dropped on their way to it.
+func (sink *WebsocketSink) NotifyDroppedMessages(count uint64) {
+	atomic.AddUint64(&sink.droppedMessages, count)
+}
+
+func (sink *WebsocketSink) sendDroppedMessagesNotice(dropped uint64, next *events.Envelope) error {
+	sink.logger.Warnf("Websocket Sink %s: Dropped %d messages to %s", sink.clientAddress, dropped, sink.streamId)
+
+	sink.totalDroppedMessages += int64(dropped)
+	notice, err := truncatingbuffer.DroppedMessagesNotice(int(dropped), sink.totalDroppedMessages, envelope_extensions.GetAppId(next), sink.Identifier(), sink.dropsondeOrigin)
+	if err != nil {
+		sink.logger.Warnf("Websocket Sink %s: Error marshalling dropped messages notice: %v", sink.clientAddress, err)
+		return nil
+	}
+
+	for _, envelope := range notice {
+		noticeBytes, err := proto.Marshal(envelope)
+		if err != nil {
+			continue
+		}
+		if err := sink.ws.WriteMessage(gorilla.BinaryMessage, noticeBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisconnectSlowConsumer makes the sink send its consumer an error and close
+// the connection instead of sending it more envelopes.
+func (sink *WebsocketSink) DisconnectSlowConsumer() {
+	select {
+	case sink.slowConsumer <- struct{}{}:
+	default:
+	}
+}
+
+func (sink *WebsocketSink) disconnectSlowConsumer() {
+	sink.logger.Warnf("Websocket Sink %s: Disconnecting slow consumer of %s", sink.clientAddress, sink.streamId)
+
+	errorEnvelope, err := emitter.Wrap(&events.Error{
+		Source:  proto.String(sink.dropsondeOrigin),
+		Code:    proto.Int32(gorilla.ClosePolicyViolation),
+		Message: proto.String(slowConsumerMessage),
+	}, sink.dropsondeOrigin)
+	if err == nil {
+		if errorBytes, err := proto.Marshal(errorEnvelope); err == nil {
+			sink.ws.WriteMessage(gorilla.BinaryMessage, errorBytes)
+		}
+	}
+
+	sink.ws.Close()
+}
+
+func filterEnvelopes(inputChan <-chan *events.Envelope, filter EnvelopeFilter, done <-chan struct{}) <-chan *events.Envelope {
+	filteredChan := make(chan *events.Envelope)
+	go func() {
+		defer close(filteredChan)
+		for envelope := range inputChan {
+			if !filter.Matches(envelope) {
+				continue
+			}
+
+			select {
+			case filteredChan <- envelope:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return filteredChan
+}
@@ -1,6 +1,7 @@
 package websocket_test
 
 import (
+	"common/logfilter"
 	"doppler/sinks/websocket"
 	"net"
 	"sync"
@@ -27,6 +28,7 @@ func (fake fakeAddr) String() string {
 
 type fakeMessageWriter struct {
 	messages [][]byte
+	closed   bool
 	sync.RWMutex
 }
 
@@ -41,6 +43,21 @@ func (fake *fakeMessageWriter) WriteMessage(messageType int, data []byte) error
 	return nil
 }
 
+func (fake *fakeMessageWriter) Close() error {
+	fake.Lock()
+	defer fake.Unlock()
+
+	fake.closed = true
+	return nil
+}
+
+func (fake *fakeMessageWriter) Closed() bool {
+	fake.RLock()
+	defer fake.RUnlock()
+
+	return fake.closed
+}
+
 func (fake *fakeMessageWriter) ReadMessages() [][]byte {
 	fake.RLock()
 	defer fake.RUnlock()
@@ -59,7 +76,7 @@ var _ = Describe("WebsocketSink", func() {
 	BeforeEach(func() {
 		logger = loggertesthelper.Logger()
 		fakeWebsocket = &fakeMessageWriter{}
-		websocketSink = websocket.NewWebsocketSink("appId", logger, fakeWebsocket, 10, "dropsonde-origin")
+		websocketSink = websocket.NewWebsocketSink("appId", logger, fakeWebsocket, 10, "dropsonde-origin", nil)
 	})
 
 	Describe("Identifier", func() {
@@ -104,5 +121,77 @@ var _ = Describe("WebsocketSink", func() {
 			Eventually(fakeWebsocket.ReadMessages).Should(HaveLen(2))
 			Expect(fakeWebsocket.ReadMessages()[1]).To(Equal(messageTwoBytes))
 		})
+
+		It("sends only the envelopes selected by its filter", func(done Done) {
+			defer close(done)
+			filter := &logfilter.StreamFilter{MessageType: events.LogMessage_ERR.Enum()}
+			websocketSink = websocket.NewWebsocketSink("appId", logger, fakeWebsocket, 10, "dropsonde-origin", filter)
+			go websocketSink.Run(inputChan)
+
+			out, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "out", "appId", "App"), "origin")
+			err, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_ERR, "err", "appId", "App"), "origin")
+			errBytes, _ := proto.Marshal(err)
+
+			inputChan <- out
+			inputChan <- err
+			Eventually(fakeWebsocket.ReadMessages).Should(HaveLen(1))
+			Consistently(fakeWebsocket.ReadMessages).Should(HaveLen(1))
+			Expect(fakeWebsocket.ReadMessages()[0]).To(Equal(errBytes))
+		})
+
+		It("sends a slow consumer error and closes the websocket when told to disconnect", func(done Done) {
+			defer close(done)
+			websocketSink = websocket.NewFirehoseSink("subscription-id", logger, fakeWebsocket, "dropsonde-origin", nil)
+			runDone := make(chan struct{})
+			go func() {
+				websocketSink.Run(inputChan)
+				close(runDone)
+			}()
+
+			websocketSink.DisconnectSlowConsumer()
+
+			Eventually(runDone).Should(BeClosed())
+			Expect(fakeWebsocket.Closed()).To(BeTrue())
+			Expect(fakeWebsocket.ReadMessages()).To(HaveLen(1))
+
+			var errorEnvelope events.Envelope
+			Expect(proto.Unmarshal(fakeWebsocket.ReadMessages()[0], &errorEnvelope)).To(Succeed())
+			Expect(errorEnvelope.GetEventType()).To(Equal(events.Envelope_Error))
+			Expect(errorEnvelope.GetError().GetMessage()).To(ContainSubstring("didn't keep up"))
+		})
+
+		It("reads a firehose sink's input channel without buffering it", func() {
+			websocketSink = websocket.NewFirehoseSink("subscription-id", logger, fakeWebsocket, "dropsonde-origin", nil)
+			message, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello world", "appId", "App"), "origin")
+			inputChan <- message
+			close(inputChan)
+
+			websocketSink.Run(inputChan)
+
+			Expect(fakeWebsocket.ReadMessages()).To(HaveLen(1))
+		})
+
+		It("tells a firehose consumer how many messages were dropped before the next message", func() {
+			websocketSink = websocket.NewFirehoseSink("subscription-id", logger, fakeWebsocket, "dropsonde-origin", nil)
+			message, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello world", "appId", "App"), "origin")
+			messageBytes, _ := proto.Marshal(message)
+			inputChan <- message
+			close(inputChan)
+
+			websocketSink.NotifyDroppedMessages(3)
+			websocketSink.Run(inputChan)
+
+			messages := fakeWebsocket.ReadMessages()
+			Expect(messages).To(HaveLen(3))
+
+			var logEnvelope, counterEnvelope events.Envelope
+			Expect(proto.Unmarshal(messages[0], &logEnvelope)).To(Succeed())
+			Expect(string(logEnvelope.GetLogMessage().GetMessage())).To(ContainSubstring("dropped 3 messages"))
+			Expect(logEnvelope.GetLogMessage().GetAppId()).To(Equal("appId"))
+			Expect(proto.Unmarshal(messages[1], &counterEnvelope)).To(Succeed())
+			Expect(counterEnvelope.GetCounterEvent().GetName()).To(Equal("TruncatingBuffer.DroppedMessages"))
+			Expect(counterEnvelope.GetCounterEvent().GetDelta()).To(BeEquivalentTo(3))
+			Expect(messages[2]).To(Equal(messageBytes))
+		})
 	})
 })
@@ -25,6 +25,10 @@ func (s *SinkManagerMetrics) UpdateDroppedMessageCount(delta int64) {
 	metrics.BatchAddCounter("messageRouter.totalDroppedMessages", uint64(delta))
 }
 
+func (s *SinkManagerMetrics) IncDroppedRecentLogs() {
+	metrics.BatchIncrementCounter("messageRouter.droppedRecentLogs")
+}
+
 func (s *SinkManagerMetrics) Inc(sink sinks.Sink) {
 	switch sink.(type) {
 	case *dump.DumpSink:
@@ -21,12 +21,31 @@ import (
 	"github.com/cloudfoundry/sonde-go/events"
 )
 
+// A RecentLogsStore keeps the recent logs of apps in place of their dump
+// sinks.
+type RecentLogsStore interface {
+	Append(appId string, envelope *events.Envelope) error
+	RecentLogs(appId string) ([]*events.Envelope, error)
+}
+
+// recentLogsBufferSize is how many log messages may wait to be written to the
+// recent logs store before new ones are dropped.
+const recentLogsBufferSize = 1024
+
+type recentLog struct {
+	appId    string
+	envelope *events.Envelope
+}
+
 type SinkManager struct {
 	messageDrainBufferSize uint
 	dropsondeOrigin        string
 
-	metrics        *metrics.SinkManagerMetrics
-	recentLogCount uint32
+	metrics         *metrics.SinkManagerMetrics
+	recentLogCount  uint32
+	recentLogsStore RecentLogsStore
+	recentLogs      chan recentLog
+	recentLogsWg    sync.WaitGroup
 
 	doneChannel         chan struct{}
 	errorChannel        chan *events.Envelope
@@ -42,7 +61,7 @@ type SinkManager struct {
 	stopOnce sync.Once
 }
 
-func New(maxRetainedLogMessages uint32, skipCertVerify bool, blackListManager *blacklist.URLBlacklistManager, logger *gosteno.Logger, messageDrainBufferSize uint, dropsondeOrigin string, sinkTimeout, sinkIOTimeout, metricTTL, dialTimeout time.Duration) *SinkManager {
+func New(maxRetainedLogMessages uint32, skipCertVerify bool, blackListManager *blacklist.URLBlacklistManager, logger *gosteno.Logger, messageDrainBufferSize uint, dropsondeOrigin string, sinkTimeout, sinkIOTimeout, metricTTL, dialTimeout time.Duration, recentLogsStore RecentLogsStore) *SinkManager {
 	return &SinkManager{
 		doneChannel:            make(chan struct{}),
 		errorChannel:           make(chan *events.Envelope, 100),
@@ -50,6 +69,8 @@ func New(maxRetainedLogMessages uint32, skipCertVerify bool, blackListManager *b
 		sinks:                  groupedsinks.NewGroupedSinks(logger),
 		skipCertVerify:         skipCertVerify,
 		recentLogCount:         maxRetainedLogMessages,
+		recentLogsStore:        recentLogsStore,
+		recentLogs:             make(chan recentLog, recentLogsBufferSize),
 		metrics:                metrics.NewSinkManagerMetrics(),
 		logger:                 logger,
 		messageDrainBufferSize: messageDrainBufferSize,
@@ -64,19 +85,31 @@ func New(maxRetainedLogMessages uint32, skipCertVerify bool, blackListManager *b
 func (sinkManager *SinkManager) Start(newAppServiceChan, deletedAppServiceChan <-chan appservice.AppService) {
 	go sinkManager.listenForNewAppServices(newAppServiceChan)
 	go sinkManager.listenForDeletedAppServices(deletedAppServiceChan)
+	if sinkManager.recentLogsStore != nil {
+		sinkManager.recentLogsWg.Add(1)
+		go sinkManager.writeRecentLogs()
+	}
 
 	sinkManager.listenForErrorMessages()
 }
 
+// Stop deletes every sink and waits until the log messages queued for the
+// recent logs store have been written, so that the store can be stopped
+// afterwards.
 func (sinkManager *SinkManager) Stop() {
 	sinkManager.stopOnce.Do(func() {
 		close(sinkManager.doneChannel)
 		sinkManager.sinks.DeleteAll()
 	})
+	sinkManager.recentLogsWg.Wait()
 }
 
 func (sinkManager *SinkManager) SendTo(appId string, receivedMessage *events.Envelope) {
-	sinkManager.ensureRecentLogsSinkFor(appId)
+	if sinkManager.recentLogsStore != nil {
+		sinkManager.storeRecentLog(appId, receivedMessage)
+	} else {
+		sinkManager.ensureRecentLogsSinkFor(appId)
+	}
 	sinkManager.ensureContainerMetricsSinkFor(appId)
 	sinkManager.sinks.Broadcast(appId, receivedMessage)
 }
@@ -119,8 +152,11 @@ func (sinkManager *SinkManager) IsFirehoseRegistered(sink sinks.Sink) bool {
 	return sinkManager.sinks.IsFirehoseRegistered(sink)
 }
 
+// RegisterFirehoseSink starts sink for its subscription. Firehose sinks don't
+// buffer envelopes themselves, so their input channel holds as many as the
+// message drain buffer would.
 func (sinkManager *SinkManager) RegisterFirehoseSink(sink sinks.Sink) bool {
-	inputChan := make(chan *events.Envelope, 128)
+	inputChan := make(chan *events.Envelope, sinkManager.messageDrainBufferSize)
 	ok := sinkManager.sinks.RegisterFirehoseSink(inputChan, sink)
 	if !ok {
 		return false
@@ -149,6 +185,14 @@ func (sinkManager *SinkManager) UnregisterFirehoseSink(sink sinks.Sink) {
 }
 
 func (sinkManager *SinkManager) RecentLogsFor(appId string) []*events.Envelope {
+	if sinkManager.recentLogsStore != nil {
+		envelopes, err := sinkManager.recentLogsStore.RecentLogs(appId)
+		if err != nil {
+			sinkManager.logger.Errorf("SinkManager.RecentLogsFor: Can't read recent logs for appId [%s]: %s", appId, err)
+		}
+		return envelopes
+	}
+
 	if sink := sinkManager.sinks.DumpFor(appId); sink != nil {
 		return sink.Dump()
 	} else {
@@ -207,6 +251,9 @@ func (sinkManager *SinkManager) listenForErrorMessages() {
 			}
 			appId := envelope_extensions.GetAppId(errorMessage)
 			sinkManager.logger.Debugf("SinkManager:ErrorChannel: Searching for sinks with appId [%s].", appId)
+			if sinkManager.recentLogsStore != nil {
+				sinkManager.storeRecentLog(appId, errorMessage)
+			}
 			sinkManager.sinks.BroadcastError(appId, errorMessage)
 			sinkManager.logger.Debugf("SinkManager:ErrorChannel: Done sending error message.")
 		}
@@ -259,6 +306,51 @@ func (sinkManager *SinkManager) ensureRecentLogsSinkFor(appId string) {
 	sinkManager.RegisterSink(sink)
 }
 
+// storeRecentLog queues a log message for the recent logs store. It never
+// waits on the disk: when the queue is full, the message is dropped.
+func (sinkManager *SinkManager) storeRecentLog(appId string, envelope *events.Envelope) {
+	if envelope.GetEventType() != events.Envelope_LogMessage {
+		return
+	}
+
+	select {
+	case sinkManager.recentLogs <- recentLog{appId: appId, envelope: envelope}:
+	default:
+		sinkManager.metrics.IncDroppedRecentLogs()
+	}
+}
+
+func (sinkManager *SinkManager) writeRecentLogs() {
+	defer sinkManager.recentLogsWg.Done()
+
+	for {
+		select {
+		case <-sinkManager.doneChannel:
+			sinkManager.writeQueuedRecentLogs()
+			return
+		case log := <-sinkManager.recentLogs:
+			sinkManager.appendRecentLog(log)
+		}
+	}
+}
+
+func (sinkManager *SinkManager) writeQueuedRecentLogs() {
+	for {
+		select {
+		case log := <-sinkManager.recentLogs:
+			sinkManager.appendRecentLog(log)
+		default:
+			return
+		}
+	}
+}
+
+func (sinkManager *SinkManager) appendRecentLog(log recentLog) {
+	if err := sinkManager.recentLogsStore.Append(log.appId, log.envelope); err != nil {
+		sinkManager.logger.Debugf("SinkManager: Can't store recent log for appId [%s]: %s", log.appId, err)
+	}
+}
+
 func (sinkManager *SinkManager) ensureContainerMetricsSinkFor(appId string) {
 	if sinkManager.sinks.ContainerMetricsFor(appId) != nil {
 		return
@@ -33,7 +33,7 @@ var _ = Describe("SinkManager", func() {
 
 	BeforeEach(func() {
 		fakeMetricSender.Reset()
-		sinkManager = sinkmanager.New(1, true, blackListManager, loggertesthelper.Logger(), 100, "dropsonde-origin", 1*time.Second, 0, 1*time.Second, 1*time.Second)
+		sinkManager = sinkmanager.New(1, true, blackListManager, loggertesthelper.Logger(), 100, "dropsonde-origin", 1*time.Second, 0, 1*time.Second, 1*time.Second, nil)
 
 		newAppServiceChan = make(chan appservice.AppService)
 		deletedAppServiceChan = make(chan appservice.AppService)
@@ -336,6 +336,14 @@ var _ = Describe("SinkManager", func() {
 			Expect(fakeMetricSender.GetValue("messageRouter.numberOfFirehoseSinks").Value).To(Equal(float64(1)))
 		})
 
+		It("gives the sink an input channel as large as the message drain buffer", func() {
+			sink := &channelSink{done: make(chan struct{}), appId: "firehose-a"}
+			Expect(sinkManager.RegisterFirehoseSink(sink)).To(BeTrue())
+			Eventually(sink.RunCalled).Should(BeTrue())
+
+			Expect(sink.InputCapacity()).To(Equal(100))
+		})
+
 		It("returns false for a duplicate sink and does not update the sink metrics", func() {
 			sink := &channelSink{done: make(chan struct{}), appId: "firehose-a"}
 
@@ -400,6 +408,86 @@ var _ = Describe("SinkManager", func() {
 		})
 	})
 
+	Describe("with a recent logs store", func() {
+		var (
+			store        *fakeRecentLogsStore
+			storeManager *sinkmanager.SinkManager
+			storeDone    chan struct{}
+		)
+
+		BeforeEach(func() {
+			store = &fakeRecentLogsStore{}
+			storeManager = sinkmanager.New(1, true, blackListManager, loggertesthelper.Logger(), 100, "dropsonde-origin", 1*time.Second, 0, 1*time.Second, 1*time.Second, store)
+
+			storeDone = make(chan struct{})
+			go func() {
+				defer close(storeDone)
+				storeManager.Start(make(chan appservice.AppService), make(chan appservice.AppService))
+			}()
+		})
+
+		AfterEach(func() {
+			storeManager.Stop()
+			<-storeDone
+		})
+
+		It("stores log messages instead of keeping them in a dump sink", func() {
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "myApp", "App"), "origin")
+			metric, _ := emitter.Wrap(factories.NewValueMetric("metric", 1, "unit"), "origin")
+
+			storeManager.SendTo("myApp", logMessage)
+			storeManager.SendTo("myApp", metric)
+
+			Eventually(store.Appended).Should(ConsistOf(logMessage))
+			Consistently(store.Appended).Should(HaveLen(1))
+			Expect(storeManager.RecentLogsFor("myApp")).To(ConsistOf(logMessage))
+		})
+
+		It("drops log messages instead of waiting on a slow store", func(done Done) {
+			defer close(done)
+			store.Block()
+			defer store.Unblock()
+
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "myApp", "App"), "origin")
+			for i := 0; i < 2000; i++ {
+				storeManager.SendTo("myApp", logMessage)
+			}
+
+			Eventually(func() uint64 {
+				return fakeMetricSender.GetCounter("messageRouter.droppedRecentLogs")
+			}).Should(BeNumerically(">", 0))
+		})
+
+		It("writes the queued log messages before Stop returns", func() {
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "myApp", "App"), "origin")
+			storeManager.SendTo("myApp", logMessage)
+			Eventually(store.Appended).Should(HaveLen(1))
+
+			store.Block()
+			for i := 0; i < 3; i++ {
+				storeManager.SendTo("myApp", logMessage)
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				storeManager.Stop()
+				close(stopped)
+			}()
+			Consistently(stopped, 100*time.Millisecond).ShouldNot(BeClosed())
+
+			store.Unblock()
+			Eventually(stopped).Should(BeClosed())
+			Expect(store.Appended()).To(HaveLen(4))
+		})
+
+		It("stores error messages", func() {
+			storeManager.SendSyslogErrorToLoggregator("error msg", "myApp", "drainUrl")
+
+			Eventually(store.Appended).Should(HaveLen(1))
+			Expect(string(store.Appended()[0].GetLogMessage().GetMessage())).To(Equal("error msg"))
+		})
+	})
+
 	Describe("Latest Container Metrics", func() {
 		var sink *channelSink
 		BeforeEach(func() {
@@ -454,6 +542,7 @@ type channelSink struct {
 	appId, identifier string
 	received          []*events.Envelope
 	runCalled         bool
+	inputCapacity     int
 	ready             chan struct{}
 	stop              chan struct{}
 }
@@ -465,6 +554,7 @@ func (c *channelSink) Run(msgChan <-chan *events.Envelope) {
 	}
 	c.Lock()
 	c.runCalled = true
+	c.inputCapacity = cap(msgChan)
 	c.Unlock()
 
 	defer close(c.done)
@@ -488,6 +578,12 @@ func (c *channelSink) RunFinished() bool {
 	return true
 }
 
+func (c *channelSink) InputCapacity() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.inputCapacity
+}
+
 func (c *channelSink) RunCalled() bool {
 	c.RLock()
 	defer c.RUnlock()
@@ -511,3 +607,46 @@ func (c *channelSink) GetInstrumentationMetric() sinks.Metric {
 	return sinks.Metric{Name: "numberOfMessagesLost", Value: 25}
 }
 func (c *channelSink) UpdateDroppedMessageCount(mc int64) {}
+
+type fakeRecentLogsStore struct {
+	sync.Mutex
+	appended []*events.Envelope
+	blocked  chan struct{}
+}
+
+func (f *fakeRecentLogsStore) Block() {
+	f.Lock()
+	defer f.Unlock()
+	f.blocked = make(chan struct{})
+}
+
+func (f *fakeRecentLogsStore) Unblock() {
+	f.Lock()
+	defer f.Unlock()
+	close(f.blocked)
+	f.blocked = nil
+}
+
+func (f *fakeRecentLogsStore) Append(appId string, envelope *events.Envelope) error {
+	f.Lock()
+	blocked := f.blocked
+	f.Unlock()
+	if blocked != nil {
+		<-blocked
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.appended = append(f.appended, envelope)
+	return nil
+}
+
+func (f *fakeRecentLogsStore) RecentLogs(appId string) ([]*events.Envelope, error) {
+	return f.Appended(), nil
+}
+
+func (f *fakeRecentLogsStore) Appended() []*events.Envelope {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*events.Envelope{}, f.appended...)
+}
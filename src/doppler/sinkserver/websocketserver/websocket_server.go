@@ -1,6 +1,7 @@
 package websocketserver
 
 import (
+	"common/logfilter"
 	"doppler/sinks"
 	"doppler/sinks/websocket"
 	"doppler/sinkserver/sinkmanager"
@@ -102,8 +103,14 @@ func (w *WebsocketServer) ServeHTTP(writer http.ResponseWriter, request *http.Re
 func (w *WebsocketServer) firehoseHandler(writer http.ResponseWriter, request *http.Request) (wsHandler, error) {
 	firehoseSubscriptionId := strings.Split(request.URL.Path, "/")[2]
 
+	filter, err := logfilter.ParseFirehose(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return nil, fmt.Errorf("Invalid firehose query (returning 400): %s", err)
+	}
+
 	f := func(ws *gorilla.Conn) {
-		w.streamFirehose(firehoseSubscriptionId, ws)
+		w.streamFirehose(firehoseSubscriptionId, filter, ws)
 	}
 	return f, nil
 
@@ -134,9 +141,23 @@ func (w *WebsocketServer) appHandler(writer http.ResponseWriter, request *http.R
 
 	switch endpoint {
 	case "stream":
-		handler = w.streamLogs
+		filter, err := logfilter.ParseStream(request.URL.Query())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return nil, fmt.Errorf("Invalid stream query (returning 400): %s", err)
+		}
+		handler = func(appId string, websocketConnection *gorilla.Conn) {
+			w.streamLogs(appId, filter, websocketConnection)
+		}
 	case "recentlogs":
-		handler = w.recentLogs
+		filter, err := logfilter.Parse(request.URL.Query())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return nil, fmt.Errorf("Invalid recent logs query (returning 400): %s", err)
+		}
+		handler = func(appId string, websocketConnection *gorilla.Conn) {
+			w.recentLogs(appId, filter, websocketConnection)
+		}
 	case "containermetrics":
 		handler = w.latestContainerMetrics
 	default:
@@ -150,25 +171,32 @@ func (w *WebsocketServer) appHandler(writer http.ResponseWriter, request *http.R
 	return f, nil
 }
 
-func (w *WebsocketServer) streamLogs(appId string, websocketConnection *gorilla.Conn) {
+func (w *WebsocketServer) streamLogs(appId string, filter *logfilter.StreamFilter, websocketConnection *gorilla.Conn) {
 	w.logger.Debugf("WebsocketServer: Requesting a wss sink for app %s", appId)
-	w.streamWebsocket(appId, websocketConnection, w.sinkManager.RegisterSink, w.sinkManager.UnregisterSink)
+
+	var sinkFilter websocket.EnvelopeFilter
+	if !filter.IsEmpty() {
+		sinkFilter = filter
+	}
+	websocketSink := websocket.NewWebsocketSink(appId, w.logger, websocketConnection, w.bufferSize, w.dropsondeOrigin, sinkFilter)
+	w.streamWebsocket(websocketSink, websocketConnection, w.sinkManager.RegisterSink, w.sinkManager.UnregisterSink)
 }
 
-func (w *WebsocketServer) streamFirehose(subscriptionId string, websocketConnection *gorilla.Conn) {
+func (w *WebsocketServer) streamFirehose(subscriptionId string, filter *logfilter.FirehoseFilter, websocketConnection *gorilla.Conn) {
 	w.logger.Debugf("WebsocketServer: Requesting firehose wss sink")
-	w.streamWebsocket(subscriptionId, websocketConnection, w.sinkManager.RegisterFirehoseSink, w.sinkManager.UnregisterFirehoseSink)
-}
 
-func (w *WebsocketServer) streamWebsocket(appId string, websocketConnection *gorilla.Conn, register func(sinks.Sink) bool, unregister func(sinks.Sink)) {
-	websocketSink := websocket.NewWebsocketSink(
-		appId,
-		w.logger,
-		websocketConnection,
-		w.bufferSize,
-		w.dropsondeOrigin,
-	)
+	// The filter is part of the subscription, so that only sinks selecting
+	// the same envelopes share them.
+	var sinkFilter websocket.EnvelopeFilter
+	if !filter.IsEmpty() {
+		subscriptionId += "?" + filter.Key()
+		sinkFilter = filter
+	}
+	firehoseSink := websocket.NewFirehoseSink(subscriptionId, w.logger, websocketConnection, w.dropsondeOrigin, sinkFilter)
+	w.streamWebsocket(firehoseSink, websocketConnection, w.sinkManager.RegisterFirehoseSink, w.sinkManager.UnregisterFirehoseSink)
+}
 
+func (w *WebsocketServer) streamWebsocket(websocketSink *websocket.WebsocketSink, websocketConnection *gorilla.Conn, register func(sinks.Sink) bool, unregister func(sinks.Sink)) {
 	register(websocketSink)
 	defer unregister(websocketSink)
 
@@ -176,8 +204,8 @@ func (w *WebsocketServer) streamWebsocket(appId string, websocketConnection *gor
 	server.NewKeepAlive(websocketConnection, w.keepAliveInterval).Run()
 }
 
-func (w *WebsocketServer) recentLogs(appId string, websocketConnection *gorilla.Conn) {
-	logMessages := w.sinkManager.RecentLogsFor(appId)
+func (w *WebsocketServer) recentLogs(appId string, filter *logfilter.Filter, websocketConnection *gorilla.Conn) {
+	logMessages := filter.Apply(w.sinkManager.RecentLogsFor(appId))
 	sendMessagesToWebsocket(logMessages, websocketConnection, w.logger)
 }
 
@@ -22,7 +22,7 @@ import (
 var _ = Describe("WebsocketServer", func() {
 
 	var server *websocketserver.WebsocketServer
-	var sinkManager = sinkmanager.New(1024, false, blacklist.New(nil), loggertesthelper.Logger(), 100, "dropsonde-origin", 1*time.Second, 0, 1*time.Second, 500*time.Millisecond)
+	var sinkManager = sinkmanager.New(1024, false, blacklist.New(nil), loggertesthelper.Logger(), 100, "dropsonde-origin", 1*time.Second, 0, 1*time.Second, 500*time.Millisecond, nil)
 	var appId = "my-app"
 	var wsReceivedChan chan []byte
 	var connectionDropped <-chan struct{}
@@ -69,6 +69,37 @@ var _ = Describe("WebsocketServer", func() {
 		close(done)
 	})
 
+	It("filters the recent logs by the query", func(done Done) {
+		filteredAppId := "filtered-app"
+		out, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "out message", filteredAppId, "App"), "origin")
+		err, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_ERR, "err message", filteredAppId, "App"), "origin")
+		sinkManager.SendTo(filteredAppId, out)
+		sinkManager.SendTo(filteredAppId, err)
+
+		AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/recentlogs?message_type=ERR", apiEndpoint, filteredAppId))
+
+		rlm, receiveErr := receiveEnvelope(wsReceivedChan)
+		Expect(receiveErr).NotTo(HaveOccurred())
+		Expect(rlm.GetLogMessage().GetMessage()).To(Equal([]byte("err message")))
+		Consistently(wsReceivedChan).ShouldNot(Receive())
+		close(done)
+	})
+
+	It("rejects an invalid recent logs query", func() {
+		_, connectionDropped = AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/recentlogs?limit=none", apiEndpoint, appId))
+		Expect(connectionDropped).To(BeClosed())
+	})
+
+	It("rejects an invalid stream query", func() {
+		_, connectionDropped = AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/stream?event_type=Unknown", apiEndpoint, appId))
+		Expect(connectionDropped).To(BeClosed())
+	})
+
+	It("rejects an invalid firehose query", func() {
+		_, connectionDropped = AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/firehose/fire-subscription-a?event_type=Unknown", apiEndpoint))
+		Expect(connectionDropped).To(BeClosed())
+	})
+
 	It("dumps container metric data to the websocket client with /containermetrics", func(done Done) {
 		cm := factories.NewContainerMetric(appId, 0, 42.42, 1234, 123412341234)
 		envelope, _ := emitter.Wrap(cm, "origin")
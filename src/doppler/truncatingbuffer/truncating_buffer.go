@@ -84,17 +84,30 @@ func (r *TruncatingBuffer) GetDroppedMessageCount() int64 {
 
 func (r *TruncatingBuffer) notifyMessagesDropped(droppedMessageCount int, appId string) {
 	metrics.BatchAddCounter("TruncatingBuffer.totalDroppedMessages", uint64(droppedMessageCount))
-	r.emitMessage(generateLogMessage(droppedMessageCount, appId, r.sinkIdentifier))
-	r.emitMessage(generateCounterEvent(droppedMessageCount, r.droppedMessageCount))
+	notice, err := DroppedMessagesNotice(droppedMessageCount, r.droppedMessageCount, appId, r.sinkIdentifier, r.dropsondeOrigin)
+	if err != nil {
+		r.logger.Warnf("Error marshalling message: %v", err)
+		return
+	}
+	for _, env := range notice {
+		r.outputChannel <- env
+	}
 }
 
-func (r *TruncatingBuffer) emitMessage(event events.Event) {
-	env, err := emitter.Wrap(event, r.dropsondeOrigin)
-	if err == nil {
-		r.outputChannel <- env
-	} else {
-		r.logger.Warnf("Error marshalling message: %v", err)
+// DroppedMessagesNotice returns the envelopes that tell a consumer how many
+// messages were dropped on their way to sinkIdentifier: an error log message
+// for the app and a TruncatingBuffer.DroppedMessages counter event carrying
+// the total dropped so far.
+func DroppedMessagesNotice(droppedMessageCount int, total int64, appId, sinkIdentifier, dropsondeOrigin string) ([]*events.Envelope, error) {
+	logMessage, err := emitter.Wrap(generateLogMessage(droppedMessageCount, appId, sinkIdentifier), dropsondeOrigin)
+	if err != nil {
+		return nil, err
+	}
+	counterEvent, err := emitter.Wrap(generateCounterEvent(droppedMessageCount, total), dropsondeOrigin)
+	if err != nil {
+		return nil, err
 	}
+	return []*events.Envelope{logMessage, counterEvent}, nil
 }
 
 func generateLogMessage(droppedMessageCount int, appId, sinkIdentifier string) *events.LogMessage {
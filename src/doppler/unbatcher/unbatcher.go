@@ -0,0 +1,66 @@
+package unbatcher
+
+import (
+	"sync/atomic"
+
+	"common/batch"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// An Unbatcher splits datagrams batched by Metron back into the individual
+// signed messages they contain. Datagrams that are not batches are passed
+// through untouched so that older Metrons keep working.
+type Unbatcher struct {
+	logger *gosteno.Logger
+
+	receivedBatchCount    uint64
+	unbatchedMessageCount uint64
+	invalidBatchCount     uint64
+}
+
+func New(logger *gosteno.Logger) *Unbatcher {
+	return &Unbatcher{
+		logger: logger,
+	}
+}
+
+func (u *Unbatcher) Run(inputChan <-chan []byte, outputChan chan<- []byte) {
+	for data := range inputChan {
+		if !batch.IsBatch(data) {
+			outputChan <- data
+			continue
+		}
+
+		atomic.AddUint64(&u.receivedBatchCount, 1)
+		metrics.BatchIncrementCounter("unbatcher.receivedBatches")
+
+		messages, err := batch.Split(data)
+		if err != nil {
+			atomic.AddUint64(&u.invalidBatchCount, 1)
+			metrics.BatchIncrementCounter("unbatcher.invalidBatches")
+			u.logger.Warnf("Unbatcher: invalid batch of %d bytes: %s", len(data), err)
+		}
+
+		atomic.AddUint64(&u.unbatchedMessageCount, uint64(len(messages)))
+		metrics.BatchAddCounter("unbatcher.unbatchedMessages", uint64(len(messages)))
+		metrics.SendValue("unbatcher.batchSize", float64(len(messages)), "messages")
+
+		for _, message := range messages {
+			outputChan <- message
+		}
+	}
+}
+
+func (u *Unbatcher) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "unbatcher",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "receivedBatches", Value: atomic.LoadUint64(&u.receivedBatchCount)},
+			instrumentation.Metric{Name: "unbatchedMessages", Value: atomic.LoadUint64(&u.unbatchedMessageCount)},
+			instrumentation.Metric{Name: "invalidBatches", Value: atomic.LoadUint64(&u.invalidBatchCount)},
+		},
+	}
+}
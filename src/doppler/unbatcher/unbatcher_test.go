@@ -0,0 +1,60 @@
+package unbatcher_test
+
+import (
+	"bytes"
+
+	"common/batch"
+	"doppler/unbatcher"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unbatcher", func() {
+	var (
+		u          *unbatcher.Unbatcher
+		inputChan  chan []byte
+		outputChan chan []byte
+	)
+
+	BeforeEach(func() {
+		u = unbatcher.New(loggertesthelper.Logger())
+		inputChan = make(chan []byte, 10)
+		outputChan = make(chan []byte, 10)
+		go u.Run(inputChan, outputChan)
+	})
+
+	AfterEach(func() {
+		close(inputChan)
+	})
+
+	It("passes through messages that are not batched", func() {
+		inputChan <- []byte("single message")
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("single message"))))
+	})
+
+	It("splits batches into their messages", func() {
+		buffer := bytes.NewBuffer(batch.Header)
+		batch.Append(buffer, []byte("one"))
+		batch.Append(buffer, []byte("two"))
+		inputChan <- buffer.Bytes()
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("one"))))
+		Eventually(outputChan).Should(Receive(Equal([]byte("two"))))
+		testhelpers.EventuallyExpectMetric(u, "receivedBatches", 1)
+		testhelpers.EventuallyExpectMetric(u, "unbatchedMessages", 2)
+	})
+
+	It("forwards the complete messages of a truncated batch", func() {
+		buffer := bytes.NewBuffer(batch.Header)
+		batch.Append(buffer, []byte("one"))
+		inputChan <- append(buffer.Bytes(), 0xff, 0x00)
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("one"))))
+		testhelpers.EventuallyExpectMetric(u, "invalidBatches", 1)
+	})
+})
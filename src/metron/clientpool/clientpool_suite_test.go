@@ -0,0 +1,13 @@
+package clientpool_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestClientPool(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ClientPool Suite")
+}
@@ -0,0 +1,297 @@
+package clientpool
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/loggregatorlib/loggregatorclient"
+)
+
+var ErrorEmptyClientPool = errors.New("doppler pool is empty")
+
+type AddressList interface {
+	GetAddresses() []string
+}
+
+type ClientCreator func(address string) loggregatorclient.LoggregatorClient
+
+// A failureNotifier is a client that can tell the pool when a send fails,
+// so that strategies can steer traffic away from that Doppler.
+type failureNotifier interface {
+	OnSendFailure(handler func())
+}
+
+// A DopplerPool keeps one client per known Doppler in any zone and lets a
+// SelectionStrategy decide which of them gets each message.
+type DopplerPool struct {
+	port          int
+	inZoneList    AddressList
+	allZoneList   AddressList
+	clientCreator ClientCreator
+	strategy      SelectionStrategy
+	dopplers      map[string]*pooledDoppler
+	stopped       bool
+
+	// The addresses the lists returned when the pool last synced, and the
+	// known Dopplers sorted by address together with the candidates offered
+	// to the strategy. They are only rebuilt when the addresses change, as
+	// RandomClient runs for every message.
+	synced           bool
+	inZoneAddresses  []string
+	allZoneAddresses []string
+	sortedDopplers   []*pooledDoppler
+	candidates       []Doppler
+
+	lock   sync.Mutex
+	logger *gosteno.Logger
+}
+
+type pooledDoppler struct {
+	Doppler
+	client      loggregatorclient.LoggregatorClient
+	chosenCount uint64
+}
+
+// NewDopplerPool returns a pool that picks Dopplers with RandomStrategy.
+func NewDopplerPool(logger *gosteno.Logger, port int, inZoneList, allZoneList AddressList, clientCreator ClientCreator) *DopplerPool {
+	return NewDopplerPoolWithStrategy(logger, port, inZoneList, allZoneList, RandomStrategy{}, clientCreator)
+}
+
+func NewDopplerPoolWithStrategy(logger *gosteno.Logger, port int, inZoneList, allZoneList AddressList, strategy SelectionStrategy, clientCreator ClientCreator) *DopplerPool {
+	return &DopplerPool{
+		port:          port,
+		inZoneList:    inZoneList,
+		allZoneList:   allZoneList,
+		clientCreator: clientCreator,
+		strategy:      strategy,
+		dopplers:      make(map[string]*pooledDoppler),
+		logger:        logger,
+	}
+}
+
+// RandomClient returns the client of the Doppler chosen by the pool's
+// strategy. The name predates strategies and is kept for ClientPool.
+func (pool *DopplerPool) RandomClient() (loggregatorclient.LoggregatorClient, error) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.sync()
+	if len(pool.candidates) == 0 {
+		return nil, ErrorEmptyClientPool
+	}
+
+	index := pool.strategy.Choose(pool.candidates, time.Now())
+	if index < 0 {
+		return nil, ErrorEmptyClientPool
+	}
+
+	chosen := pool.sortedDopplers[index]
+	atomic.AddUint64(&chosen.chosenCount, 1)
+	return chosen.client, nil
+}
+
+// Clients returns the clients of Dopplers in the local zone, or of every
+// Doppler when none is known locally.
+func (pool *DopplerPool) Clients() []loggregatorclient.LoggregatorClient {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.sync()
+
+	var inZone, anyZone []loggregatorclient.LoggregatorClient
+	for _, doppler := range pool.sortedDopplers {
+		anyZone = append(anyZone, doppler.client)
+		if doppler.InZone {
+			inZone = append(inZone, doppler.client)
+		}
+	}
+
+	if len(inZone) > 0 {
+		return inZone
+	}
+	return anyZone
+}
+
+// SetAddressLists replaces where the pool learns about Dopplers, e.g. after
+// Metron is pointed at another etcd. Clients for Dopplers that are still
+// listed are kept.
+func (pool *DopplerPool) SetAddressLists(inZoneList, allZoneList AddressList) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.inZoneList = inZoneList
+	pool.allZoneList = allZoneList
+	pool.synced = false
+}
+
+// GetAddresses returns the addresses of every known Doppler in any zone.
+func (pool *DopplerPool) GetAddresses() []string {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.sync()
+
+	addresses := make([]string, 0, len(pool.sortedDopplers))
+	for _, doppler := range pool.sortedDopplers {
+		addresses = append(addresses, doppler.Address)
+	}
+	return addresses
+}
+
+func (pool *DopplerPool) Emit() instrumentation.Context {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	metrics := []instrumentation.Metric{}
+	for _, doppler := range pool.sortedDopplers {
+		metrics = append(metrics, instrumentation.Metric{
+			Name:  "chosenCount",
+			Value: atomic.LoadUint64(&doppler.chosenCount),
+			Tags:  map[string]interface{}{"address": doppler.Address, "inZone": doppler.InZone},
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "dopplerPool",
+		Metrics: metrics,
+	}
+}
+
+// Stop stops the client of every known Doppler, letting them send what they
+// have queued. The pool creates no clients afterwards, so it behaves as an
+// empty pool.
+func (pool *DopplerPool) Stop() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.stopped = true
+	for address, doppler := range pool.dopplers {
+		doppler.client.Stop()
+		delete(pool.dopplers, address)
+	}
+	pool.sortedDopplers = nil
+	pool.candidates = nil
+}
+
+func (pool *DopplerPool) recordFailure(address string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	doppler, ok := pool.dopplers[address]
+	if !ok {
+		return
+	}
+
+	doppler.LastFailure = time.Now()
+	for i := range pool.candidates {
+		if pool.candidates[i].Address == address {
+			pool.candidates[i].LastFailure = doppler.LastFailure
+		}
+	}
+}
+
+// sync must be called with the lock held. It updates the known Dopplers if
+// the address lists changed since it last ran.
+func (pool *DopplerPool) sync() {
+	if pool.stopped {
+		return
+	}
+
+	inZoneAddresses := pool.inZoneList.GetAddresses()
+	allZoneAddresses := pool.allZoneList.GetAddresses()
+	if pool.synced && equalAddresses(inZoneAddresses, pool.inZoneAddresses) && equalAddresses(allZoneAddresses, pool.allZoneAddresses) {
+		return
+	}
+	pool.synced = true
+	pool.inZoneAddresses = append([]string(nil), inZoneAddresses...)
+	pool.allZoneAddresses = append([]string(nil), allZoneAddresses...)
+
+	inZone := make(map[string]bool)
+	for _, address := range inZoneAddresses {
+		inZone[pool.withPort(address)] = true
+	}
+
+	known := make(map[string]bool, len(inZone))
+	for address := range inZone {
+		known[address] = true
+	}
+	for _, address := range allZoneAddresses {
+		known[pool.withPort(address)] = true
+	}
+
+	for address := range known {
+		if doppler, ok := pool.dopplers[address]; ok {
+			doppler.InZone = inZone[address]
+			continue
+		}
+
+		pool.logger.Debugf("DopplerPool: adding client for %s", address)
+		pool.dopplers[address] = pool.newDoppler(address, inZone[address])
+	}
+
+	for address, doppler := range pool.dopplers {
+		if !known[address] {
+			pool.logger.Debugf("DopplerPool: removing client for %s", address)
+			doppler.client.Stop()
+			delete(pool.dopplers, address)
+		}
+	}
+
+	pool.sortedDopplers = pool.sorted()
+	pool.candidates = make([]Doppler, len(pool.sortedDopplers))
+	for i, doppler := range pool.sortedDopplers {
+		pool.candidates[i] = doppler.Doppler
+	}
+}
+
+func equalAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (pool *DopplerPool) newDoppler(address string, inZone bool) *pooledDoppler {
+	client := pool.clientCreator(address)
+	if notifier, ok := client.(failureNotifier); ok {
+		notifier.OnSendFailure(func() { pool.recordFailure(address) })
+	}
+
+	return &pooledDoppler{
+		Doppler: Doppler{Address: address, InZone: inZone},
+		client:  client,
+	}
+}
+
+func (pool *DopplerPool) withPort(address string) string {
+	if !strings.Contains(address, ":") {
+		return fmt.Sprintf("%s:%d", address, pool.port)
+	}
+	return address
+}
+
+func (pool *DopplerPool) sorted() []*pooledDoppler {
+	addresses := make([]string, 0, len(pool.dopplers))
+	for address := range pool.dopplers {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	dopplers := make([]*pooledDoppler, len(addresses))
+	for i, address := range addresses {
+		dopplers[i] = pool.dopplers[address]
+	}
+	return dopplers
+}
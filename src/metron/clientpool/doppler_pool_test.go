@@ -0,0 +1,250 @@
+package clientpool_test
+
+import (
+	"sync"
+	"time"
+
+	"metron/clientpool"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/loggregatorlib/loggregatorclient"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DopplerPool", func() {
+	var (
+		inZone  *fakeAddressList
+		allZone *fakeAddressList
+		created map[string]*fakeClient
+		pool    *clientpool.DopplerPool
+	)
+
+	BeforeEach(func() {
+		inZone = &fakeAddressList{}
+		allZone = &fakeAddressList{}
+		created = make(map[string]*fakeClient)
+
+		pool = clientpool.NewDopplerPool(loggertesthelper.Logger(), 3457, inZone, allZone, func(address string) loggregatorclient.LoggregatorClient {
+			client := &fakeClient{address: address}
+			created[address] = client
+			return client
+		})
+	})
+
+	It("returns an error when no dopplers are known", func() {
+		_, err := pool.RandomClient()
+		Expect(err).To(Equal(clientpool.ErrorEmptyClientPool))
+	})
+
+	It("prefers dopplers in the same zone", func() {
+		inZone.SetAddresses([]string{"10.0.0.1"})
+		allZone.SetAddresses([]string{"10.0.0.1", "10.0.0.2"})
+
+		Expect(pool.Clients()).To(HaveLen(1))
+		client, err := pool.RandomClient()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.(*fakeClient).address).To(Equal("10.0.0.1:3457"))
+	})
+
+	It("falls back to dopplers in any zone", func() {
+		allZone.SetAddresses([]string{"10.0.0.2"})
+
+		client, err := pool.RandomClient()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.(*fakeClient).address).To(Equal("10.0.0.2:3457"))
+	})
+
+	It("keeps the port when the address already has one", func() {
+		inZone.SetAddresses([]string{"10.0.0.1:1234"})
+
+		client, _ := pool.RandomClient()
+		Expect(client.(*fakeClient).address).To(Equal("10.0.0.1:1234"))
+	})
+
+	It("lists the addresses of dopplers in every zone", func() {
+		inZone.SetAddresses([]string{"10.0.0.1"})
+		allZone.SetAddresses([]string{"10.0.0.2", "10.0.0.1"})
+
+		Expect(pool.GetAddresses()).To(Equal([]string{"10.0.0.1:3457", "10.0.0.2:3457"}))
+	})
+
+	It("switches to new address lists, keeping clients that are still listed", func() {
+		inZone.SetAddresses([]string{"10.0.0.1", "10.0.0.2"})
+		pool.Clients()
+		first := created["10.0.0.1:3457"]
+
+		newInZone := &fakeAddressList{addresses: []string{"10.0.0.1", "10.0.0.3"}}
+		pool.SetAddressLists(newInZone, &fakeAddressList{})
+
+		Expect(pool.Clients()).To(HaveLen(2))
+		Expect(created["10.0.0.1:3457"] == first).To(BeTrue())
+		Expect(created["10.0.0.2:3457"].stopped).To(BeTrue())
+		Expect(created).To(HaveKey("10.0.0.3:3457"))
+	})
+
+	It("reuses clients for known dopplers and stops clients for removed ones", func() {
+		inZone.SetAddresses([]string{"10.0.0.1", "10.0.0.2"})
+		pool.Clients()
+		first := created["10.0.0.1:3457"]
+
+		inZone.SetAddresses([]string{"10.0.0.1"})
+		pool.Clients()
+
+		Expect(created["10.0.0.1:3457"] == first).To(BeTrue())
+		Expect(first.stopped).To(BeFalse())
+		Expect(created["10.0.0.2:3457"].stopped).To(BeTrue())
+	})
+
+	It("stops every client when stopped", func() {
+		inZone.SetAddresses([]string{"10.0.0.1"})
+		allZone.SetAddresses([]string{"10.0.0.2"})
+		pool.Clients()
+
+		pool.Stop()
+
+		Expect(created["10.0.0.1:3457"].stopped).To(BeTrue())
+		Expect(created["10.0.0.2:3457"].stopped).To(BeTrue())
+	})
+
+	It("creates no clients once stopped", func() {
+		inZone.SetAddresses([]string{"10.0.0.1"})
+		pool.Stop()
+
+		_, err := pool.RandomClient()
+
+		Expect(err).To(Equal(clientpool.ErrorEmptyClientPool))
+		Expect(created).To(BeEmpty())
+	})
+})
+
+var _ = Describe("DopplerPool with a strategy", func() {
+	var (
+		inZone   *fakeAddressList
+		allZone  *fakeAddressList
+		created  map[string]*fakeClient
+		strategy *fakeStrategy
+		pool     *clientpool.DopplerPool
+	)
+
+	BeforeEach(func() {
+		inZone = &fakeAddressList{}
+		allZone = &fakeAddressList{}
+		created = make(map[string]*fakeClient)
+		strategy = &fakeStrategy{choice: 1}
+
+		pool = clientpool.NewDopplerPoolWithStrategy(loggertesthelper.Logger(), 3457, inZone, allZone, strategy, func(address string) loggregatorclient.LoggregatorClient {
+			client := &fakeClient{address: address}
+			created[address] = client
+			return client
+		})
+
+		inZone.SetAddresses([]string{"10.0.0.1"})
+		allZone.SetAddresses([]string{"10.0.0.1", "10.0.0.2"})
+	})
+
+	It("offers Dopplers in every zone to the strategy, sorted by address", func() {
+		client, err := pool.RandomClient()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.(*fakeClient).address).To(Equal("10.0.0.2:3457"))
+
+		Expect(strategy.offered).To(Equal([]clientpool.Doppler{
+			{Address: "10.0.0.1:3457", InZone: true},
+			{Address: "10.0.0.2:3457", InZone: false},
+		}))
+	})
+
+	It("reuses the candidates until the addresses change", func() {
+		pool.RandomClient()
+		offered := strategy.offered
+
+		pool.RandomClient()
+		Expect(&strategy.offered[0] == &offered[0]).To(BeTrue())
+
+		allZone.SetAddresses([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+		pool.RandomClient()
+		Expect(strategy.offered).To(HaveLen(3))
+	})
+
+	It("records failed sends for the strategy", func() {
+		pool.RandomClient()
+		created["10.0.0.2:3457"].fail()
+
+		pool.RandomClient()
+		Expect(strategy.offered[1].LastFailure).To(BeTemporally("~", time.Now(), time.Second))
+		Expect(strategy.offered[0].LastFailure.IsZero()).To(BeTrue())
+	})
+
+	It("returns an error when the strategy chooses none", func() {
+		strategy.choice = -1
+		_, err := pool.RandomClient()
+		Expect(err).To(Equal(clientpool.ErrorEmptyClientPool))
+	})
+
+	It("counts how often each Doppler is chosen", func() {
+		pool.RandomClient()
+		pool.RandomClient()
+
+		metrics := pool.Emit().Metrics
+		Expect(metrics).To(HaveLen(2))
+		Expect(metrics[1].Tags["address"]).To(Equal("10.0.0.2:3457"))
+		Expect(metrics[1].Value).To(Equal(uint64(2)))
+		Expect(metrics[0].Value).To(Equal(uint64(0)))
+	})
+})
+
+type fakeStrategy struct {
+	choice  int
+	offered []clientpool.Doppler
+}
+
+func (f *fakeStrategy) Choose(dopplers []clientpool.Doppler, now time.Time) int {
+	f.offered = dopplers
+	return f.choice
+}
+
+type fakeAddressList struct {
+	addresses []string
+	sync.Mutex
+}
+
+func (f *fakeAddressList) SetAddresses(addresses []string) {
+	f.Lock()
+	defer f.Unlock()
+	f.addresses = addresses
+}
+
+func (f *fakeAddressList) GetAddresses() []string {
+	f.Lock()
+	defer f.Unlock()
+	return f.addresses
+}
+
+type fakeClient struct {
+	address        string
+	stopped        bool
+	data           [][]byte
+	failureHandler func()
+}
+
+func (f *fakeClient) OnSendFailure(handler func()) {
+	f.failureHandler = handler
+}
+
+func (f *fakeClient) fail() {
+	f.failureHandler()
+}
+
+func (f *fakeClient) Send(p []byte) {
+	f.data = append(f.data, p)
+}
+
+func (f *fakeClient) Emit() instrumentation.Context {
+	return instrumentation.Context{}
+}
+
+func (f *fakeClient) Stop() {
+	f.stopped = true
+}
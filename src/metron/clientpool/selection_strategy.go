@@ -0,0 +1,125 @@
+package clientpool
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// A Doppler is a candidate a SelectionStrategy can send the next message to.
+type Doppler struct {
+	Address     string
+	InZone      bool
+	LastFailure time.Time
+}
+
+// A SelectionStrategy picks which of the known Dopplers gets the next
+// message. It returns the index of its choice, or -1 to choose none.
+type SelectionStrategy interface {
+	Choose(dopplers []Doppler, now time.Time) int
+}
+
+// NewSelectionStrategy returns the strategy with the given name: "random",
+// "zoneWeighted" or "leastRecentlyFailed". An empty name means random.
+func NewSelectionStrategy(name string, inZoneWeight float64, ejectionDuration time.Duration) (SelectionStrategy, error) {
+	switch name {
+	case "", "random":
+		return RandomStrategy{}, nil
+	case "zoneWeighted":
+		if inZoneWeight <= 0 {
+			return nil, fmt.Errorf("zoneWeighted selection needs a positive in-zone weight, got %v", inZoneWeight)
+		}
+		return ZoneWeightedStrategy{InZoneWeight: inZoneWeight}, nil
+	case "leastRecentlyFailed":
+		return LeastRecentlyFailedStrategy{EjectionDuration: ejectionDuration}, nil
+	}
+	return nil, fmt.Errorf("unknown doppler selection strategy %q", name)
+}
+
+// RandomStrategy picks a random Doppler in the local zone, or in any zone
+// when none is known locally.
+type RandomStrategy struct{}
+
+func (RandomStrategy) Choose(dopplers []Doppler, now time.Time) int {
+	return randomPreferringZone(dopplers, func(Doppler) bool { return true })
+}
+
+// ZoneWeightedStrategy spreads messages over Dopplers in every zone, each
+// local Doppler being InZoneWeight times as likely to be picked as a remote
+// one.
+type ZoneWeightedStrategy struct {
+	InZoneWeight float64
+}
+
+func (s ZoneWeightedStrategy) Choose(dopplers []Doppler, now time.Time) int {
+	total := 0.0
+	for _, doppler := range dopplers {
+		total += s.weight(doppler)
+	}
+	if total == 0 {
+		return -1
+	}
+
+	pick := rand.Float64() * total
+	for i, doppler := range dopplers {
+		pick -= s.weight(doppler)
+		if pick < 0 {
+			return i
+		}
+	}
+	return len(dopplers) - 1
+}
+
+func (s ZoneWeightedStrategy) weight(doppler Doppler) float64 {
+	if doppler.InZone {
+		return s.InZoneWeight
+	}
+	return 1
+}
+
+// LeastRecentlyFailedStrategy ejects Dopplers for EjectionDuration after a
+// send to them fails, picking randomly among the rest like RandomStrategy.
+// When every Doppler is ejected it picks the one that failed longest ago.
+// Only clients that report send failures, such as TLSClient, are ever
+// ejected, so Metron allows it only with the tls protocol.
+type LeastRecentlyFailedStrategy struct {
+	EjectionDuration time.Duration
+}
+
+func (s LeastRecentlyFailedStrategy) Choose(dopplers []Doppler, now time.Time) int {
+	healthy := func(doppler Doppler) bool {
+		return doppler.LastFailure.IsZero() || now.Sub(doppler.LastFailure) >= s.EjectionDuration
+	}
+	if index := randomPreferringZone(dopplers, healthy); index >= 0 {
+		return index
+	}
+
+	leastRecent := -1
+	for i, doppler := range dopplers {
+		if leastRecent < 0 || doppler.LastFailure.Before(dopplers[leastRecent].LastFailure) {
+			leastRecent = i
+		}
+	}
+	return leastRecent
+}
+
+func randomPreferringZone(dopplers []Doppler, eligible func(Doppler) bool) int {
+	var inZone, anyZone []int
+	for i, doppler := range dopplers {
+		if !eligible(doppler) {
+			continue
+		}
+		anyZone = append(anyZone, i)
+		if doppler.InZone {
+			inZone = append(inZone, i)
+		}
+	}
+
+	if len(inZone) > 0 {
+		return inZone[rand.Intn(len(inZone))]
+	}
+	if len(anyZone) > 0 {
+		return anyZone[rand.Intn(len(anyZone))]
+	}
+	return -1
+}
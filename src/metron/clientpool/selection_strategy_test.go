@@ -0,0 +1,112 @@
+package clientpool_test
+
+import (
+	"time"
+
+	"metron/clientpool"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SelectionStrategy", func() {
+	var (
+		now      time.Time
+		dopplers []clientpool.Doppler
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		dopplers = []clientpool.Doppler{
+			{Address: "in-zone-a", InZone: true},
+			{Address: "in-zone-b", InZone: true},
+			{Address: "other-zone", InZone: false},
+		}
+	})
+
+	choices := func(strategy clientpool.SelectionStrategy, times int) map[string]int {
+		chosen := make(map[string]int)
+		for i := 0; i < times; i++ {
+			index := strategy.Choose(dopplers, now)
+			Expect(index).To(BeNumerically(">=", 0))
+			chosen[dopplers[index].Address]++
+		}
+		return chosen
+	}
+
+	Describe("RandomStrategy", func() {
+		It("only picks Dopplers in the local zone when there are any", func() {
+			chosen := choices(clientpool.RandomStrategy{}, 100)
+			Expect(chosen).To(HaveKey("in-zone-a"))
+			Expect(chosen).To(HaveKey("in-zone-b"))
+			Expect(chosen).NotTo(HaveKey("other-zone"))
+		})
+
+		It("falls back to other zones", func() {
+			dopplers = dopplers[2:]
+			Expect(clientpool.RandomStrategy{}.Choose(dopplers, now)).To(Equal(0))
+		})
+
+		It("chooses none without Dopplers", func() {
+			Expect(clientpool.RandomStrategy{}.Choose(nil, now)).To(Equal(-1))
+		})
+	})
+
+	Describe("ZoneWeightedStrategy", func() {
+		It("sends a share of traffic to other zones according to the weight", func() {
+			chosen := choices(clientpool.ZoneWeightedStrategy{InZoneWeight: 4}, 9000)
+
+			Expect(chosen["other-zone"]).To(BeNumerically("~", 1000, 200))
+			Expect(chosen["in-zone-a"]).To(BeNumerically("~", 4000, 400))
+		})
+	})
+
+	Describe("LeastRecentlyFailedStrategy", func() {
+		var strategy clientpool.LeastRecentlyFailedStrategy
+
+		BeforeEach(func() {
+			strategy = clientpool.LeastRecentlyFailedStrategy{EjectionDuration: time.Minute}
+		})
+
+		It("ejects Dopplers that failed recently", func() {
+			dopplers[0].LastFailure = now.Add(-time.Second)
+
+			chosen := choices(strategy, 100)
+			Expect(chosen).To(Equal(map[string]int{"in-zone-b": 100}))
+		})
+
+		It("lets Dopplers back in once the ejection is over", func() {
+			dopplers[0].LastFailure = now.Add(-2 * time.Minute)
+
+			Expect(choices(strategy, 100)).To(HaveKey("in-zone-a"))
+		})
+
+		It("picks the Doppler that failed longest ago when all are ejected", func() {
+			dopplers[0].LastFailure = now.Add(-time.Second)
+			dopplers[1].LastFailure = now.Add(-3 * time.Second)
+			dopplers[2].LastFailure = now.Add(-2 * time.Second)
+
+			Expect(strategy.Choose(dopplers, now)).To(Equal(1))
+		})
+	})
+
+	Describe("NewSelectionStrategy", func() {
+		It("builds strategies by name", func() {
+			strategy, err := clientpool.NewSelectionStrategy("leastRecentlyFailed", 0, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strategy).To(Equal(clientpool.LeastRecentlyFailedStrategy{EjectionDuration: time.Minute}))
+
+			strategy, err = clientpool.NewSelectionStrategy("", 0, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strategy).To(Equal(clientpool.RandomStrategy{}))
+		})
+
+		It("rejects unknown strategies and non-positive zone weights", func() {
+			_, err := clientpool.NewSelectionStrategy("roundRobin", 0, 0)
+			Expect(err).To(HaveOccurred())
+
+			_, err = clientpool.NewSelectionStrategy("zoneWeighted", 0, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,154 @@
+package clientpool
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+var (
+	DialTimeout  = time.Second
+	WriteTimeout = time.Second
+	MinBackoff   = 100 * time.Millisecond
+	MaxBackoff   = 10 * time.Second
+)
+
+var errBackingOff = errors.New("waiting to reconnect")
+
+// A TLSClient sends length-prefixed messages to a Doppler over a mutually
+// authenticated TLS connection. When the connection fails it is dropped and
+// re-established on a later Send, backing off exponentially between attempts.
+type TLSClient struct {
+	address   string
+	tlsConfig *tls.Config
+	logger    *gosteno.Logger
+
+	lock        sync.Mutex
+	conn        net.Conn
+	backoff     time.Duration
+	nextAttempt time.Time
+
+	sentMessageCount uint64
+	sentByteCount    uint64
+	sendErrorCount   uint64
+
+	failureHandler func()
+}
+
+func NewTLSClient(address string, tlsConfig *tls.Config, logger *gosteno.Logger) *TLSClient {
+	return &TLSClient{
+		address:   address,
+		tlsConfig: tlsConfig,
+		logger:    logger,
+	}
+}
+
+// OnSendFailure registers a function called, outside of the client's lock,
+// whenever a message can't be sent. It must be set before the first Send.
+func (c *TLSClient) OnSendFailure(handler func()) {
+	c.failureHandler = handler
+}
+
+func (c *TLSClient) Send(message []byte) {
+	if !c.send(message) && c.failureHandler != nil {
+		c.failureHandler()
+	}
+}
+
+func (c *TLSClient) send(message []byte) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			c.logger.Debugf("TLSClient: can't connect to %s: %v", c.address, err)
+			c.incrementSendErrors()
+			return false
+		}
+	}
+
+	frame := make([]byte, 4+len(message))
+	binary.LittleEndian.PutUint32(frame, uint32(len(message)))
+	copy(frame[4:], message)
+
+	c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	if _, err := c.conn.Write(frame); err != nil {
+		c.logger.Warnf("TLSClient: error writing to %s, reconnecting: %v", c.address, err)
+		c.close()
+		c.scheduleReconnect()
+		c.incrementSendErrors()
+		return false
+	}
+
+	atomic.AddUint64(&c.sentMessageCount, 1)
+	atomic.AddUint64(&c.sentByteCount, uint64(len(message)))
+	return true
+}
+
+func (c *TLSClient) Stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.close()
+}
+
+func (c *TLSClient) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "tlsClient",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "sentMessageCount", Value: atomic.LoadUint64(&c.sentMessageCount), Tags: map[string]interface{}{"address": c.address}},
+			instrumentation.Metric{Name: "sentByteCount", Value: atomic.LoadUint64(&c.sentByteCount), Tags: map[string]interface{}{"address": c.address}},
+			instrumentation.Metric{Name: "sendErrors", Value: atomic.LoadUint64(&c.sendErrorCount), Tags: map[string]interface{}{"address": c.address}},
+		},
+	}
+}
+
+func (c *TLSClient) connect() error {
+	if time.Now().Before(c.nextAttempt) {
+		return errBackingOff
+	}
+
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	if err != nil {
+		c.scheduleReconnect()
+		return err
+	}
+
+	c.logger.Infof("TLSClient: connected to %s", c.address)
+	c.conn = conn
+	c.backoff = 0
+	return nil
+}
+
+func (c *TLSClient) scheduleReconnect() {
+	c.backoff *= 2
+	if c.backoff < MinBackoff {
+		c.backoff = MinBackoff
+	}
+	if c.backoff > MaxBackoff {
+		c.backoff = MaxBackoff
+	}
+	c.nextAttempt = time.Now().Add(c.backoff)
+}
+
+func (c *TLSClient) close() {
+	if c.conn == nil {
+		return
+	}
+	c.conn.Close()
+	c.conn = nil
+}
+
+func (c *TLSClient) incrementSendErrors() {
+	atomic.AddUint64(&c.sendErrorCount, 1)
+	metrics.BatchIncrementCounter("tlsClient.sendErrors")
+}
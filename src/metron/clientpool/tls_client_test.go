@@ -0,0 +1,115 @@
+package clientpool_test
+
+import (
+	"common/tlsconfig"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"metron/clientpool"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLSClient", func() {
+	var (
+		listener  net.Listener
+		received  chan []byte
+		client    *clientpool.TLSClient
+		clientTLS *tls.Config
+	)
+
+	BeforeEach(func() {
+		serverTLS, err := tlsconfig.NewServerConfig("../../common/tlsconfig/fixtures/doppler.crt", "../../common/tlsconfig/fixtures/doppler.key", "../../common/tlsconfig/fixtures/loggregator-ca.crt")
+		Expect(err).NotTo(HaveOccurred())
+
+		clientTLS, err = tlsconfig.NewClientConfig("../../common/tlsconfig/fixtures/metron.crt", "../../common/tlsconfig/fixtures/metron.key", "../../common/tlsconfig/fixtures/loggregator-ca.crt", "doppler")
+		Expect(err).NotTo(HaveOccurred())
+
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+		Expect(err).NotTo(HaveOccurred())
+
+		received = make(chan []byte, 10)
+		go acceptFrames(listener, received)
+
+		client = clientpool.NewTLSClient(listener.Addr().String(), clientTLS, loggertesthelper.Logger())
+	})
+
+	AfterEach(func() {
+		client.Stop()
+		listener.Close()
+	})
+
+	It("sends length-prefixed messages", func() {
+		client.Send([]byte("message one"))
+		client.Send([]byte("message two"))
+
+		Eventually(received).Should(Receive(Equal([]byte("message one"))))
+		Eventually(received).Should(Receive(Equal([]byte("message two"))))
+	})
+
+	It("counts sent messages and bytes", func() {
+		client.Send([]byte("12345"))
+
+		Eventually(received).Should(Receive())
+		testhelpers.EventuallyExpectMetric(client, "sentMessageCount", 1)
+		testhelpers.EventuallyExpectMetric(client, "sentByteCount", 5)
+	})
+
+	Context("when the doppler is unreachable", func() {
+		BeforeEach(func() {
+			clientpool.MinBackoff = time.Hour
+			listener.Close()
+		})
+
+		AfterEach(func() {
+			clientpool.MinBackoff = 100 * time.Millisecond
+		})
+
+		It("counts the failure and backs off before reconnecting", func() {
+			client.Send([]byte("lost"))
+			client.Send([]byte("also lost"))
+
+			testhelpers.EventuallyExpectMetric(client, "sendErrors", 2)
+		})
+
+		It("notifies the failure handler of each failed send", func() {
+			failures := 0
+			client.OnSendFailure(func() { failures++ })
+
+			client.Send([]byte("lost"))
+			Expect(failures).To(Equal(1))
+		})
+	})
+})
+
+func acceptFrames(listener net.Listener, received chan<- []byte) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				var size uint32
+				if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+					return
+				}
+
+				message := make([]byte, size)
+				if _, err := io.ReadFull(conn, message); err != nil {
+					return
+				}
+				received <- message
+			}
+		}(conn)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("readConfig", func() {
+	var configFile string
+
+	BeforeEach(func() {
+		file, err := ioutil.TempFile("", "metron-config")
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+		configFile = file.Name()
+	})
+
+	AfterEach(func() {
+		os.Remove(configFile)
+	})
+
+	writeConfig := func(config string) {
+		Expect(ioutil.WriteFile(configFile, []byte(config), 0600)).To(Succeed())
+	}
+
+	It("rejects the leastRecentlyFailed strategy over UDP", func() {
+		writeConfig(`{"SharedSecret": "secret", "DopplerSelection": {"Strategy": "leastRecentlyFailed"}}`)
+
+		_, err := readConfig(configFile)
+		Expect(err).To(MatchError(ContainSubstring("leastRecentlyFailed needs PreferredProtocol tls")))
+	})
+
+	It("accepts the leastRecentlyFailed strategy over TLS", func() {
+		writeConfig(`{"SharedSecret": "secret", "PreferredProtocol": "tls", "DopplerSelection": {"Strategy": "leastRecentlyFailed"}}`)
+
+		config, err := readConfig(configFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.DopplerSelection.Strategy).To(Equal("leastRecentlyFailed"))
+	})
+})
@@ -0,0 +1,191 @@
+package health
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+type AddressList interface {
+	GetAddresses() []string
+}
+
+type ForwardStats interface {
+	SentMessages() uint64
+	ForwardErrors() uint64
+	LastSendTime() time.Time
+}
+
+type UnmarshalStats interface {
+	MessagesReceived() uint64
+	UnmarshalErrors() uint64
+}
+
+// Thresholds above which a check reports Metron as unhealthy. Error rates
+// are fractions of the messages seen since the previous check.
+type Thresholds struct {
+	MaxForwardErrorRate   float64
+	MaxUnmarshalErrorRate float64
+	MaxTimeSinceLastSend  time.Duration
+}
+
+// A CheckResult is the outcome of a single health check. It is reported as
+// JSON in the "health" context of the varz endpoint.
+type CheckResult struct {
+	Healthy   bool        `json:"healthy"`
+	Value     interface{} `json:"value"`
+	Threshold interface{} `json:"threshold,omitempty"`
+}
+
+// Health decides whether Metron is healthy from what it is actually doing:
+// whether it knows about any Doppler, how many messages it fails to forward
+// or unmarshal, and how long ago it last sent anything. It satisfies
+// cfcomponent's health monitor, so /healthz reflects these checks too.
+// Until the first check, Metron is reported healthy: the Doppler address
+// lists and message counters need an interval to fill in.
+type Health struct {
+	thresholds   Thresholds
+	addressLists []AddressList
+	forwarder    ForwardStats
+	unmarshaller UnmarshalStats
+	logger       *gosteno.Logger
+
+	startTime    time.Time
+	lastSent     uint64
+	lastErrors   uint64
+	lastReceived uint64
+	lastInvalid  uint64
+	results      map[string]CheckResult
+
+	stopChan chan struct{}
+	lock     sync.RWMutex
+}
+
+func New(thresholds Thresholds, addressLists []AddressList, forwarder ForwardStats, unmarshaller UnmarshalStats, logger *gosteno.Logger) *Health {
+	return &Health{
+		thresholds:   thresholds,
+		addressLists: addressLists,
+		forwarder:    forwarder,
+		unmarshaller: unmarshaller,
+		logger:       logger,
+		startTime:    time.Now(),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Run re-evaluates the checks every interval until Stop is called.
+func (h *Health) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.Check()
+		case <-h.stopChan:
+			return
+		}
+	}
+}
+
+func (h *Health) Stop() {
+	close(h.stopChan)
+}
+
+// Check evaluates every health check. Rates are computed over the messages
+// seen since the previous call.
+func (h *Health) Check() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	results := make(map[string]CheckResult)
+
+	knownAddresses := 0
+	for _, list := range h.addressLists {
+		knownAddresses += len(list.GetAddresses())
+	}
+	results["dopplerAddressesKnown"] = CheckResult{Healthy: knownAddresses > 0, Value: knownAddresses}
+
+	sent, errors := h.forwarder.SentMessages(), h.forwarder.ForwardErrors()
+	forwardErrorRate := errorRate(sent-h.lastSent, errors-h.lastErrors)
+	h.lastSent, h.lastErrors = sent, errors
+	results["forwardErrorRate"] = CheckResult{
+		Healthy:   forwardErrorRate <= h.thresholds.MaxForwardErrorRate,
+		Value:     forwardErrorRate,
+		Threshold: h.thresholds.MaxForwardErrorRate,
+	}
+
+	received, invalid := h.unmarshaller.MessagesReceived(), h.unmarshaller.UnmarshalErrors()
+	unmarshalErrorRate := errorRate(received-h.lastReceived, invalid-h.lastInvalid)
+	h.lastReceived, h.lastInvalid = received, invalid
+	results["unmarshalErrorRate"] = CheckResult{
+		Healthy:   unmarshalErrorRate <= h.thresholds.MaxUnmarshalErrorRate,
+		Value:     unmarshalErrorRate,
+		Threshold: h.thresholds.MaxUnmarshalErrorRate,
+	}
+
+	lastSend := h.forwarder.LastSendTime()
+	if lastSend.IsZero() {
+		lastSend = h.startTime
+	}
+	sinceLastSend := time.Since(lastSend)
+	results["secondsSinceLastSend"] = CheckResult{
+		Healthy:   sinceLastSend <= h.thresholds.MaxTimeSinceLastSend,
+		Value:     sinceLastSend.Seconds(),
+		Threshold: h.thresholds.MaxTimeSinceLastSend.Seconds(),
+	}
+
+	for name, result := range results {
+		if !result.Healthy && (h.results == nil || h.results[name].Healthy) {
+			h.logger.Warnf("Health: check %s failed with value %v", name, result.Value)
+		}
+	}
+	h.results = results
+}
+
+func (h *Health) Ok() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for _, result := range h.results {
+		if !result.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Health) Results() map[string]CheckResult {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	results := make(map[string]CheckResult, len(h.results))
+	for name, result := range h.results {
+		results[name] = result
+	}
+	return results
+}
+
+func (h *Health) Emit() instrumentation.Context {
+	metrics := []instrumentation.Metric{
+		instrumentation.Metric{Name: "ok", Value: h.Ok()},
+	}
+	for name, result := range h.Results() {
+		metrics = append(metrics, instrumentation.Metric{Name: name, Value: result})
+	}
+
+	return instrumentation.Context{
+		Name:    "health",
+		Metrics: metrics,
+	}
+}
+
+func errorRate(successes uint64, failures uint64) float64 {
+	total := successes + failures
+	if total == 0 {
+		return 0
+	}
+	return float64(failures) / float64(total)
+}
@@ -0,0 +1,150 @@
+package health_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"metron/health"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var (
+		addresses    *fakeAddressList
+		forwarder    *fakeForwarder
+		unmarshaller *fakeUnmarshaller
+		h            *health.Health
+		thresholds   health.Thresholds
+	)
+
+	BeforeEach(func() {
+		addresses = &fakeAddressList{addresses: []string{"10.0.0.1"}}
+		forwarder = &fakeForwarder{lastSend: time.Now()}
+		unmarshaller = &fakeUnmarshaller{}
+		thresholds = health.Thresholds{
+			MaxForwardErrorRate:   0.1,
+			MaxUnmarshalErrorRate: 0.1,
+			MaxTimeSinceLastSend:  time.Minute,
+		}
+	})
+
+	JustBeforeEach(func() {
+		h = health.New(thresholds, []health.AddressList{addresses}, forwarder, unmarshaller, loggertesthelper.Logger())
+	})
+
+	It("is healthy when every check passes", func() {
+		h.Check()
+
+		Expect(h.Ok()).To(BeTrue())
+		Expect(h.Results()).To(HaveLen(4))
+	})
+
+	Context("before the first check", func() {
+		BeforeEach(func() {
+			addresses.addresses = nil
+		})
+
+		It("is healthy even though no doppler address is known yet", func() {
+			Expect(h.Ok()).To(BeTrue())
+			Expect(h.Results()).To(BeEmpty())
+		})
+	})
+
+	Context("with an error rate threshold of 0", func() {
+		BeforeEach(func() {
+			thresholds.MaxForwardErrorRate = 0
+		})
+
+		It("is unhealthy after a single forward error", func() {
+			forwarder.sent = 100
+			h.Check()
+			Expect(h.Ok()).To(BeTrue())
+
+			forwarder.errors = 1
+			h.Check()
+			Expect(h.Ok()).To(BeFalse())
+		})
+	})
+
+	It("is unhealthy when no doppler address is known", func() {
+		addresses.addresses = nil
+		h.Check()
+
+		Expect(h.Ok()).To(BeFalse())
+		Expect(h.Results()["dopplerAddressesKnown"].Healthy).To(BeFalse())
+	})
+
+	It("is unhealthy when too many messages fail to forward since the last check", func() {
+		forwarder.sent = 80
+		forwarder.errors = 20
+		h.Check()
+
+		Expect(h.Ok()).To(BeFalse())
+		Expect(h.Results()["forwardErrorRate"].Value).To(Equal(0.2))
+
+		forwarder.sent = 180
+		h.Check()
+		Expect(h.Ok()).To(BeTrue())
+	})
+
+	It("is unhealthy when too many messages fail to unmarshal", func() {
+		unmarshaller.received = 1
+		unmarshaller.errors = 1
+		h.Check()
+
+		Expect(h.Results()["unmarshalErrorRate"].Healthy).To(BeFalse())
+		Expect(h.Ok()).To(BeFalse())
+	})
+
+	It("is unhealthy when nothing has been sent for too long", func() {
+		forwarder.lastSend = time.Now().Add(-2 * time.Minute)
+		h.Check()
+
+		Expect(h.Results()["secondsSinceLastSend"].Healthy).To(BeFalse())
+		Expect(h.Ok()).To(BeFalse())
+	})
+
+	It("reports each check as JSON in its instrumentation context", func() {
+		h.Check()
+		context := h.Emit()
+		Expect(context.Name).To(Equal("health"))
+
+		values := map[string]interface{}{}
+		for _, metric := range context.Metrics {
+			values[metric.Name] = metric.Value
+		}
+		Expect(values["ok"]).To(Equal(true))
+
+		encoded, err := json.Marshal(values["dopplerAddressesKnown"])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encoded).To(MatchJSON(`{"healthy": true, "value": 1}`))
+	})
+})
+
+type fakeAddressList struct {
+	addresses []string
+}
+
+func (f *fakeAddressList) GetAddresses() []string {
+	return f.addresses
+}
+
+type fakeForwarder struct {
+	sent, errors uint64
+	lastSend     time.Time
+}
+
+func (f *fakeForwarder) SentMessages() uint64    { return f.sent }
+func (f *fakeForwarder) ForwardErrors() uint64   { return f.errors }
+func (f *fakeForwarder) LastSendTime() time.Time { return f.lastSend }
+
+type fakeUnmarshaller struct {
+	received, errors uint64
+}
+
+func (f *fakeUnmarshaller) MessagesReceived() uint64 { return f.received }
+func (f *fakeUnmarshaller) UnmarshalErrors() uint64  { return f.errors }
@@ -3,15 +3,34 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
+	"common/signature"
+	"common/tlsconfig"
+
+	metronclientpool "metron/clientpool"
+	"metron/health"
 	"metron/networkreader"
+	"metron/prometheus"
+	"metron/spool"
+	"metron/streamreader"
+	"metron/writers"
+	"metron/writers/batchwriter"
+	"metron/writers/compressor"
 	"metron/writers/dopplerforwarder"
+	"metron/writers/envelopefilter"
 	"metron/writers/eventmarshaller"
 	"metron/writers/eventunmarshaller"
+	"metron/writers/histogramaggregator"
+	"metron/writers/httpmetrics"
 	"metron/writers/legacyunmarshaller"
 	"metron/writers/messageaggregator"
+	"metron/writers/ratelimiter"
 	"metron/writers/signer"
+	"metron/writers/statsdunmarshaller"
+	"metron/writers/switchwriter"
 	"metron/writers/tagger"
 	"metron/writers/varzforwarder"
 
@@ -23,7 +42,7 @@ import (
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/registrars/collectorregistrar"
-	"github.com/cloudfoundry/loggregatorlib/clientpool"
+	"github.com/cloudfoundry/loggregatorlib/loggregatorclient"
 	"github.com/cloudfoundry/loggregatorlib/servicediscovery"
 	"github.com/cloudfoundry/storeadapter"
 	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
@@ -42,50 +61,201 @@ var (
 var metricTTL = time.Hour
 
 func main() {
-	// Metron is intended to be light-weight so we occupy only one core
-	runtime.GOMAXPROCS(1)
-
 	flag.Parse()
 	config, logger := parseConfig(*debug, *configFilePath, *logFilePath)
 
-	dopplerClientPool := initializeClientPool(config, logger)
+	// Metron is intended to be light-weight so we occupy only one core,
+	// unless more ingestion workers were explicitly asked for
+	runtime.GOMAXPROCS(config.IngestionWorkers)
+
+	dopplerAddressLists := initializeAddressLists(config, logger)
+	dopplerClientPool := initializeClientPool(config, dopplerAddressLists, logger)
 
-	dopplerForwarder := dopplerforwarder.New(dopplerClientPool, logger)
-	byteSigner := signer.New(config.SharedSecret, dopplerForwarder)
-	marshaller := eventmarshaller.New(byteSigner, logger)
+	dopplerForwarder, messageSpool := initializeDopplerForwarder(dopplerClientPool, config, logger)
+	batchWriter := newBatchWriter(config, dopplerForwarder, logger)
+	dopplerWriter := switchwriter.NewByteArrayWriter(newDopplerWriter(config, batchWriter))
+	messageWriter, messageCompressor := initializeCompressor(config, dopplerWriter, logger)
+	marshaller := eventmarshaller.New(messageWriter, logger)
 	varzShim := varzforwarder.New(config.Job, metricTTL, marshaller, logger)
-	messageTagger := tagger.New(config.Deployment, config.Job, config.Index, varzShim)
-	aggregator := messageaggregator.New(messageTagger, logger)
+	messageTagger := switchwriter.NewEnvelopeWriter(newTagger(config, varzShim))
+	httpMetrics := initializeHTTPMetrics(config, messageTagger, logger)
+	var aggregatorOutput writers.EnvelopeWriter = messageTagger
+	if httpMetrics != nil {
+		aggregatorOutput = httpMetrics
+	}
+	aggregator := messageaggregator.New(aggregatorOutput, logger)
 
-	initializeMetrics(byteSigner, config, logger)
+	metricsMarshaller := eventmarshaller.New(messageWriter, logger)
+	metricsTagger := switchwriter.NewEnvelopeWriter(newTagger(config, metricsMarshaller))
+	metricSender, metricBatcher := initializeMetrics(metricsTagger, config, logger)
 
-	dropsondeUnmarshaller := eventunmarshaller.New(aggregator, logger)
-	dropsondeReader := networkreader.New(fmt.Sprintf("localhost:%d", config.DropsondeIncomingMessagesPort), "dropsondeAgentListener", dropsondeUnmarshaller, logger)
+	histogramAggregator, err := histogramaggregator.New(config.HistogramMetricNames, config.HistogramPercentiles, time.Duration(config.HistogramWindowSeconds)*time.Second, aggregator, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	rateLimiter, err := ratelimiter.New(config.RateLimits, time.Duration(config.RateLimitSummaryIntervalSeconds)*time.Second, histogramAggregator, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	envelopeFilter, err := envelopefilter.New(config.FilterRules, rateLimiter, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	dropsondeUnmarshaller := eventunmarshaller.New(envelopeFilter, logger)
+	readerConfig := networkreader.Config{Workers: config.IngestionWorkers, ReceiveBufferBytes: config.ReceiveBufferBytes}
+	dropsondeReader := networkreader.NewWithConfig(fmt.Sprintf("localhost:%d", config.DropsondeIncomingMessagesPort), "dropsondeAgentListener", readerConfig, dropsondeUnmarshaller, logger)
+
+	streamReaders := initializeStreamReaders(config, dropsondeUnmarshaller, logger)
 
 	// TODO: remove next four lines when legacy support is removed (or extracted to injector)
-	legacyMarshaller := eventmarshaller.New(byteSigner, logger)
-	legacyMessageTagger := tagger.New(config.Deployment, config.Job, config.Index, legacyMarshaller)
-	legacyUnmarshaller := legacyunmarshaller.New(legacyMessageTagger, logger)
+	legacyMarshaller := eventmarshaller.New(messageWriter, logger)
+	legacyMessageTagger := switchwriter.NewEnvelopeWriter(newTagger(config, legacyMarshaller))
+	legacyUnmarshaller := legacyunmarshaller.NewWithConfig(config.LegacyValidation, legacyMessageTagger, logger)
 	legacyReader := networkreader.New(fmt.Sprintf("localhost:%d", config.LegacyIncomingMessagesPort), "legacyAgentListener", legacyUnmarshaller, logger)
 
+	statsdUnmarshaller, statsdReader := initializeStatsdReader(config, envelopeFilter, logger)
+
+	healthMonitor := initializeHealth(config, dopplerClientPool, dopplerForwarder, dropsondeUnmarshaller, logger)
+
+	configReloader := &reloader{
+		configFile:      *configFilePath,
+		config:          config,
+		logger:          logger,
+		readConfig:      readConfig,
+		newAddressLists: initializeAddressLists,
+		addressLists:    dopplerAddressLists,
+		clientPool:      dopplerClientPool,
+		batchWriter:     batchWriter,
+		dopplerWriter:   dopplerWriter,
+		taggers: []taggerSwitch{
+			{messageTagger, varzShim},
+			{legacyMessageTagger, legacyMarshaller},
+			{metricsTagger, metricsMarshaller},
+		},
+		metricSender:  metricSender,
+		metricBatcher: metricBatcher,
+		stopChan:      make(chan struct{}),
+		doneChan:      make(chan struct{}),
+	}
+	go configReloader.Run()
+
 	instrumentables := []instrumentation.Instrumentable{
+		healthMonitor,
+		dopplerClientPool,
 		legacyReader,
 		dropsondeReader,
 		legacyUnmarshaller,
 		dropsondeUnmarshaller,
+		envelopeFilter,
+		rateLimiter,
+		histogramAggregator,
 		aggregator,
 		varzShim,
 		marshaller,
 	}
+	if instrumentable, ok := batchWriter.(instrumentation.Instrumentable); ok {
+		instrumentables = append(instrumentables, instrumentable)
+	}
+	if messageSpool != nil {
+		instrumentables = append(instrumentables, messageSpool)
+	}
+	if httpMetrics != nil {
+		instrumentables = append(instrumentables, httpMetrics)
+	}
+	if messageCompressor != nil {
+		instrumentables = append(instrumentables, messageCompressor)
+	}
+	for _, reader := range streamReaders {
+		instrumentables = append(instrumentables, reader)
+	}
+	if statsdReader != nil {
+		instrumentables = append(instrumentables, statsdReader, statsdUnmarshaller)
+	}
+
+	go healthMonitor.Run(time.Duration(config.HealthCheckIntervalSeconds) * time.Second)
+	go startMonitoringEndpoints(config, healthMonitor, instrumentables, logger)
 
-	go startMonitoringEndpoints(config, instrumentables, logger)
+	if config.PrometheusPort != 0 {
+		go startPrometheusEndpoint(config, varzShim, instrumentables, logger)
+	}
+
+	for _, reader := range streamReaders {
+		go reader.Start()
+	}
+
+	if statsdReader != nil {
+		go statsdReader.Start()
+	}
 
 	go legacyReader.Start()
-	dropsondeReader.Start()
+	go dropsondeReader.Start()
+
+	readers := []messageReader{dropsondeReader, legacyReader}
+	for _, reader := range streamReaders {
+		readers = append(readers, reader)
+	}
+	if statsdReader != nil {
+		readers = append(readers, statsdReader)
+	}
+
+	shutdown := &shutdownHandler{
+		timeout:               time.Duration(config.ShutdownTimeoutSeconds) * time.Second,
+		logger:                logger,
+		readers:               readers,
+		reloader:              configReloader,
+		rateLimiter:           rateLimiter,
+		histogramAggregator:   histogramAggregator,
+		httpMetrics:           httpMetrics,
+		batchWriter:           batchWriter,
+		clientPool:            dopplerClientPool,
+		messageSpool:          messageSpool,
+		healthMonitor:         healthMonitor,
+		dropsondeUnmarshaller: dropsondeUnmarshaller,
+		envelopeFilter:        envelopeFilter,
+		dopplerForwarder:      dopplerForwarder,
+	}
+	shutdown.Wait()
 }
 
-func startMonitoringEndpoints(config metronConfig, instrumentables []instrumentation.Instrumentable, logger *gosteno.Logger) {
-	component := initializeComponent(config, instrumentables, logger)
+func initializeStreamReaders(config metronConfig, dropsondeUnmarshaller writers.ByteArrayWriter, logger *gosteno.Logger) []*streamreader.StreamReader {
+	var readers []*streamreader.StreamReader
+	if config.DropsondeIncomingTCPPort != 0 {
+		address := fmt.Sprintf("localhost:%d", config.DropsondeIncomingTCPPort)
+		readers = append(readers, streamreader.New("tcp", address, "dropsondeTCPListener", dropsondeUnmarshaller, logger))
+	}
+	if config.DropsondeIncomingUnixSocket != "" {
+		readers = append(readers, streamreader.New("unix", config.DropsondeIncomingUnixSocket, "dropsondeUnixListener", dropsondeUnmarshaller, logger))
+	}
+	return readers
+}
+
+func initializeHTTPMetrics(config metronConfig, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) *httpmetrics.HTTPMetrics {
+	if config.HttpMetricsIntervalSeconds == 0 {
+		return nil
+	}
+
+	httpMetrics, err := httpmetrics.New(config.HttpMetricsPercentiles, time.Duration(config.HttpMetricsIntervalSeconds)*time.Second, outputWriter, logger)
+	if err != nil {
+		panic(err)
+	}
+	return httpMetrics
+}
+
+func initializeStatsdReader(config metronConfig, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) (*statsdunmarshaller.StatsdUnmarshaller, *networkreader.NetworkReader) {
+	if config.StatsdIncomingMessagesPort == 0 {
+		return nil, nil
+	}
+
+	statsdUnmarshaller := statsdunmarshaller.New(config.StatsdOrigin, outputWriter, logger)
+	statsdReader := networkreader.New(fmt.Sprintf("localhost:%d", config.StatsdIncomingMessagesPort), "statsdAgentListener", statsdUnmarshaller, logger)
+	return statsdUnmarshaller, statsdReader
+}
+
+func startMonitoringEndpoints(config metronConfig, healthMonitor *health.Health, instrumentables []instrumentation.Instrumentable, logger *gosteno.Logger) {
+	component := initializeComponent(config, healthMonitor, instrumentables, logger)
 	go collectorregistrar.NewCollectorRegistrar(cfcomponent.DefaultYagnatsClientProvider, component, time.Duration(config.CollectorRegistrarIntervalMilliseconds)*time.Millisecond, &config.Config).Run()
 
 	if err := component.StartMonitoringEndpoints(); err != nil {
@@ -93,7 +263,32 @@ func startMonitoringEndpoints(config metronConfig, instrumentables []instrumenta
 	}
 }
 
-func initializeClientPool(config metronConfig, logger *gosteno.Logger) *clientpool.LoggregatorClientPool {
+func startPrometheusEndpoint(config metronConfig, varzShim *varzforwarder.VarzForwarder, instrumentables []instrumentation.Instrumentable, logger *gosteno.Logger) {
+	labels := map[string]string{
+		"deployment": config.Deployment,
+		"job":        config.Job,
+		"index":      strconv.Itoa(int(config.Index)),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.New(varzShim, instrumentables, labels))
+
+	address := fmt.Sprintf(":%d", config.PrometheusPort)
+	logger.Infof("Startup: Serving Prometheus metrics on %s/metrics", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logger.Errorf("Prometheus endpoint failed: %v", err)
+	}
+}
+
+// dopplerAddressLists are the in-zone and all-zone Doppler addresses found in
+// etcd, together with the connection they are read through.
+type dopplerAddressLists struct {
+	adapter storeadapter.StoreAdapter
+	inZone  servicediscovery.ServerAddressList
+	allZone servicediscovery.ServerAddressList
+}
+
+func initializeAddressLists(config metronConfig, logger *gosteno.Logger) *dopplerAddressLists {
 	adapter := storeAdapterProvider(config.EtcdUrls, config.EtcdMaxConcurrentRequests)
 	err := adapter.Connect()
 	if err != nil {
@@ -106,19 +301,122 @@ func initializeClientPool(config metronConfig, logger *gosteno.Logger) *clientpo
 	go inZoneServerAddressList.Run(time.Duration(config.EtcdQueryIntervalMilliseconds) * time.Millisecond)
 	go allZoneServerAddressList.Run(time.Duration(config.EtcdQueryIntervalMilliseconds) * time.Millisecond)
 
-	clientPool := clientpool.NewLoggregatorClientPool(logger, config.LoggregatorDropsondePort, inZoneServerAddressList, allZoneServerAddressList)
-	return clientPool
+	return &dopplerAddressLists{
+		adapter: adapter,
+		inZone:  inZoneServerAddressList,
+		allZone: allZoneServerAddressList,
+	}
+}
+
+func (lists *dopplerAddressLists) Stop() {
+	lists.inZone.Stop()
+	lists.allZone.Stop()
+	lists.adapter.Disconnect()
+}
+
+func initializeClientPool(config metronConfig, addressLists *dopplerAddressLists, logger *gosteno.Logger) *metronclientpool.DopplerPool {
+	selection := config.DopplerSelection
+	strategy, err := metronclientpool.NewSelectionStrategy(selection.Strategy, selection.InZoneWeight, time.Duration(selection.EjectionSeconds)*time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	if config.PreferredProtocol == "tls" {
+		tlsConfig, err := tlsconfig.NewClientConfig(config.TLSConfig.CertFile, config.TLSConfig.KeyFile, config.TLSConfig.CAFile, "doppler")
+		if err != nil {
+			panic(err)
+		}
+
+		return metronclientpool.NewDopplerPoolWithStrategy(logger, config.TLSConfig.Port, addressLists.inZone, addressLists.allZone, strategy, func(address string) loggregatorclient.LoggregatorClient {
+			return metronclientpool.NewTLSClient(address, tlsConfig, logger)
+		})
+	}
+
+	return metronclientpool.NewDopplerPoolWithStrategy(logger, config.LoggregatorDropsondePort, addressLists.inZone, addressLists.allZone, strategy, func(address string) loggregatorclient.LoggregatorClient {
+		return loggregatorclient.NewLoggregatorClient(address, logger, loggregatorclient.DefaultBufferSize)
+	})
 }
 
-func initializeMetrics(byteSigner *signer.Signer, config metronConfig, logger *gosteno.Logger) {
-	metricsMarshaller := eventmarshaller.New(byteSigner, logger)
-	metricsTagger := tagger.New(config.Deployment, config.Job, config.Index, metricsMarshaller)
+func initializeHealth(config metronConfig, dopplerClientPool *metronclientpool.DopplerPool, dopplerForwarder *dopplerforwarder.DopplerForwarder, dropsondeUnmarshaller *eventunmarshaller.EventUnmarshaller, logger *gosteno.Logger) *health.Health {
+	thresholds := health.Thresholds{
+		MaxForwardErrorRate:   *config.HealthMaxForwardErrorRate,
+		MaxUnmarshalErrorRate: *config.HealthMaxUnmarshalErrorRate,
+		MaxTimeSinceLastSend:  time.Duration(*config.HealthMaxSecondsSinceLastSend) * time.Second,
+	}
+	return health.New(thresholds, []health.AddressList{dopplerClientPool}, dopplerForwarder, dropsondeUnmarshaller, logger)
+}
+
+func initializeDopplerForwarder(clientPool dopplerforwarder.ClientPool, config metronConfig, logger *gosteno.Logger) (*dopplerforwarder.DopplerForwarder, *spool.Spool) {
+	if config.SpoolDirectory == "" {
+		return dopplerforwarder.New(clientPool, logger), nil
+	}
+
+	messageSpool, err := spool.New(config.SpoolDirectory, config.SpoolMaxBytes, config.SpoolSegmentBytes, logger)
+	if err != nil {
+		panic(err)
+	}
+	return dopplerforwarder.NewWithSpool(clientPool, messageSpool, logger), messageSpool
+}
+
+// Batching only applies to UDP, where every datagram costs a syscall on both
+// ends. The TLS transport is a stream and already frames each message.
+func newBatchWriter(config metronConfig, dopplerForwarder *dopplerforwarder.DopplerForwarder, logger *gosteno.Logger) writers.ByteArrayWriter {
+	if config.PreferredProtocol == "tls" || !config.EnableBatching {
+		return dopplerForwarder
+	}
+	return batchwriter.New(dopplerForwarder, config.BatchMaxBytes, time.Duration(config.BatchIntervalMilliseconds)*time.Millisecond, logger)
+}
+
+// Compression happens before signing, so Doppler verifies what was sent over
+// the wire and only then decompresses it.
+func initializeCompressor(config metronConfig, dopplerWriter writers.ByteArrayWriter, logger *gosteno.Logger) (writers.ByteArrayWriter, *compressor.Compressor) {
+	if config.Compression == "" {
+		return dopplerWriter, nil
+	}
+
+	messageCompressor, err := compressor.New(config.Compression, dopplerWriter, logger)
+	if err != nil {
+		panic(err)
+	}
+	return messageCompressor, messageCompressor
+}
+
+// Envelopes sent over TLS are authenticated by the connection itself, so only
+// UDP traffic needs to be signed.
+func newDopplerWriter(config metronConfig, dopplerForwarder writers.ByteArrayWriter) writers.ByteArrayWriter {
+	if config.PreferredProtocol == "tls" {
+		return dopplerForwarder
+	}
+	return signer.New(config.SharedSecret, dopplerForwarder)
+}
+
+// currentSharedSecret picks the key Metron signs with while secrets are being
+// rotated. Without a CurrentSharedSecretId the plain SharedSecret is used.
+func currentSharedSecret(config metronConfig) (string, error) {
+	if config.CurrentSharedSecretId == "" {
+		return config.SharedSecret, nil
+	}
+
+	for _, key := range config.SharedSecrets {
+		if key.Id == config.CurrentSharedSecretId {
+			return key.Secret, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown CurrentSharedSecretId %q", config.CurrentSharedSecretId)
+}
+
+func newTagger(config metronConfig, outputWriter writers.EnvelopeWriter) *tagger.Tagger {
+	return tagger.New(config.Deployment, config.Job, config.Index, config.Tags, outputWriter)
+}
+
+func initializeMetrics(metricsTagger writers.EnvelopeWriter, config metronConfig, logger *gosteno.Logger) (metric_sender.MetricSender, *metricbatcher.MetricBatcher) {
 	metricsAggregator := messageaggregator.New(metricsTagger, logger)
 
 	eventWriter := eventwriter.New("MetronAgent", metricsAggregator)
 	metricSender := metric_sender.NewMetricSender(eventWriter)
 	metricBatcher := metricbatcher.New(metricSender, time.Duration(config.MetricBatchIntervalSeconds)*time.Second)
 	metrics.Initialize(metricSender, metricBatcher)
+	return metricSender, metricBatcher
 }
 
 func storeAdapterProvider(urls []string, concurrentRequests int) storeadapter.StoreAdapter {
@@ -130,7 +428,7 @@ func storeAdapterProvider(urls []string, concurrentRequests int) storeadapter.St
 	return etcdstoreadapter.NewETCDStoreAdapter(urls, workPool)
 }
 
-func initializeComponent(config metronConfig, instrumentables []instrumentation.Instrumentable, logger *gosteno.Logger) cfcomponent.Component {
+func initializeComponent(config metronConfig, healthMonitor *health.Health, instrumentables []instrumentation.Instrumentable, logger *gosteno.Logger) cfcomponent.Component {
 	if len(config.NatsHosts) == 0 {
 		logger.Warn("Startup: Did not receive a NATS host - not going to register component")
 		cfcomponent.DefaultYagnatsClientProvider = func(logger *gosteno.Logger, c *cfcomponent.Config) (yagnats.NATSConn, error) {
@@ -138,7 +436,7 @@ func initializeComponent(config metronConfig, instrumentables []instrumentation.
 		}
 	}
 
-	component, err := cfcomponent.NewComponent(logger, "MetronAgent", config.Index, &metronHealthMonitor{}, config.VarzPort, []string{config.VarzUser, config.VarzPass}, instrumentables)
+	component, err := cfcomponent.NewComponent(logger, "MetronAgent", config.Index, healthMonitor, config.VarzPort, []string{config.VarzUser, config.VarzPass}, instrumentables)
 	if err != nil {
 		panic(err)
 	}
@@ -147,20 +445,105 @@ func initializeComponent(config metronConfig, instrumentables []instrumentation.
 }
 
 func parseConfig(debug bool, configFile string, logFilePath string) (metronConfig, *gosteno.Logger) {
+	config, err := readConfig(configFile)
+	if err != nil {
+		panic(err)
+	}
+
+	logger := cfcomponent.NewLogger(debug, logFilePath, "metron", config.Config)
+	logger.Info("Startup: Setting up the Metron agent")
+
+	return config, logger
+}
+
+// readConfig reads the config file, fills in defaults and validates it.
+func readConfig(configFile string) (metronConfig, error) {
 	config := metronConfig{}
 	err := cfcomponent.ReadConfigInto(&config, configFile)
 	if err != nil {
-		panic(err)
+		return config, err
 	}
 
 	if config.MetricBatchIntervalSeconds == 0 {
 		config.MetricBatchIntervalSeconds = 15
 	}
 
-	logger := cfcomponent.NewLogger(debug, logFilePath, "metron", config.Config)
-	logger.Info("Startup: Setting up the Metron agent")
+	if config.HistogramWindowSeconds == 0 {
+		config.HistogramWindowSeconds = 10
+	}
 
-	return config, logger
+	if config.RateLimitSummaryIntervalSeconds == 0 {
+		config.RateLimitSummaryIntervalSeconds = 60
+	}
+
+	if config.ShutdownTimeoutSeconds == 0 {
+		config.ShutdownTimeoutSeconds = 10
+	}
+
+	if config.HealthCheckIntervalSeconds == 0 {
+		config.HealthCheckIntervalSeconds = 10
+	}
+
+	// The health thresholds may be set to 0 on purpose, so only missing
+	// ones get a default.
+	if config.HealthMaxForwardErrorRate == nil {
+		defaultRate := 0.1
+		config.HealthMaxForwardErrorRate = &defaultRate
+	}
+
+	if config.HealthMaxUnmarshalErrorRate == nil {
+		defaultRate := 0.1
+		config.HealthMaxUnmarshalErrorRate = &defaultRate
+	}
+
+	if config.HealthMaxSecondsSinceLastSend == nil {
+		defaultSeconds := uint(60)
+		config.HealthMaxSecondsSinceLastSend = &defaultSeconds
+	}
+
+	if config.StatsdOrigin == "" {
+		config.StatsdOrigin = "statsd"
+	}
+
+	if config.IngestionWorkers == 0 {
+		config.IngestionWorkers = 1
+	}
+
+	if config.BatchMaxBytes == 0 {
+		config.BatchMaxBytes = 16384
+	}
+
+	if config.BatchIntervalMilliseconds == 0 {
+		config.BatchIntervalMilliseconds = 100
+	}
+
+	if config.SpoolMaxBytes == 0 {
+		config.SpoolMaxBytes = 100 * 1024 * 1024
+	}
+
+	if config.SpoolSegmentBytes == 0 {
+		config.SpoolSegmentBytes = 1024 * 1024
+	}
+
+	if config.DopplerSelection.EjectionSeconds == 0 {
+		config.DopplerSelection.EjectionSeconds = 30
+	}
+
+	if config.PreferredProtocol == "" {
+		config.PreferredProtocol = "udp"
+	}
+
+	if config.PreferredProtocol != "udp" && config.PreferredProtocol != "tls" {
+		return config, fmt.Errorf("Invalid PreferredProtocol %q, must be udp or tls", config.PreferredProtocol)
+	}
+
+	// UDP sends never fail, so leastRecentlyFailed would just be random.
+	if config.DopplerSelection.Strategy == "leastRecentlyFailed" && config.PreferredProtocol != "tls" {
+		return config, fmt.Errorf("DopplerSelection strategy leastRecentlyFailed needs PreferredProtocol tls, got %q", config.PreferredProtocol)
+	}
+
+	config.SharedSecret, err = currentSharedSecret(config)
+	return config, err
 }
 
 type metronConfig struct {
@@ -170,9 +553,17 @@ type metronConfig struct {
 	Zone       string
 	Job        string
 	Index      uint
+	Tags       map[string]string
 
 	LegacyIncomingMessagesPort    int
+	LegacyValidation              legacyunmarshaller.Config
 	DropsondeIncomingMessagesPort int
+	IngestionWorkers              int
+	ReceiveBufferBytes            int
+	DropsondeIncomingTCPPort      int
+	DropsondeIncomingUnixSocket   string
+	StatsdIncomingMessagesPort    int
+	StatsdOrigin                  string
 
 	EtcdUrls                      []string
 	EtcdMaxConcurrentRequests     int
@@ -180,12 +571,55 @@ type metronConfig struct {
 
 	LoggregatorDropsondePort int
 	SharedSecret             string
+	SharedSecrets            []signature.Key
+	CurrentSharedSecretId    string
+
+	PreferredProtocol string
+	TLSConfig         tlsConfig
+	DopplerSelection  dopplerSelection
+
+	Compression string
+
+	EnableBatching            bool
+	BatchMaxBytes             int
+	BatchIntervalMilliseconds uint
+
+	SpoolDirectory    string
+	SpoolMaxBytes     int64
+	SpoolSegmentBytes int64
+
+	FilterRules []envelopefilter.Rule
+
+	RateLimits                      []ratelimiter.Limit
+	RateLimitSummaryIntervalSeconds uint
+
+	HistogramMetricNames   []string
+	HistogramPercentiles   []float64
+	HistogramWindowSeconds uint
+
+	HttpMetricsIntervalSeconds uint
+	HttpMetricsPercentiles     []float64
+
+	HealthCheckIntervalSeconds    uint
+	HealthMaxForwardErrorRate     *float64
+	HealthMaxUnmarshalErrorRate   *float64
+	HealthMaxSecondsSinceLastSend *uint
 
 	MetricBatchIntervalSeconds uint
+	PrometheusPort             int
+
+	ShutdownTimeoutSeconds uint
 }
 
-type metronHealthMonitor struct{}
+type dopplerSelection struct {
+	Strategy        string
+	InZoneWeight    float64
+	EjectionSeconds uint
+}
 
-func (*metronHealthMonitor) Ok() bool {
-	return true
+type tlsConfig struct {
+	Port     int
+	CertFile string
+	KeyFile  string
+	CAFile   string
 }
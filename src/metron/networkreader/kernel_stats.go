@@ -0,0 +1,66 @@
+package networkreader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// KernelStats are the kernel's counters for the UDP sockets bound to a port,
+// summed over every socket sharing it.
+type KernelStats struct {
+	Drops             uint64
+	ReceiveQueueBytes uint64
+}
+
+// ParseProcNetUDP reads the format of /proc/net/udp and returns the stats for
+// the sockets bound to the given local port.
+func ParseProcNetUDP(reader io.Reader, port int) (KernelStats, error) {
+	var stats KernelStats
+	scanner := bufio.NewScanner(reader)
+
+	// The first line is a header.
+	if !scanner.Scan() {
+		return stats, scanner.Err()
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 {
+			return stats, fmt.Errorf("unexpected line in /proc/net/udp: %q", scanner.Text())
+		}
+
+		localAddress := strings.Split(fields[1], ":")
+		if len(localAddress) != 2 {
+			return stats, fmt.Errorf("unexpected local address %q", fields[1])
+		}
+		localPort, err := strconv.ParseUint(localAddress[1], 16, 16)
+		if err != nil {
+			return stats, err
+		}
+		if int(localPort) != port {
+			continue
+		}
+
+		queues := strings.Split(fields[4], ":")
+		if len(queues) != 2 {
+			return stats, fmt.Errorf("unexpected queue sizes %q", fields[4])
+		}
+		receiveQueue, err := strconv.ParseUint(queues[1], 16, 64)
+		if err != nil {
+			return stats, err
+		}
+
+		drops, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			return stats, err
+		}
+
+		stats.ReceiveQueueBytes += receiveQueue
+		stats.Drops += drops
+	}
+
+	return stats, scanner.Err()
+}
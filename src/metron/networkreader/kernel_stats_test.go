@@ -0,0 +1,36 @@
+package networkreader_test
+
+import (
+	"strings"
+
+	"metron/networkreader"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseProcNetUDP", func() {
+	const procNetUDP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  155: 0100007F:0D81 00000000:0000 07 00000000:00000300 00:00000000 00000000  1000        0 12345 2 ffff880000000000 7
+  156: 0100007F:0D81 00000000:0000 07 00000000:00000100 00:00000000 00000000  1000        0 12346 2 ffff880000000001 3
+  200: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 10000 2 ffff880000000002 42
+`
+
+	It("sums the drops and receive queues of the sockets on the port", func() {
+		stats, err := networkreader.ParseProcNetUDP(strings.NewReader(procNetUDP), 3457)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Drops).To(BeEquivalentTo(10))
+		Expect(stats.ReceiveQueueBytes).To(BeEquivalentTo(0x400))
+	})
+
+	It("returns zeros when no socket is bound to the port", func() {
+		stats, err := networkreader.ParseProcNetUDP(strings.NewReader(procNetUDP), 1234)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(networkreader.KernelStats{}))
+	})
+
+	It("returns an error for malformed input", func() {
+		_, err := networkreader.ParseProcNetUDP(strings.NewReader("header\ngarbage\n"), 3457)
+		Expect(err).To(HaveOccurred())
+	})
+})
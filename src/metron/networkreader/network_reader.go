@@ -4,6 +4,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"metron/writers"
 
@@ -12,13 +13,32 @@ import (
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 )
 
+// KernelStatsInterval is how often the kernel's UDP counters for the
+// reader's port are polled.
+var KernelStatsInterval = 15 * time.Second
+
+// Config tunes how a NetworkReader reads from its port. Workers greater than
+// one start that many readers; where SO_REUSEPORT is available each worker
+// gets its own socket so the kernel spreads datagrams between them.
+// ReceiveBufferBytes sets SO_RCVBUF on every socket when non-zero.
+type Config struct {
+	Workers            int
+	ReceiveBufferBytes int
+}
+
 type NetworkReader struct {
-	host       string
-	connection net.PacketConn
-	writer     writers.ByteArrayWriter
+	host        string
+	config      Config
+	connections []net.PacketConn
+	writer      writers.ByteArrayWriter
+	stopChan    chan struct{}
+	stopOnce    sync.Once
+	doneChan    chan struct{}
 
 	receivedMessageCount uint64
 	receivedByteCount    uint64
+	kernelDropCount      uint64
+	receiveQueueBytes    uint64
 	contextName          string
 
 	lock   sync.RWMutex
@@ -26,24 +46,87 @@ type NetworkReader struct {
 }
 
 func New(host string, name string, writer writers.ByteArrayWriter, logger *gosteno.Logger) *NetworkReader {
+	return NewWithConfig(host, name, Config{Workers: 1}, writer, logger)
+}
+
+func NewWithConfig(host string, name string, config Config, writer writers.ByteArrayWriter, logger *gosteno.Logger) *NetworkReader {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+
 	return &NetworkReader{
 		host:        host,
+		config:      config,
 		contextName: name,
 		writer:      writer,
 		logger:      logger,
+		stopChan:    make(chan struct{}),
+		doneChan:    make(chan struct{}),
 	}
 }
 
 func (nr *NetworkReader) Start() {
-	connection, err := net.ListenPacket("udp4", nr.host)
+	connections, err := nr.listen()
 	if err != nil {
 		nr.logger.Fatalf("Failed to listen on port. %s", err)
 	}
 	nr.logger.Infof("Listening on port %s", nr.host)
 	nr.lock.Lock()
-	nr.connection = connection
+	select {
+	case <-nr.stopChan:
+		nr.lock.Unlock()
+		closeAll(connections)
+		return
+	default:
+	}
+	nr.connections = connections
+	nr.lock.Unlock()
+	defer close(nr.doneChan)
+
+	var wg sync.WaitGroup
+	port := connections[0].LocalAddr().(*net.UDPAddr).Port
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		nr.pollKernelStats(port)
+	}()
+
+	for i := 0; i < nr.config.Workers; i++ {
+		wg.Add(1)
+		go func(connection net.PacketConn) {
+			defer wg.Done()
+			nr.read(connection)
+		}(connections[i%len(connections)])
+	}
+	wg.Wait()
+}
+
+// Stop closes the reader's sockets and, if it was started, waits until the
+// messages it already read have been handed to its writer.
+func (nr *NetworkReader) Stop() {
+	nr.lock.Lock()
+	nr.stopOnce.Do(func() { close(nr.stopChan) })
+	started := nr.connections != nil
+	closeAll(nr.connections)
 	nr.lock.Unlock()
 
+	if started {
+		<-nr.doneChan
+	}
+}
+
+// ReceivedMessages returns the number of messages read from the port.
+func (nr *NetworkReader) ReceivedMessages() uint64 {
+	return atomic.LoadUint64(&nr.receivedMessageCount)
+}
+
+func (nr *NetworkReader) Emit() instrumentation.Context {
+	return instrumentation.Context{Name: nr.contextName,
+		Metrics: nr.metrics(),
+	}
+}
+
+func (nr *NetworkReader) read(connection net.PacketConn) {
 	readBuffer := make([]byte, 65535) //buffer with size = max theoretical UDP size
 	for {
 		readCount, senderAddr, err := connection.ReadFrom(readBuffer)
@@ -63,21 +146,84 @@ func (nr *NetworkReader) Start() {
 	}
 }
 
-func (nr *NetworkReader) Stop() {
-	nr.lock.Lock()
-	defer nr.lock.Unlock()
-	nr.connection.Close()
+// listen opens one socket per worker when the platform supports
+// SO_REUSEPORT, and a single shared socket otherwise.
+func (nr *NetworkReader) listen() ([]net.PacketConn, error) {
+	count := 1
+	if nr.config.Workers > 1 && reusePortSupported {
+		count = nr.config.Workers
+	}
+
+	var connections []net.PacketConn
+	address := nr.host
+	for i := 0; i < count; i++ {
+		var connection net.PacketConn
+		var err error
+		if count > 1 {
+			connection, err = listenReusePort(address)
+		} else {
+			connection, err = net.ListenPacket("udp4", address)
+		}
+		if err != nil {
+			closeAll(connections)
+			return nil, err
+		}
+
+		if nr.config.ReceiveBufferBytes > 0 {
+			if err := connection.(*net.UDPConn).SetReadBuffer(nr.config.ReceiveBufferBytes); err != nil {
+				nr.logger.Warnf("NetworkReader: failed to set receive buffer size to %d: %s", nr.config.ReceiveBufferBytes, err)
+			}
+		}
+
+		// Later sockets must bind the port the first one got, in case the
+		// configured port was 0.
+		address = connection.LocalAddr().String()
+		connections = append(connections, connection)
+	}
+
+	return connections, nil
 }
 
-func (nr *NetworkReader) Emit() instrumentation.Context {
-	return instrumentation.Context{Name: nr.contextName,
-		Metrics: nr.metrics(),
+func closeAll(connections []net.PacketConn) {
+	for _, connection := range connections {
+		connection.Close()
+	}
+}
+
+func (nr *NetworkReader) pollKernelStats(port int) {
+	ticker := time.NewTicker(KernelStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		nr.updateKernelStats(port)
+
+		select {
+		case <-nr.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (nr *NetworkReader) updateKernelStats(port int) {
+	stats, err := readKernelStats(port)
+	if err != nil {
+		nr.logger.Debugf("NetworkReader: unable to read kernel UDP stats: %s", err)
+		return
+	}
+
+	previous := atomic.SwapUint64(&nr.kernelDropCount, stats.Drops)
+	if stats.Drops > previous {
+		metrics.BatchAddCounter(nr.contextName+".kernelDropCount", stats.Drops-previous)
 	}
+	atomic.StoreUint64(&nr.receiveQueueBytes, stats.ReceiveQueueBytes)
 }
 
 func (nr *NetworkReader) metrics() []instrumentation.Metric {
 	return []instrumentation.Metric{
 		instrumentation.Metric{Name: "receivedMessageCount", Value: atomic.LoadUint64(&nr.receivedMessageCount)},
 		instrumentation.Metric{Name: "receivedByteCount", Value: atomic.LoadUint64(&nr.receivedByteCount)},
+		instrumentation.Metric{Name: "kernelDropCount", Value: atomic.LoadUint64(&nr.kernelDropCount)},
+		instrumentation.Metric{Name: "receiveQueueBytes", Value: atomic.LoadUint64(&nr.receiveQueueBytes)},
 	}
 }
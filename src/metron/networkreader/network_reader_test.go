@@ -3,6 +3,7 @@ package networkreader_test
 import (
 	"fmt"
 	"net"
+	"time"
 
 	"metron/networkreader"
 	"metron/writers/mocks"
@@ -35,6 +36,59 @@ var _ = Describe("NetworkReader", func() {
 
 			Expect(context.Name).To(Equal("networkReader"))
 		})
+
+		It("can be stopped", func(done Done) {
+			reader.Stop()
+			close(done)
+		})
+
+		It("can be stopped more than once", func(done Done) {
+			reader.Stop()
+			reader.Stop()
+			close(done)
+		})
+	})
+
+	Context("with a slow writer", func() {
+		var writer *blockingWriter
+
+		BeforeEach(func() {
+			writer = &blockingWriter{started: make(chan struct{}, 10), unblock: make(chan struct{})}
+			reader = networkreader.New(address, "networkReader", writer, loggertesthelper.Logger())
+
+			go func() {
+				reader.Start()
+				close(readerStopped)
+			}()
+
+			Eventually(func() error {
+				connection, err := net.ListenPacket("udp4", address)
+				if err == nil {
+					connection.Close()
+					return fmt.Errorf("reader is not listening yet")
+				}
+				return nil
+			}).Should(Succeed())
+		})
+
+		It("waits for the message being written when stopped", func() {
+			connection, err := net.Dial("udp", address)
+			Expect(err).NotTo(HaveOccurred())
+			defer connection.Close()
+			connection.Write([]byte("Some Data"))
+			Eventually(writer.started).Should(Receive())
+
+			stopReturned := make(chan struct{})
+			go func() {
+				reader.Stop()
+				close(stopReturned)
+			}()
+			Consistently(stopReturned, 100*time.Millisecond).ShouldNot(BeClosed())
+
+			close(writer.unblock)
+			Eventually(stopReturned).Should(BeClosed())
+			Eventually(readerStopped).Should(BeClosed())
+		})
 	})
 
 	Context("with a reader running", func() {
@@ -91,13 +145,15 @@ var _ = Describe("NetworkReader", func() {
 			Eventually(writer.Data).Should(HaveLen(2))
 
 			metrics := reader.Emit().Metrics
-			Expect(metrics).To(HaveLen(2))
+			Expect(metrics).To(HaveLen(4))
 			for _, metric := range metrics {
 				switch metric.Name {
 				case "receivedMessageCount":
 					Expect(metric.Value).To(Equal(uint64(2)))
 				case "receivedByteCount":
 					Expect(metric.Value).To(Equal(uint64(dataByteCount)))
+				case "kernelDropCount", "receiveQueueBytes":
+					Expect(metric.Value).To(BeAssignableToTypeOf(uint64(0)))
 				default:
 					Fail(fmt.Sprintf("Got an invalid metric name: %s", metric.Name))
 				}
@@ -105,4 +161,53 @@ var _ = Describe("NetworkReader", func() {
 			close(done)
 		}, 2)
 	})
+
+	Context("with several workers", func() {
+		BeforeEach(func() {
+			config := networkreader.Config{Workers: 4, ReceiveBufferBytes: 1024 * 1024}
+			reader = networkreader.NewWithConfig(address, "networkReader", config, &writer, loggertesthelper.Logger())
+
+			go func() {
+				reader.Start()
+				close(readerStopped)
+			}()
+
+			Eventually(func() error {
+				connection, err := net.ListenPacket("udp4", address)
+				if err == nil {
+					connection.Close()
+					return fmt.Errorf("reader is not listening yet")
+				}
+				return nil
+			}).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			reader.Stop()
+			Eventually(readerStopped).Should(BeClosed())
+		})
+
+		It("delivers data from every sender to its writer", func() {
+			for i := 0; i < 20; i++ {
+				connection, err := net.Dial("udp", address)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = connection.Write([]byte("Some Data"))
+				Expect(err).NotTo(HaveOccurred())
+				connection.Close()
+			}
+
+			Eventually(writer.Data).Should(HaveLen(20))
+		})
+	})
 })
+
+type blockingWriter struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write([]byte) {
+	w.started <- struct{}{}
+	<-w.unblock
+}
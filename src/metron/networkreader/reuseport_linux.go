@@ -0,0 +1,55 @@
+package networkreader
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+const (
+	reusePortSupported = true
+	procNetUDP         = "/proc/net/udp"
+
+	// The syscall package doesn't define SO_REUSEPORT on linux.
+	soReusePort = 0xf
+)
+
+func listenReusePort(address string) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+
+	sockaddr := &syscall.SockaddrInet4{Port: udpAddr.Port}
+	if ip := udpAddr.IP.To4(); ip != nil {
+		copy(sockaddr.Addr[:], ip)
+	}
+	if err := syscall.Bind(fd, sockaddr); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "udp:"+address)
+	defer file.Close()
+	return net.FilePacketConn(file)
+}
+
+func readKernelStats(port int) (KernelStats, error) {
+	file, err := os.Open(procNetUDP)
+	if err != nil {
+		return KernelStats{}, err
+	}
+	defer file.Close()
+
+	return ParseProcNetUDP(file, port)
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package networkreader
+
+import (
+	"errors"
+	"net"
+)
+
+const reusePortSupported = false
+
+func listenReusePort(address string) (net.PacketConn, error) {
+	return nil, errors.New("SO_REUSEPORT is not supported on this platform")
+}
+
+func readKernelStats(port int) (KernelStats, error) {
+	return KernelStats{}, errors.New("kernel UDP stats are only available on linux")
+}
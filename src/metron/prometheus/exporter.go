@@ -0,0 +1,233 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"metron/writers/varzforwarder"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+const contentType = "text/plain; version=0.0.4"
+
+type MetricSource interface {
+	Metrics() []varzforwarder.ForwardedMetric
+}
+
+// An Exporter serves the metrics Metron forwards, and Metron's own
+// instrumentation, in the Prometheus text exposition format. Forwarded
+// metrics keep their name and carry their origin as a label; instrumentation
+// metrics are named metron_<context>_<metric>. Every sample is labelled with
+// the labels given to New.
+type Exporter struct {
+	source          MetricSource
+	instrumentables []instrumentation.Instrumentable
+	labels          map[string]string
+}
+
+func New(source MetricSource, instrumentables []instrumentation.Instrumentable, labels map[string]string) *Exporter {
+	sanitized := make(map[string]string, len(labels))
+	for name, value := range labels {
+		sanitized[SanitizeLabelName(name)] = value
+	}
+
+	return &Exporter{
+		source:          source,
+		instrumentables: instrumentables,
+		labels:          sanitized,
+	}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buffer bytes.Buffer
+	e.Write(&buffer)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buffer.Bytes())
+}
+
+// Write writes every metric family, sorted by name.
+func (e *Exporter) Write(w io.Writer) {
+	families := make(map[string]*family)
+
+	for _, metric := range e.source.Metrics() {
+		labels := e.sampleLabels(map[string]string{"origin": metric.Origin})
+		add(families, SanitizeMetricName(metric.Name), metricType(metric.Counter), sample{labels: labels, value: metric.Value})
+	}
+
+	for _, instrumentable := range e.instrumentables {
+		context := instrumentable.Emit()
+		for _, metric := range context.Metrics {
+			value, ok := numericValue(metric.Value)
+			if !ok {
+				continue
+			}
+
+			tags := make(map[string]string, len(metric.Tags))
+			for name, tag := range metric.Tags {
+				tags[name] = fmt.Sprint(tag)
+			}
+			name := SanitizeMetricName("metron_" + context.Name + "_" + metric.Name)
+			add(families, name, "untyped", sample{labels: e.sampleLabels(tags), value: value})
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		families[name].write(w, name)
+	}
+}
+
+// sampleLabels merges metric specific labels with the exporter's own, which
+// take precedence.
+func (e *Exporter) sampleLabels(labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(e.labels))
+	for name, value := range labels {
+		merged[SanitizeLabelName(name)] = value
+	}
+	for name, value := range e.labels {
+		merged[name] = value
+	}
+	return merged
+}
+
+type family struct {
+	metricType string
+	samples    []sample
+}
+
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// add appends a sample to its family. A name reported as a counter by one
+// origin and a gauge by another is exposed as a gauge.
+func add(families map[string]*family, name string, metricType string, s sample) {
+	f, ok := families[name]
+	if !ok {
+		f = &family{metricType: metricType}
+		families[name] = f
+	}
+	if f.metricType != metricType {
+		f.metricType = "gauge"
+	}
+	f.samples = append(f.samples, s)
+}
+
+func (f *family) write(w io.Writer, name string) {
+	lines := make([]string, 0, len(f.samples))
+	for _, s := range f.samples {
+		lines = append(lines, name+formatLabels(s.labels)+" "+formatValue(s.value))
+	}
+	sort.Strings(lines)
+
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, f.metricType)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func metricType(counter bool) string {
+	if counter {
+		return "counter"
+	}
+	return "gauge"
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelValueEscaper.Replace(labels[name]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// SanitizeMetricName replaces every character Prometheus does not allow in
+// a metric name with an underscore.
+func SanitizeMetricName(name string) string {
+	return sanitize(name, true)
+}
+
+// SanitizeLabelName is like SanitizeMetricName, but also replaces colons,
+// which are reserved in label names.
+func SanitizeLabelName(name string) string {
+	return sanitize(name, false)
+}
+
+func sanitize(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	sanitized := []byte(name)
+	for i, c := range sanitized {
+		valid := c == '_' ||
+			(c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9') ||
+			(c == ':' && allowColon)
+		if !valid {
+			sanitized[i] = '_'
+		}
+	}
+
+	if sanitized[0] >= '0' && sanitized[0] <= '9' {
+		return "_" + string(sanitized)
+	}
+	return string(sanitized)
+}
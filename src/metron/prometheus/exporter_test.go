@@ -0,0 +1,129 @@
+package prometheus_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"metron/prometheus"
+	"metron/writers/varzforwarder"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Exporter", func() {
+	var (
+		source          *fakeSource
+		instrumentables []instrumentation.Instrumentable
+		exporter        *prometheus.Exporter
+	)
+
+	BeforeEach(func() {
+		source = &fakeSource{}
+		instrumentables = nil
+	})
+
+	JustBeforeEach(func() {
+		labels := map[string]string{"deployment": "cf", "job": "doppler", "index": "0"}
+		exporter = prometheus.New(source, instrumentables, labels)
+	})
+
+	It("serves forwarded metrics with type hints and labels", func() {
+		source.metrics = []varzforwarder.ForwardedMetric{
+			{Origin: "router", Name: "latency", Value: 1.5},
+			{Origin: "router", Name: "requestCount", Value: 42, Counter: true},
+		}
+
+		server := httptest.NewServer(exporter)
+		defer server.Close()
+
+		response, err := http.Get(server.URL + "/metrics")
+		Expect(err).NotTo(HaveOccurred())
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+
+		Expect(response.Header.Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+		Expect(string(body)).To(Equal(
+			"# TYPE latency gauge\n" +
+				`latency{deployment="cf",index="0",job="doppler",origin="router"} 1.5` + "\n" +
+				"# TYPE requestCount counter\n" +
+				`requestCount{deployment="cf",index="0",job="doppler",origin="router"} 42` + "\n",
+		))
+	})
+
+	It("groups samples from several origins under one family", func() {
+		source.metrics = []varzforwarder.ForwardedMetric{
+			{Origin: "a", Name: "cpu", Value: 1},
+			{Origin: "b", Name: "cpu", Value: 2},
+		}
+
+		body := render(exporter)
+		Expect(body).To(ContainSubstring("# TYPE cpu gauge\n" +
+			`cpu{deployment="cf",index="0",job="doppler",origin="a"} 1` + "\n" +
+			`cpu{deployment="cf",index="0",job="doppler",origin="b"} 2` + "\n"))
+	})
+
+	It("sanitizes metric names and escapes label values", func() {
+		source.metrics = []varzforwarder.ForwardedMetric{
+			{Origin: `dea "1"`, Name: "2xx.response-time", Value: 3},
+		}
+
+		Expect(render(exporter)).To(ContainSubstring(`_2xx_response_time{deployment="cf",index="0",job="doppler",origin="dea \"1\""} 3`))
+	})
+
+	Context("with instrumentables", func() {
+		BeforeEach(func() {
+			instrumentables = []instrumentation.Instrumentable{
+				fakeInstrumentable{context: instrumentation.Context{
+					Name: "rateLimiter",
+					Metrics: []instrumentation.Metric{
+						{Name: "app.droppedMessages", Value: uint64(7), Tags: map[string]interface{}{"origin": "app"}},
+						{Name: "ok", Value: true},
+						{Name: "details", Value: struct{}{}},
+					},
+				}},
+			}
+		})
+
+		It("exposes Metron's own numeric metrics", func() {
+			body := render(exporter)
+
+			Expect(body).To(ContainSubstring("# TYPE metron_rateLimiter_app_droppedMessages untyped\n" +
+				`metron_rateLimiter_app_droppedMessages{deployment="cf",index="0",job="doppler",origin="app"} 7`))
+			Expect(body).To(ContainSubstring(`metron_rateLimiter_ok{deployment="cf",index="0",job="doppler"} 1`))
+			Expect(body).NotTo(ContainSubstring("details"))
+		})
+	})
+
+	Describe("SanitizeLabelName", func() {
+		It("replaces colons, which SanitizeMetricName keeps", func() {
+			Expect(prometheus.SanitizeMetricName("a:b")).To(Equal("a:b"))
+			Expect(prometheus.SanitizeLabelName("a:b")).To(Equal("a_b"))
+		})
+	})
+})
+
+func render(exporter *prometheus.Exporter) string {
+	recorder := httptest.NewRecorder()
+	exporter.ServeHTTP(recorder, nil)
+	return recorder.Body.String()
+}
+
+type fakeSource struct {
+	metrics []varzforwarder.ForwardedMetric
+}
+
+func (f *fakeSource) Metrics() []varzforwarder.ForwardedMetric {
+	return f.metrics
+}
+
+type fakeInstrumentable struct {
+	context instrumentation.Context
+}
+
+func (f fakeInstrumentable) Emit() instrumentation.Context {
+	return f.context
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"metron/clientpool"
+	"metron/writers"
+	"metron/writers/switchwriter"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metricbatcher"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+)
+
+// taggerSwitch is a tagger in the writer chain together with the writer the
+// tagger hands its envelopes to.
+type taggerSwitch struct {
+	writer       *switchwriter.EnvelopeWriter
+	outputWriter writers.EnvelopeWriter
+}
+
+// addressListsTimeout is how long a reload waits for the Dopplers in new etcd
+// URLs, and addressListsPollInterval how often it checks for them.
+var (
+	addressListsTimeout      = 10 * time.Second
+	addressListsPollInterval = 100 * time.Millisecond
+)
+
+// A reloader re-reads the config file on SIGHUP and applies the settings that
+// can change while Metron runs: the shared secret, the etcd URLs, the metric
+// batch interval and the tags. Everything a new config needs is built before
+// any of it is switched in, and a config that can't be read or doesn't
+// validate is logged and ignored. New etcd URLs are only switched to once
+// Dopplers have been found through them.
+type reloader struct {
+	configFile string
+	config     metronConfig
+	logger     *gosteno.Logger
+
+	// readConfig reads and validates the config file, and newAddressLists
+	// watches the Doppler addresses in etcd. They are readConfig and
+	// initializeAddressLists outside of tests.
+	readConfig      func(configFile string) (metronConfig, error)
+	newAddressLists func(config metronConfig, logger *gosteno.Logger) *dopplerAddressLists
+
+	addressLists  *dopplerAddressLists
+	clientPool    *clientpool.DopplerPool
+	batchWriter   writers.ByteArrayWriter
+	dopplerWriter *switchwriter.ByteArrayWriter
+	taggers       []taggerSwitch
+	metricSender  metric_sender.MetricSender
+	metricBatcher *metricbatcher.MetricBatcher
+
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+func (r *reloader) Run() {
+	defer close(r.doneChan)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-signals:
+			r.reload()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// Stop waits for a reload in progress to finish, stops listening for SIGHUP
+// and returns the metric batcher that is in use.
+func (r *reloader) Stop() *metricbatcher.MetricBatcher {
+	close(r.stopChan)
+	<-r.doneChan
+	return r.metricBatcher
+}
+
+func (r *reloader) reload() {
+	r.logger.Info("Reloading config from " + r.configFile)
+
+	newConfig, err := r.readConfig(r.configFile)
+	if err != nil {
+		r.logger.Errorf("Not reloading, keeping the current config: %v", err)
+		return
+	}
+
+	if !reflect.DeepEqual(withReloadableFields(r.config, newConfig), newConfig) {
+		r.logger.Warn("Config changes other than the shared secret, etcd URLs, metric batch interval and tags need a restart and were ignored")
+	}
+	config := withReloadableFields(r.config, newConfig)
+
+	dopplerWriter := newDopplerWriter(config, r.batchWriter)
+	taggers := make([]writers.EnvelopeWriter, len(r.taggers))
+	for i, t := range r.taggers {
+		taggers[i] = newTagger(config, t.outputWriter)
+	}
+
+	var addressLists *dopplerAddressLists
+	if !reflect.DeepEqual(config.EtcdUrls, r.config.EtcdUrls) {
+		addressLists = r.newAddressLists(config, r.logger)
+		if !waitForAddresses(addressLists, addressListsTimeout) {
+			r.logger.Errorf("Found no Dopplers through etcd %v within %s, keeping etcd %v", config.EtcdUrls, addressListsTimeout, r.config.EtcdUrls)
+			addressLists.Stop()
+			addressLists = nil
+			config.EtcdUrls = r.config.EtcdUrls
+		}
+	}
+
+	var metricBatcher *metricbatcher.MetricBatcher
+	if config.MetricBatchIntervalSeconds != r.config.MetricBatchIntervalSeconds {
+		metricBatcher = metricbatcher.New(r.metricSender, time.Duration(config.MetricBatchIntervalSeconds)*time.Second)
+	}
+
+	r.dopplerWriter.Switch(dopplerWriter)
+	for i, t := range r.taggers {
+		t.writer.Switch(taggers[i])
+	}
+
+	if addressLists != nil {
+		r.clientPool.SetAddressLists(addressLists.inZone, addressLists.allZone)
+		r.addressLists.Stop()
+		r.addressLists = addressLists
+	}
+
+	if metricBatcher != nil {
+		// Initialize closes the batcher it replaces.
+		metrics.Initialize(r.metricSender, metricBatcher)
+		r.metricBatcher = metricBatcher
+	}
+
+	r.config = config
+	r.logger.Info("Reloaded config")
+}
+
+// waitForAddresses reports whether lists found any Doppler within timeout.
+// Address lists fill themselves in the background, so lists that were just
+// created are empty at first.
+func waitForAddresses(lists *dopplerAddressLists, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for len(lists.allZone.GetAddresses()) == 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(addressListsPollInterval)
+	}
+	return true
+}
+
+// withReloadableFields returns config with the settings that can be reloaded
+// taken from source.
+func withReloadableFields(config metronConfig, source metronConfig) metronConfig {
+	config.SharedSecret = source.SharedSecret
+	config.SharedSecrets = source.SharedSecrets
+	config.CurrentSharedSecretId = source.CurrentSharedSecretId
+	config.EtcdUrls = source.EtcdUrls
+	config.MetricBatchIntervalSeconds = source.MetricBatchIntervalSeconds
+	config.Tags = source.Tags
+	return config
+}
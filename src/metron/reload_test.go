@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"metron/clientpool"
+	"metron/writers/mocks"
+	"metron/writers/switchwriter"
+
+	"github.com/cloudfoundry/dropsonde/signature"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/loggregatorlib/loggregatorclient"
+	"github.com/cloudfoundry/storeadapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("reloader", func() {
+	var (
+		config       metronConfig
+		newConfig    metronConfig
+		readErr      error
+		addressLists *dopplerAddressLists
+		newAddresses []string
+		newLists     *dopplerAddressLists
+		batchWriter  *mocks.MockByteArrayWriter
+		r            *reloader
+	)
+
+	var (
+		originalTimeout      time.Duration
+		originalPollInterval time.Duration
+	)
+
+	BeforeEach(func() {
+		originalTimeout = addressListsTimeout
+		originalPollInterval = addressListsPollInterval
+		addressListsTimeout = 100 * time.Millisecond
+		addressListsPollInterval = time.Millisecond
+	})
+
+	AfterEach(func() {
+		addressListsTimeout = originalTimeout
+		addressListsPollInterval = originalPollInterval
+	})
+
+	BeforeEach(func() {
+		loggertesthelper.TestLoggerSink.Clear()
+
+		config = metronConfig{
+			Zone:                       "z1",
+			SharedSecret:               "old-secret",
+			EtcdUrls:                   []string{"http://etcd-old:4001"},
+			MetricBatchIntervalSeconds: 15,
+		}
+		newConfig = config
+		readErr = nil
+
+		addressLists = fakeAddressLists("10.0.0.1")
+		newAddresses = []string{"10.0.0.2"}
+		newLists = nil
+		batchWriter = &mocks.MockByteArrayWriter{}
+
+		r = &reloader{
+			configFile: "metron.json",
+			config:     config,
+			logger:     loggertesthelper.Logger(),
+			readConfig: func(string) (metronConfig, error) {
+				return newConfig, readErr
+			},
+			newAddressLists: func(metronConfig, *gosteno.Logger) *dopplerAddressLists {
+				newLists = fakeAddressLists()
+				go func(addresses []string) {
+					time.Sleep(10 * time.Millisecond)
+					newLists.inZone.(*fakeServerAddressList).setAddresses(addresses)
+					newLists.allZone.(*fakeServerAddressList).setAddresses(addresses)
+				}(newAddresses)
+				return newLists
+			},
+			addressLists: addressLists,
+			clientPool: clientpool.NewDopplerPool(loggertesthelper.Logger(), 3457, addressLists.inZone, addressLists.allZone, func(address string) loggregatorclient.LoggregatorClient {
+				return &fakeClient{}
+			}),
+			batchWriter:   batchWriter,
+			dopplerWriter: switchwriter.NewByteArrayWriter(newDopplerWriter(config, batchWriter)),
+		}
+	})
+
+	It("signs messages with a new shared secret", func() {
+		newConfig.SharedSecret = "new-secret"
+
+		r.reload()
+		r.dopplerWriter.Write([]byte("message"))
+
+		Expect(batchWriter.Data()).To(Equal([][]byte{signature.SignMessage([]byte("message"), []byte("new-secret"))}))
+		Expect(r.config.SharedSecret).To(Equal("new-secret"))
+	})
+
+	It("moves the client pool to the Dopplers found through new etcd URLs", func() {
+		Expect(r.clientPool.GetAddresses()).To(Equal([]string{"10.0.0.1:3457"}))
+		newConfig.EtcdUrls = []string{"http://etcd-new:4001"}
+
+		r.reload()
+
+		Expect(r.clientPool.GetAddresses()).To(Equal([]string{"10.0.0.2:3457"}))
+		Expect(addressLists.inZone.(*fakeServerAddressList).isStopped()).To(BeTrue())
+		Expect(addressLists.adapter.(*fakeStoreAdapter).disconnected).To(BeTrue())
+		Expect(r.config.EtcdUrls).To(Equal([]string{"http://etcd-new:4001"}))
+	})
+
+	It("keeps the current Dopplers when none are found through new etcd URLs", func() {
+		newConfig.EtcdUrls = []string{"http://etcd-new:4001"}
+		newConfig.SharedSecret = "new-secret"
+		newAddresses = nil
+
+		r.reload()
+
+		Expect(r.clientPool.GetAddresses()).To(Equal([]string{"10.0.0.1:3457"}))
+		Expect(r.addressLists == addressLists).To(BeTrue())
+		Expect(addressLists.inZone.(*fakeServerAddressList).isStopped()).To(BeFalse())
+		Expect(newLists.inZone.(*fakeServerAddressList).isStopped()).To(BeTrue())
+		Expect(r.config.EtcdUrls).To(Equal([]string{"http://etcd-old:4001"}))
+		Expect(r.config.SharedSecret).To(Equal("new-secret"))
+		Expect(string(loggertesthelper.TestLoggerSink.LogContents())).To(ContainSubstring("Found no Dopplers through etcd [http://etcd-new:4001]"))
+	})
+
+	It("keeps the address lists when the etcd URLs are unchanged", func() {
+		newConfig.SharedSecret = "new-secret"
+
+		r.reload()
+
+		Expect(r.addressLists == addressLists).To(BeTrue())
+		Expect(addressLists.inZone.(*fakeServerAddressList).isStopped()).To(BeFalse())
+	})
+
+	It("ignores a config that can't be read", func() {
+		newConfig.SharedSecret = "new-secret"
+		readErr = errors.New("invalid config")
+
+		r.reload()
+		r.dopplerWriter.Write([]byte("message"))
+
+		Expect(batchWriter.Data()).To(Equal([][]byte{signature.SignMessage([]byte("message"), []byte("old-secret"))}))
+		Expect(r.config).To(Equal(config))
+		Expect(string(loggertesthelper.TestLoggerSink.LogContents())).To(ContainSubstring("Not reloading, keeping the current config: invalid config"))
+	})
+
+	It("warns about changes that need a restart and applies only the others", func() {
+		newConfig.Zone = "z2"
+		newConfig.SharedSecret = "new-secret"
+
+		r.reload()
+
+		Expect(r.config.Zone).To(Equal("z1"))
+		Expect(r.config.SharedSecret).To(Equal("new-secret"))
+		Expect(string(loggertesthelper.TestLoggerSink.LogContents())).To(ContainSubstring("need a restart and were ignored"))
+	})
+
+	It("does not warn when only reloadable settings change", func() {
+		newConfig.SharedSecret = "new-secret"
+
+		r.reload()
+
+		Expect(string(loggertesthelper.TestLoggerSink.LogContents())).NotTo(ContainSubstring("need a restart"))
+	})
+})
+
+func fakeAddressLists(addresses ...string) *dopplerAddressLists {
+	return &dopplerAddressLists{
+		adapter: &fakeStoreAdapter{},
+		inZone:  &fakeServerAddressList{addresses: addresses},
+		allZone: &fakeServerAddressList{addresses: addresses},
+	}
+}
+
+type fakeServerAddressList struct {
+	addresses []string
+	stopped   bool
+	sync.Mutex
+}
+
+func (f *fakeServerAddressList) Run(time.Duration) {}
+
+func (f *fakeServerAddressList) Stop() {
+	f.Lock()
+	defer f.Unlock()
+	f.stopped = true
+}
+
+func (f *fakeServerAddressList) isStopped() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.stopped
+}
+
+func (f *fakeServerAddressList) GetAddresses() []string {
+	f.Lock()
+	defer f.Unlock()
+	return f.addresses
+}
+
+func (f *fakeServerAddressList) setAddresses(addresses []string) {
+	f.Lock()
+	defer f.Unlock()
+	f.addresses = addresses
+}
+
+type fakeStoreAdapter struct {
+	storeadapter.StoreAdapter
+	disconnected bool
+}
+
+func (f *fakeStoreAdapter) Disconnect() error {
+	f.disconnected = true
+	return nil
+}
+
+type fakeClient struct{}
+
+func (f *fakeClient) Send([]byte)                   {}
+func (f *fakeClient) Stop()                         {}
+func (f *fakeClient) Emit() instrumentation.Context { return instrumentation.Context{} }
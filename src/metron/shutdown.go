@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"metron/clientpool"
+	"metron/health"
+	"metron/spool"
+	"metron/writers"
+	"metron/writers/dopplerforwarder"
+	"metron/writers/envelopefilter"
+	"metron/writers/eventunmarshaller"
+	"metron/writers/histogramaggregator"
+	"metron/writers/httpmetrics"
+	"metron/writers/ratelimiter"
+
+	"github.com/cloudfoundry/gosteno"
+)
+
+type messageReader interface {
+	Stop()
+	ReceivedMessages() uint64
+}
+
+type stopper interface {
+	Stop()
+}
+
+// A shutdownHandler waits for SIGTERM or SIGINT, stops the readers so no new
+// messages come in, and then sends on whatever is still in the writer chain:
+// the rate limiter's summary, the open histograms and HTTP metrics, the
+// batched metrics and the buffered batch. Draining gives up after the timeout.
+// Either way it logs how many messages were received, sent and dropped over
+// Metron's lifetime.
+type shutdownHandler struct {
+	timeout time.Duration
+	logger  *gosteno.Logger
+
+	readers             []messageReader
+	reloader            *reloader
+	rateLimiter         *ratelimiter.RateLimiter
+	histogramAggregator *histogramaggregator.HistogramAggregator
+	httpMetrics         *httpmetrics.HTTPMetrics
+	batchWriter         writers.ByteArrayWriter
+	clientPool          *clientpool.DopplerPool
+	messageSpool        *spool.Spool
+	healthMonitor       *health.Health
+
+	dropsondeUnmarshaller *eventunmarshaller.EventUnmarshaller
+	envelopeFilter        *envelopefilter.EnvelopeFilter
+	dopplerForwarder      *dopplerforwarder.DopplerForwarder
+}
+
+func (s *shutdownHandler) Wait() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	signal.Stop(signals)
+
+	s.shutdown(sig)
+}
+
+// shutdown drains the writer chain within the timeout and logs the summary.
+func (s *shutdownHandler) shutdown(sig os.Signal) {
+	s.logger.Infof("Shutdown: received %s, draining for up to %s", sig, s.timeout)
+	drained := make(chan struct{})
+	go func() {
+		s.drain()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		s.logger.Info("Shutdown: drained all in-flight messages")
+	case <-time.After(s.timeout):
+		s.logger.Warnf("Shutdown: gave up draining after %s, in-flight messages may be lost", s.timeout)
+	}
+
+	s.logSummary()
+}
+
+func (s *shutdownHandler) drain() {
+	// Stopping a reader waits for the messages it already read, so nothing
+	// is written to the chain while it is being drained.
+	for _, reader := range s.readers {
+		reader.Stop()
+	}
+
+	s.healthMonitor.Stop()
+
+	s.rateLimiter.WriteSummary()
+	s.rateLimiter.Stop()
+
+	s.histogramAggregator.Flush()
+	s.histogramAggregator.Stop()
+
+	if s.httpMetrics != nil {
+		s.httpMetrics.Flush()
+		s.httpMetrics.Stop()
+	}
+
+	metricBatcher := s.reloader.Stop()
+	metricBatcher.Close()
+
+	if batchWriter, ok := s.batchWriter.(stopper); ok {
+		batchWriter.Stop()
+	}
+
+	s.dopplerForwarder.Stop()
+	s.clientPool.Stop()
+	if s.messageSpool != nil {
+		s.messageSpool.Close()
+	}
+}
+
+func (s *shutdownHandler) logSummary() {
+	var received uint64
+	for _, reader := range s.readers {
+		received += reader.ReceivedMessages()
+	}
+
+	malformed := s.dropsondeUnmarshaller.UnmarshalErrors()
+	denied := s.envelopeFilter.DeniedMessages()
+	rateLimited := s.rateLimiter.DroppedMessages()
+	unsent := s.dopplerForwarder.ForwardErrors()
+
+	if s.messageSpool != nil {
+		s.logger.Infof("Shutdown: received %d messages, sent %d to Doppler, spooled %d and dropped %d (%d malformed, %d denied by filters, %d rate limited)",
+			received, s.dopplerForwarder.SentMessages(), unsent, malformed+denied+rateLimited, malformed, denied, rateLimited)
+		return
+	}
+
+	s.logger.Infof("Shutdown: received %d messages, sent %d to Doppler and dropped %d (%d malformed, %d denied by filters, %d rate limited, %d with no Doppler available)",
+		received, s.dopplerForwarder.SentMessages(), malformed+denied+rateLimited+unsent, malformed, denied, rateLimited, unsent)
+}
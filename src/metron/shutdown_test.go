@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"metron/clientpool"
+	"metron/health"
+	"metron/writers/dopplerforwarder"
+	"metron/writers/envelopefilter"
+	"metron/writers/eventunmarshaller"
+	"metron/writers/histogramaggregator"
+	"metron/writers/mocks"
+	"metron/writers/ratelimiter"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metricbatcher"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/loggregatorlib/loggregatorclient"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("shutdownHandler", func() {
+	var (
+		stops   *stopRecorder
+		reader  *fakeReader
+		handler *shutdownHandler
+	)
+
+	BeforeEach(func() {
+		loggertesthelper.TestLoggerSink.Clear()
+		logger := loggertesthelper.Logger()
+
+		stops = &stopRecorder{}
+		reader = &fakeReader{name: "reader", received: 3, stops: stops}
+
+		histogramAggregator, err := histogramaggregator.New(nil, nil, 0, &mocks.MockEnvelopeWriter{}, logger)
+		Expect(err).NotTo(HaveOccurred())
+		rateLimiter, err := ratelimiter.New(nil, 0, histogramAggregator, logger)
+		Expect(err).NotTo(HaveOccurred())
+		envelopeFilter, err := envelopefilter.New(nil, rateLimiter, logger)
+		Expect(err).NotTo(HaveOccurred())
+		dropsondeUnmarshaller := eventunmarshaller.New(envelopeFilter, logger)
+
+		emptyList := &fakeServerAddressList{}
+		clientPool := clientpool.NewDopplerPool(logger, 3457, emptyList, emptyList, func(address string) loggregatorclient.LoggregatorClient {
+			return &fakeClient{}
+		})
+		dopplerForwarder := dopplerforwarder.New(clientPool, logger)
+		dopplerForwarder.Write([]byte("unsent"))
+
+		configReloader := &reloader{
+			metricBatcher: metricbatcher.New(fake.NewFakeMetricSender(), time.Minute),
+			stopChan:      make(chan struct{}),
+			doneChan:      make(chan struct{}),
+		}
+		go configReloader.Run()
+
+		handler = &shutdownHandler{
+			timeout:               time.Second,
+			logger:                logger,
+			readers:               []messageReader{reader},
+			reloader:              configReloader,
+			rateLimiter:           rateLimiter,
+			histogramAggregator:   histogramAggregator,
+			batchWriter:           &fakeBatchWriter{stops: stops},
+			clientPool:            clientPool,
+			healthMonitor:         health.New(health.Thresholds{}, nil, dopplerForwarder, dropsondeUnmarshaller, logger),
+			dropsondeUnmarshaller: dropsondeUnmarshaller,
+			envelopeFilter:        envelopeFilter,
+			dopplerForwarder:      dopplerForwarder,
+		}
+	})
+
+	It("stops the readers before the batch writer and logs a summary", func() {
+		handler.shutdown(syscall.SIGTERM)
+
+		Expect(stops.Stopped()).To(Equal([]string{"reader", "batchWriter"}))
+
+		logs := string(loggertesthelper.TestLoggerSink.LogContents())
+		Expect(logs).To(ContainSubstring("Shutdown: drained all in-flight messages"))
+		Expect(logs).To(ContainSubstring("Shutdown: received 3 messages, sent 0 to Doppler and dropped 1 (0 malformed, 0 denied by filters, 0 rate limited, 1 with no Doppler available)"))
+	})
+
+	Context("when draining takes longer than the timeout", func() {
+		BeforeEach(func() {
+			handler.timeout = 50 * time.Millisecond
+			reader.block = make(chan struct{})
+		})
+
+		AfterEach(func() {
+			close(reader.block)
+		})
+
+		It("gives up and still logs a summary", func() {
+			handler.shutdown(syscall.SIGTERM)
+
+			logs := string(loggertesthelper.TestLoggerSink.LogContents())
+			Expect(logs).To(ContainSubstring("Shutdown: gave up draining after 50ms"))
+			Expect(logs).To(ContainSubstring("Shutdown: received 3 messages"))
+		})
+	})
+})
+
+type stopRecorder struct {
+	stopped []string
+	sync.Mutex
+}
+
+func (s *stopRecorder) record(name string) {
+	s.Lock()
+	defer s.Unlock()
+	s.stopped = append(s.stopped, name)
+}
+
+func (s *stopRecorder) Stopped() []string {
+	s.Lock()
+	defer s.Unlock()
+	return s.stopped
+}
+
+type fakeReader struct {
+	name     string
+	received uint64
+	block    chan struct{}
+	stops    *stopRecorder
+}
+
+func (f *fakeReader) Stop() {
+	if f.block != nil {
+		<-f.block
+	}
+	f.stops.record(f.name)
+}
+
+func (f *fakeReader) ReceivedMessages() uint64 { return f.received }
+
+type fakeBatchWriter struct {
+	stops *stopRecorder
+}
+
+func (f *fakeBatchWriter) Write([]byte) {}
+func (f *fakeBatchWriter) Stop()        { f.stops.record("batchWriter") }
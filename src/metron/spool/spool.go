@@ -0,0 +1,378 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+const (
+	segmentSuffix    = ".seg"
+	lengthPrefixSize = 4
+)
+
+// A Spool buffers messages on disk while no Doppler is reachable. Messages
+// are appended to segment files of roughly segmentBytes each. When the spool
+// grows beyond maxBytes the oldest segment is evicted. Segments left behind
+// by a previous run are picked up again, so a restart does not lose data
+// that has not been replayed yet.
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	logger       *gosteno.Logger
+
+	segments      []*segment
+	writer        *os.File
+	nextSegmentID uint64
+	lock          sync.Mutex
+
+	depth            int64
+	bytes            int64
+	evictedMessages  uint64
+	evictedSegments  uint64
+	spooledMessages  uint64
+	replayedMessages uint64
+}
+
+type segment struct {
+	id       uint64
+	path     string
+	bytes    int64
+	messages int64
+
+	// offset and replayed track how much of the segment has been replayed
+	// already, so a replay picks up where the last one stopped.
+	offset   int64
+	replayed int64
+}
+
+func New(dir string, maxBytes int64, segmentBytes int64, logger *gosteno.Logger) (*Spool, error) {
+	if segmentBytes <= 0 || segmentBytes > maxBytes {
+		return nil, fmt.Errorf("segment size %d must be positive and at most the spool size %d", segmentBytes, maxBytes)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		logger:       logger,
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append writes a message to the newest segment, evicting the oldest
+// segments if the spool would exceed its size cap.
+func (s *Spool) Append(message []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	size := int64(lengthPrefixSize + len(message))
+	if size > s.segmentBytes {
+		return fmt.Errorf("message of %d bytes does not fit in a spool segment", len(message))
+	}
+
+	if s.writer == nil || s.current().bytes+size > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	for s.bytes+size > s.maxBytes && len(s.segments) > 1 {
+		s.evictOldest()
+	}
+
+	var prefix [lengthPrefixSize]byte
+	binary.LittleEndian.PutUint32(prefix[:], uint32(len(message)))
+	if _, err := s.writer.Write(append(prefix[:], message...)); err != nil {
+		return err
+	}
+
+	current := s.current()
+	current.bytes += size
+	current.messages++
+	atomic.AddInt64(&s.bytes, size)
+	atomic.AddInt64(&s.depth, 1)
+	atomic.AddUint64(&s.spooledMessages, 1)
+	metrics.BatchIncrementCounter("spool.spooledMessages")
+
+	return nil
+}
+
+// Len returns the number of messages waiting in the spool.
+func (s *Spool) Len() int {
+	return int(atomic.LoadInt64(&s.depth))
+}
+
+// Replay hands up to max of the oldest spooled messages to send and
+// removes them from the spool as they are sent. A segment is deleted once
+// all of its messages have been sent. It returns the number of messages
+// sent. A segment that cannot be read is dropped and the error returned.
+// Messages of a segment that was being replayed when Metron stopped are
+// sent again.
+func (s *Spool) Replay(max int, send func(message []byte)) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sent := 0
+	for sent < max && len(s.segments) > 0 {
+		oldest := s.segments[0]
+		n, err := s.replaySegment(oldest, max-sent, send)
+		sent += n
+
+		switch {
+		case err == io.ErrUnexpectedEOF:
+			s.logger.Warnf("Spool: segment %s ends with a partial message, skipping it", oldest.path)
+		case err != nil:
+			s.logger.Errorf("Spool: error reading segment %s, dropping %d messages: %s", oldest.path, oldest.messages-oldest.replayed, err)
+			s.dropOldest()
+			return sent, err
+		case oldest.replayed < oldest.messages:
+			return sent, nil
+		}
+
+		if oldest == s.current() {
+			s.closeWriter()
+		}
+		s.removeOldest()
+	}
+
+	return sent, nil
+}
+
+// Close closes the segment that is currently written to. Spooled messages
+// stay on disk and are recovered by the next call to New.
+func (s *Spool) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closeWriter()
+}
+
+func (s *Spool) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "spool",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "depth", Value: atomic.LoadInt64(&s.depth)},
+			instrumentation.Metric{Name: "bytes", Value: atomic.LoadInt64(&s.bytes)},
+			instrumentation.Metric{Name: "spooledMessages", Value: atomic.LoadUint64(&s.spooledMessages)},
+			instrumentation.Metric{Name: "replayedMessages", Value: atomic.LoadUint64(&s.replayedMessages)},
+			instrumentation.Metric{Name: "evictedMessages", Value: atomic.LoadUint64(&s.evictedMessages)},
+			instrumentation.Metric{Name: "evictedSegments", Value: atomic.LoadUint64(&s.evictedSegments)},
+		},
+	}
+}
+
+func (s *Spool) current() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *Spool) rotate() error {
+	s.closeWriter()
+
+	seg := &segment{
+		id:   s.nextSegmentID,
+		path: filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.nextSegmentID, segmentSuffix)),
+	}
+
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.nextSegmentID++
+	s.writer = file
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+func (s *Spool) closeWriter() {
+	if s.writer == nil {
+		return
+	}
+
+	if err := s.writer.Close(); err != nil {
+		s.logger.Warnf("Spool: error closing segment: %s", err)
+	}
+	s.writer = nil
+}
+
+func (s *Spool) evictOldest() {
+	oldest := s.segments[0]
+	s.logger.Warnf("Spool: size cap of %d bytes reached, evicting %d messages in %s", s.maxBytes, oldest.messages-oldest.replayed, oldest.path)
+	s.dropOldest()
+}
+
+func (s *Spool) dropOldest() {
+	oldest := s.segments[0]
+	atomic.AddUint64(&s.evictedMessages, uint64(oldest.messages-oldest.replayed))
+	atomic.AddUint64(&s.evictedSegments, 1)
+	metrics.BatchAddCounter("spool.evictedMessages", uint64(oldest.messages-oldest.replayed))
+
+	if oldest == s.current() {
+		s.closeWriter()
+	}
+	s.removeOldest()
+}
+
+func (s *Spool) removeOldest() {
+	oldest := s.segments[0]
+	if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+		s.logger.Warnf("Spool: error removing segment %s: %s", oldest.path, err)
+	}
+
+	atomic.AddInt64(&s.bytes, -(oldest.bytes - oldest.offset))
+	atomic.AddInt64(&s.depth, -(oldest.messages - oldest.replayed))
+	s.segments = s.segments[1:]
+}
+
+// replaySegment sends up to max messages of seg, starting after the ones
+// replayed before. A segment that ends before all of its messages have
+// been read yields io.ErrUnexpectedEOF.
+func (s *Spool) replaySegment(seg *segment, max int, send func(message []byte)) (int, error) {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(seg.offset, 0); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	sent := 0
+	for sent < max && seg.replayed < seg.messages {
+		message, err := readMessage(reader)
+		if err == io.EOF {
+			return sent, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		send(message)
+		sent++
+
+		size := int64(lengthPrefixSize + len(message))
+		seg.offset += size
+		seg.replayed++
+		atomic.AddInt64(&s.bytes, -size)
+		atomic.AddInt64(&s.depth, -1)
+		atomic.AddUint64(&s.replayedMessages, 1)
+		metrics.BatchIncrementCounter("spool.replayedMessages")
+	}
+
+	if seg.replayed == seg.messages {
+		if _, err := readMessage(reader); err != io.EOF && err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func (s *Spool) recover() error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &segment{id: id, path: filepath.Join(s.dir, name)}
+		err = readSegment(seg.path, func(message []byte) {
+			seg.bytes += int64(lengthPrefixSize + len(message))
+			seg.messages++
+		})
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		s.segments = append(s.segments, seg)
+		s.bytes += seg.bytes
+		s.depth += seg.messages
+		if id >= s.nextSegmentID {
+			s.nextSegmentID = id + 1
+		}
+	}
+
+	sort.Sort(byID(s.segments))
+
+	if len(s.segments) > 0 {
+		s.logger.Infof("Spool: recovered %d messages in %d segments from %s", s.depth, len(s.segments), s.dir)
+	}
+	return nil
+}
+
+func readSegment(path string, fn func(message []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		message, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fn(message)
+	}
+}
+
+func readMessage(reader *bufio.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, size)
+	if _, err := io.ReadFull(reader, message); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+type byID []*segment
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Less(i, j int) bool { return b[i].id < b[j].id }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,148 @@
+package spool_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"metron/spool"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spool", func() {
+	var (
+		dir string
+		s   *spool.Spool
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "metron-spool")
+		Expect(err).NotTo(HaveOccurred())
+
+		s, err = spool.New(dir, 64, 16, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		s.Close()
+		os.RemoveAll(dir)
+	})
+
+	replayAtMost := func(s *spool.Spool, max int) []string {
+		var messages []string
+		sent, err := s.Replay(max, func(message []byte) {
+			messages = append(messages, string(message))
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sent).To(Equal(len(messages)))
+		return messages
+	}
+
+	replay := func(s *spool.Spool) []string {
+		return replayAtMost(s, 1000)
+	}
+
+	It("replays messages in the order they were appended", func() {
+		for _, message := range []string{"one", "two", "three", "four"} {
+			Expect(s.Append([]byte(message))).To(Succeed())
+		}
+		Expect(s.Len()).To(Equal(4))
+
+		Expect(replay(s)).To(Equal([]string{"one", "two", "three", "four"}))
+		Expect(s.Len()).To(Equal(0))
+		testhelpers.EventuallyExpectMetric(s, "replayedMessages", 4)
+		testhelpers.EventuallyExpectMetric(s, "bytes", 0)
+	})
+
+	It("removes messages as they are replayed", func() {
+		for _, message := range []string{"aa", "bb", "cc"} {
+			Expect(s.Append([]byte(message))).To(Succeed())
+		}
+
+		Expect(replayAtMost(s, 1)).To(Equal([]string{"aa"}))
+		Expect(s.Len()).To(Equal(2))
+		testhelpers.EventuallyExpectMetric(s, "bytes", 12)
+
+		Expect(replayAtMost(s, 1)).To(Equal([]string{"bb"}))
+		segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		Expect(segments).To(HaveLen(1))
+
+		Expect(s.Append([]byte("dd"))).To(Succeed())
+		Expect(replay(s)).To(Equal([]string{"cc", "dd"}))
+		Expect(s.Len()).To(Equal(0))
+		testhelpers.EventuallyExpectMetric(s, "replayedMessages", 4)
+	})
+
+	It("keeps appending after the spool was replayed", func() {
+		Expect(s.Append([]byte("one"))).To(Succeed())
+		Expect(replay(s)).To(Equal([]string{"one"}))
+
+		Expect(s.Append([]byte("two"))).To(Succeed())
+		Expect(replay(s)).To(Equal([]string{"two"}))
+	})
+
+	It("splits messages into segment files", func() {
+		for _, message := range []string{"aa", "bb", "cc"} {
+			Expect(s.Append([]byte(message))).To(Succeed())
+		}
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		Expect(segments).To(HaveLen(2))
+		testhelpers.EventuallyExpectMetric(s, "bytes", 18)
+		testhelpers.EventuallyExpectMetric(s, "depth", 3)
+	})
+
+	It("evicts the oldest segment when the size cap is reached", func() {
+		for i := 0; i < 10; i++ {
+			Expect(s.Append([]byte{byte('0' + i), 'x', 'x', 'x', 'x', 'x', 'x', 'x'})).To(Succeed())
+		}
+
+		messages := replay(s)
+		Expect(messages).To(HaveLen(5))
+		Expect(messages[0]).To(Equal("5xxxxxxx"))
+		testhelpers.EventuallyExpectMetric(s, "evictedMessages", 5)
+	})
+
+	It("rejects messages larger than a segment", func() {
+		Expect(s.Append(make([]byte, 16))).NotTo(Succeed())
+		Expect(s.Len()).To(Equal(0))
+	})
+
+	It("recovers messages spooled by a previous run", func() {
+		Expect(s.Append([]byte("one"))).To(Succeed())
+		Expect(s.Append([]byte("two"))).To(Succeed())
+		s.Close()
+
+		recovered, err := spool.New(dir, 64, 16, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		defer recovered.Close()
+
+		Expect(recovered.Len()).To(Equal(2))
+		Expect(recovered.Append([]byte("three"))).To(Succeed())
+		Expect(replay(recovered)).To(Equal([]string{"one", "two", "three"}))
+	})
+
+	It("skips a partially written message at the end of a segment", func() {
+		Expect(s.Append([]byte("one"))).To(Succeed())
+		s.Close()
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		file, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		file.Write([]byte{10, 0, 0, 0, 'p'})
+		file.Close()
+
+		recovered, err := spool.New(dir, 64, 16, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		defer recovered.Close()
+
+		Expect(recovered.Len()).To(Equal(1))
+		Expect(replay(recovered)).To(Equal([]string{"one"}))
+	})
+})
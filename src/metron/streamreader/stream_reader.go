@@ -0,0 +1,236 @@
+package streamreader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"metron/writers"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+const maxMessageSize = 65535
+
+// A StreamReader accepts stream connections on a TCP port or Unix domain
+// socket and reads dropsonde envelopes prefixed with their length as a
+// little-endian uint32. Each message is handed to the writer before the
+// next one is read, so a slow writer leaves data in the kernel buffers and
+// eventually blocks the sender instead of silently dropping messages.
+type StreamReader struct {
+	network     string
+	address     string
+	contextName string
+	writer      writers.ByteArrayWriter
+	logger      *gosteno.Logger
+
+	listener    net.Listener
+	connections map[net.Conn]struct{}
+	stopped     bool
+	lock        sync.Mutex
+	wg          sync.WaitGroup
+	doneChan    chan struct{}
+
+	receivedMessageCount    uint64
+	receivedByteCount       uint64
+	receiveErrorCount       uint64
+	acceptedConnectionCount uint64
+	openConnectionCount     int64
+}
+
+// New returns a StreamReader for network "tcp" or "unix".
+func New(network string, address string, name string, writer writers.ByteArrayWriter, logger *gosteno.Logger) *StreamReader {
+	return &StreamReader{
+		network:     network,
+		address:     address,
+		contextName: name,
+		writer:      writer,
+		logger:      logger,
+		connections: make(map[net.Conn]struct{}),
+		doneChan:    make(chan struct{}),
+	}
+}
+
+// removeStaleSocket removes a socket file left behind by an earlier run,
+// which would make Listen fail. It returns an error, and removes nothing, if
+// something other than a socket is at the address.
+func (sr *StreamReader) removeStaleSocket() error {
+	info, err := os.Lstat(sr.address)
+	if err != nil {
+		return nil
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", sr.address)
+	}
+
+	if err := os.Remove(sr.address); err != nil {
+		sr.logger.Warnf("StreamReader: error removing stale socket %s: %s", sr.address, err)
+	}
+	return nil
+}
+
+func (sr *StreamReader) Start() {
+	if sr.network == "unix" {
+		if err := sr.removeStaleSocket(); err != nil {
+			sr.logger.Errorf("StreamReader: not listening on %s %s: %s", sr.network, sr.address, err)
+			return
+		}
+	}
+
+	listener, err := net.Listen(sr.network, sr.address)
+	if err != nil {
+		sr.logger.Fatalf("Failed to listen on %s %s. %s", sr.network, sr.address, err)
+	}
+	sr.logger.Infof("Listening on %s %s", sr.network, sr.address)
+
+	sr.lock.Lock()
+	if sr.stopped {
+		sr.lock.Unlock()
+		listener.Close()
+		return
+	}
+	sr.listener = listener
+	sr.lock.Unlock()
+	defer close(sr.doneChan)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			sr.logger.Debugf("StreamReader: stopped accepting connections: %s", err)
+			break
+		}
+
+		atomic.AddUint64(&sr.acceptedConnectionCount, 1)
+		metrics.BatchIncrementCounter(sr.contextName + ".acceptedConnections")
+
+		if !sr.addConnection(conn) {
+			conn.Close()
+			continue
+		}
+		go sr.handleConnection(conn)
+	}
+
+	sr.wg.Wait()
+}
+
+// Stop closes the listener and every connection and, if the reader was
+// started, waits until the messages it already read have been handed to its
+// writer.
+func (sr *StreamReader) Stop() {
+	sr.lock.Lock()
+	sr.stopped = true
+	started := sr.listener != nil
+	if started {
+		sr.listener.Close()
+	}
+
+	for conn := range sr.connections {
+		conn.Close()
+	}
+	sr.lock.Unlock()
+
+	if started {
+		<-sr.doneChan
+	}
+}
+
+// Address returns the address the reader is listening on, or an empty string
+// if it has not started yet.
+func (sr *StreamReader) Address() string {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+
+	if sr.listener == nil {
+		return ""
+	}
+	return sr.listener.Addr().String()
+}
+
+// ReceivedMessages returns the number of messages read from every connection.
+func (sr *StreamReader) ReceivedMessages() uint64 {
+	return atomic.LoadUint64(&sr.receivedMessageCount)
+}
+
+func (sr *StreamReader) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: sr.contextName,
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "receivedMessageCount", Value: atomic.LoadUint64(&sr.receivedMessageCount)},
+			instrumentation.Metric{Name: "receivedByteCount", Value: atomic.LoadUint64(&sr.receivedByteCount)},
+			instrumentation.Metric{Name: "receiveErrors", Value: atomic.LoadUint64(&sr.receiveErrorCount)},
+			instrumentation.Metric{Name: "acceptedConnections", Value: atomic.LoadUint64(&sr.acceptedConnectionCount)},
+			instrumentation.Metric{Name: "openConnections", Value: atomic.LoadInt64(&sr.openConnectionCount)},
+		},
+	}
+}
+
+func (sr *StreamReader) handleConnection(conn net.Conn) {
+	defer sr.wg.Done()
+	defer sr.removeConnection(conn)
+	defer conn.Close()
+
+	for {
+		var size uint32
+		err := binary.Read(conn, binary.LittleEndian, &size)
+		if err != nil {
+			if err != io.EOF {
+				sr.logger.Debugf("StreamReader: error reading message size: %s", err)
+			}
+			return
+		}
+
+		if size > maxMessageSize {
+			sr.logger.Warnf("StreamReader: message of %d bytes exceeds maximum, closing connection", size)
+			sr.incrementReceiveErrors()
+			return
+		}
+
+		message := make([]byte, size)
+		if _, err := io.ReadFull(conn, message); err != nil {
+			sr.logger.Debugf("StreamReader: error reading message: %s", err)
+			sr.incrementReceiveErrors()
+			return
+		}
+
+		atomic.AddUint64(&sr.receivedMessageCount, 1)
+		atomic.AddUint64(&sr.receivedByteCount, uint64(size))
+		metrics.BatchIncrementCounter(sr.contextName + ".receivedMessageCount")
+		metrics.BatchAddCounter(sr.contextName+".receivedByteCount", uint64(size))
+
+		sr.writer.Write(message)
+	}
+}
+
+// addConnection tracks a new connection and reports whether it may be read
+// from, which it may not once the reader is stopped.
+func (sr *StreamReader) addConnection(conn net.Conn) bool {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+
+	if sr.stopped {
+		return false
+	}
+	sr.connections[conn] = struct{}{}
+	atomic.AddInt64(&sr.openConnectionCount, 1)
+	sr.wg.Add(1)
+	return true
+}
+
+func (sr *StreamReader) removeConnection(conn net.Conn) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	delete(sr.connections, conn)
+	atomic.AddInt64(&sr.openConnectionCount, -1)
+}
+
+func (sr *StreamReader) incrementReceiveErrors() {
+	atomic.AddUint64(&sr.receiveErrorCount, 1)
+	metrics.BatchIncrementCounter(sr.contextName + ".receiveErrors")
+}
@@ -0,0 +1,13 @@
+package streamreader_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStreamReader(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "StreamReader Suite")
+}
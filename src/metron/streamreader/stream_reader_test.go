@@ -0,0 +1,245 @@
+package streamreader_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"metron/streamreader"
+	"metron/writers/mocks"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StreamReader", func() {
+	var (
+		reader  *streamreader.StreamReader
+		writer  *mocks.MockByteArrayWriter
+		stopped chan struct{}
+	)
+
+	start := func(network, address string) {
+		reader = streamreader.New(network, address, "streamReader", writer, loggertesthelper.Logger())
+		stopped = make(chan struct{})
+		go func() {
+			reader.Start()
+			close(stopped)
+		}()
+		Eventually(reader.Address).ShouldNot(BeEmpty())
+	}
+
+	BeforeEach(func() {
+		writer = &mocks.MockByteArrayWriter{}
+	})
+
+	AfterEach(func() {
+		reader.Stop()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	Context("over TCP", func() {
+		BeforeEach(func() {
+			start("tcp", "127.0.0.1:0")
+		})
+
+		It("sends length-prefixed messages to its writer", func() {
+			conn, err := net.Dial("tcp", reader.Address())
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			writeMessage(conn, []byte("one"))
+			writeMessage(conn, []byte("two"))
+
+			Eventually(writer.Data).Should(Equal([][]byte{[]byte("one"), []byte("two")}))
+			testhelpers.EventuallyExpectMetric(reader, "receivedMessageCount", 2)
+			testhelpers.EventuallyExpectMetric(reader, "receivedByteCount", 6)
+			testhelpers.EventuallyExpectMetric(reader, "openConnections", 1)
+		})
+
+		It("closes connections that send oversized messages", func() {
+			conn, err := net.Dial("tcp", reader.Address())
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			binary.Write(conn, binary.LittleEndian, uint32(1<<20))
+
+			testhelpers.EventuallyExpectMetric(reader, "receiveErrors", 1)
+			testhelpers.EventuallyExpectMetric(reader, "openConnections", 0)
+		})
+	})
+
+	Context("over a Unix domain socket", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "metron-stream")
+			Expect(err).NotTo(HaveOccurred())
+
+			start("unix", filepath.Join(dir, "metron.sock"))
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("sends length-prefixed messages to its writer", func() {
+			conn, err := net.Dial("unix", filepath.Join(dir, "metron.sock"))
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			writeMessage(conn, []byte("hello"))
+
+			Eventually(writer.Data).Should(Equal([][]byte{[]byte("hello")}))
+		})
+	})
+
+	Context("when the Unix domain socket path exists", func() {
+		var (
+			dir  string
+			path string
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "metron-stream")
+			Expect(err).NotTo(HaveOccurred())
+			path = filepath.Join(dir, "metron.sock")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("replaces a socket left behind by an earlier run", func() {
+			listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+			Expect(err).NotTo(HaveOccurred())
+			listener.SetUnlinkOnClose(false)
+			listener.Close()
+
+			start("unix", path)
+
+			conn, err := net.Dial("unix", path)
+			Expect(err).NotTo(HaveOccurred())
+			conn.Close()
+		})
+
+		It("does not listen and leaves a file that is not a socket alone", func() {
+			loggertesthelper.TestLoggerSink.Clear()
+			Expect(ioutil.WriteFile(path, []byte("precious"), 0600)).To(Succeed())
+
+			reader = streamreader.New("unix", path, "streamReader", writer, loggertesthelper.Logger())
+			stopped = make(chan struct{})
+			go func() {
+				reader.Start()
+				close(stopped)
+			}()
+			Eventually(stopped).Should(BeClosed())
+			Expect(reader.Address()).To(BeEmpty())
+			Expect(string(loggertesthelper.TestLoggerSink.LogContents())).To(ContainSubstring(path + " exists and is not a socket"))
+
+			contents, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("precious"))
+		})
+	})
+
+	Context("when the writer is slow", func() {
+		var blockingWriter *blockingWriter
+
+		BeforeEach(func() {
+			blockingWriter = newBlockingWriter()
+			reader = streamreader.New("tcp", "127.0.0.1:0", "streamReader", blockingWriter, loggertesthelper.Logger())
+			stopped = make(chan struct{})
+			go func() {
+				reader.Start()
+				close(stopped)
+			}()
+			Eventually(reader.Address).ShouldNot(BeEmpty())
+		})
+
+		AfterEach(func() {
+			blockingWriter.release()
+		})
+
+		It("stops reading until the writer catches up", func() {
+			conn, err := net.Dial("tcp", reader.Address())
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			writeMessage(conn, []byte("first"))
+			writeMessage(conn, []byte("second"))
+
+			Eventually(blockingWriter.started).Should(Receive())
+			Consistently(func() uint64 { return receivedMessages(reader) }, 100*time.Millisecond).Should(BeEquivalentTo(1))
+
+			blockingWriter.release()
+			Eventually(func() uint64 { return receivedMessages(reader) }).Should(BeEquivalentTo(2))
+		})
+
+		It("waits for the message being written when stopped", func() {
+			conn, err := net.Dial("tcp", reader.Address())
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			writeMessage(conn, []byte("first"))
+			Eventually(blockingWriter.started).Should(Receive())
+
+			stopReturned := make(chan struct{})
+			go func() {
+				reader.Stop()
+				close(stopReturned)
+			}()
+			Consistently(stopReturned, 100*time.Millisecond).ShouldNot(BeClosed())
+
+			blockingWriter.release()
+			Eventually(stopReturned).Should(BeClosed())
+		})
+	})
+})
+
+func writeMessage(conn net.Conn, message []byte) {
+	err := binary.Write(conn, binary.LittleEndian, uint32(len(message)))
+	Expect(err).NotTo(HaveOccurred())
+	_, err = conn.Write(message)
+	Expect(err).NotTo(HaveOccurred())
+}
+
+func receivedMessages(reader *streamreader.StreamReader) uint64 {
+	for _, metric := range reader.Emit().Metrics {
+		if metric.Name == "receivedMessageCount" {
+			return metric.Value.(uint64)
+		}
+	}
+	return 0
+}
+
+type blockingWriter struct {
+	started  chan struct{}
+	unblock  chan struct{}
+	released sync.Once
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{
+		started: make(chan struct{}, 10),
+		unblock: make(chan struct{}),
+	}
+}
+
+func (w *blockingWriter) Write([]byte) {
+	w.started <- struct{}{}
+	<-w.unblock
+}
+
+func (w *blockingWriter) release() {
+	w.released.Do(func() { close(w.unblock) })
+}
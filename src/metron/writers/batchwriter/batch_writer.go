@@ -0,0 +1,162 @@
+package batchwriter
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"common/batch"
+	"metron/writers"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// A BatchWriter collects signed messages into a single datagram and forwards
+// it once the batch would exceed maxBytes or flushInterval has passed since
+// the first message was added, whichever comes first.
+type BatchWriter struct {
+	outputWriter  writers.ByteArrayWriter
+	maxBytes      int
+	flushInterval time.Duration
+	logger        *gosteno.Logger
+
+	buffer       *bytes.Buffer
+	messageCount uint64
+	timer        *time.Timer
+	lock         sync.Mutex
+
+	batchesSent      uint64
+	messagesBatched  uint64
+	sizeFlushes      uint64
+	intervalFlushes  uint64
+	oversizeMessages uint64
+	lastBatchSize    uint64
+}
+
+func New(outputWriter writers.ByteArrayWriter, maxBytes int, flushInterval time.Duration, logger *gosteno.Logger) *BatchWriter {
+	return &BatchWriter{
+		outputWriter:  outputWriter,
+		maxBytes:      maxBytes,
+		flushInterval: flushInterval,
+		logger:        logger,
+		buffer:        newBuffer(maxBytes),
+	}
+}
+
+func (w *BatchWriter) Write(message []byte) {
+	w.lock.Lock()
+	batchSize := w.write(message)
+	w.lock.Unlock()
+
+	sendBatchSize(batchSize)
+}
+
+func (w *BatchWriter) write(message []byte) uint64 {
+	if len(batch.Header)+batch.EncodedSize(message) > w.maxBytes {
+		batchSize := w.flush()
+		atomic.AddUint64(&w.oversizeMessages, 1)
+		metrics.BatchIncrementCounter("batchWriter.oversizeMessages")
+		w.logger.Debugf("BatchWriter: message of %d bytes does not fit in a batch, sending it on its own", len(message))
+		w.outputWriter.Write(message)
+		return batchSize
+	}
+
+	var batchSize uint64
+	if w.buffer.Len()+batch.EncodedSize(message) > w.maxBytes {
+		atomic.AddUint64(&w.sizeFlushes, 1)
+		metrics.BatchIncrementCounter("batchWriter.sizeFlushes")
+		batchSize = w.flush()
+	}
+
+	batch.Append(w.buffer, message)
+	w.messageCount++
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.flushInterval, w.flushOnInterval)
+	}
+	return batchSize
+}
+
+// Flush sends any buffered messages immediately.
+func (w *BatchWriter) Flush() {
+	w.lock.Lock()
+	batchSize := w.flush()
+	w.lock.Unlock()
+
+	sendBatchSize(batchSize)
+}
+
+// Stop flushes any buffered messages and cancels the pending flush timer.
+func (w *BatchWriter) Stop() {
+	w.Flush()
+}
+
+func (w *BatchWriter) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "batchWriter",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "batchesSent", Value: atomic.LoadUint64(&w.batchesSent)},
+			instrumentation.Metric{Name: "messagesBatched", Value: atomic.LoadUint64(&w.messagesBatched)},
+			instrumentation.Metric{Name: "sizeFlushes", Value: atomic.LoadUint64(&w.sizeFlushes)},
+			instrumentation.Metric{Name: "intervalFlushes", Value: atomic.LoadUint64(&w.intervalFlushes)},
+			instrumentation.Metric{Name: "oversizeMessages", Value: atomic.LoadUint64(&w.oversizeMessages)},
+			instrumentation.Metric{Name: "lastBatchSize", Value: atomic.LoadUint64(&w.lastBatchSize)},
+		},
+	}
+}
+
+func (w *BatchWriter) flushOnInterval() {
+	w.lock.Lock()
+	var batchSize uint64
+	if w.messageCount > 0 {
+		atomic.AddUint64(&w.intervalFlushes, 1)
+		metrics.BatchIncrementCounter("batchWriter.intervalFlushes")
+		batchSize = w.flush()
+	}
+	w.lock.Unlock()
+
+	sendBatchSize(batchSize)
+}
+
+// flush must be called with the lock held. It returns the number of messages
+// sent, which the caller reports with sendBatchSize once it has released the
+// lock: the metric is written straight back through this writer.
+func (w *BatchWriter) flush() uint64 {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+
+	if w.messageCount == 0 {
+		return 0
+	}
+
+	w.outputWriter.Write(w.buffer.Bytes())
+
+	batchSize := w.messageCount
+	atomic.AddUint64(&w.batchesSent, 1)
+	atomic.AddUint64(&w.messagesBatched, batchSize)
+	atomic.StoreUint64(&w.lastBatchSize, batchSize)
+	metrics.BatchIncrementCounter("batchWriter.batchesSent")
+	metrics.BatchAddCounter("batchWriter.messagesBatched", batchSize)
+
+	w.buffer = newBuffer(w.maxBytes)
+	w.messageCount = 0
+	return batchSize
+}
+
+func sendBatchSize(batchSize uint64) {
+	if batchSize == 0 {
+		return
+	}
+	metrics.SendValue("batchWriter.batchSize", float64(batchSize), "messages")
+}
+
+func newBuffer(maxBytes int) *bytes.Buffer {
+	buffer := bytes.NewBuffer(make([]byte, 0, maxBytes))
+	buffer.Write(batch.Header)
+	return buffer
+}
@@ -0,0 +1,13 @@
+package batchwriter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBatchWriter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "BatchWriter Suite")
+}
@@ -0,0 +1,126 @@
+package batchwriter_test
+
+import (
+	"time"
+
+	"common/batch"
+	"metron/eventwriter"
+	"metron/writers/batchwriter"
+	"metron/writers/eventmarshaller"
+	"metron/writers/mocks"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metricbatcher"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BatchWriter", func() {
+	var (
+		outputWriter *mocks.MockByteArrayWriter
+		writer       *batchwriter.BatchWriter
+	)
+
+	BeforeEach(func() {
+		outputWriter = &mocks.MockByteArrayWriter{}
+		writer = batchwriter.New(outputWriter, 32, time.Hour, loggertesthelper.Logger())
+	})
+
+	AfterEach(func() {
+		writer.Stop()
+	})
+
+	It("buffers messages until the batch is flushed", func() {
+		writer.Write([]byte("one"))
+		writer.Write([]byte("two"))
+		Expect(outputWriter.Data()).To(BeEmpty())
+
+		writer.Flush()
+
+		Expect(outputWriter.Data()).To(HaveLen(1))
+		messages, err := batch.Split(outputWriter.Data()[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(Equal([][]byte{[]byte("one"), []byte("two")}))
+		testhelpers.EventuallyExpectMetric(writer, "batchesSent", 1)
+		testhelpers.EventuallyExpectMetric(writer, "messagesBatched", 2)
+	})
+
+	It("flushes when the next message would exceed the maximum size", func() {
+		writer.Write([]byte("0123456789"))
+		writer.Write([]byte("0123456789"))
+
+		Expect(outputWriter.Data()).To(HaveLen(1))
+		messages, _ := batch.Split(outputWriter.Data()[0])
+		Expect(messages).To(HaveLen(1))
+		testhelpers.EventuallyExpectMetric(writer, "sizeFlushes", 1)
+	})
+
+	It("sends messages that don't fit in a batch on their own", func() {
+		writer.Write([]byte("small"))
+		writer.Write([]byte("a message that is too large for a batch"))
+
+		Expect(outputWriter.Data()).To(HaveLen(2))
+		Expect(batch.IsBatch(outputWriter.Data()[0])).To(BeTrue())
+		Expect(outputWriter.Data()[1]).To(Equal([]byte("a message that is too large for a batch")))
+		testhelpers.EventuallyExpectMetric(writer, "oversizeMessages", 1)
+	})
+
+	It("does not send empty batches", func() {
+		writer.Flush()
+		Expect(outputWriter.Data()).To(BeEmpty())
+	})
+
+	Context("when its own metrics are written back through it", func() {
+		BeforeEach(func() {
+			writer = batchwriter.New(outputWriter, 1024, time.Hour, loggertesthelper.Logger())
+
+			marshaller := eventmarshaller.New(writer, loggertesthelper.Logger())
+			metricSender := metric_sender.NewMetricSender(eventwriter.New("MetronAgent", marshaller))
+			metrics.Initialize(metricSender, metricbatcher.New(metricSender, time.Hour))
+		})
+
+		AfterEach(func() {
+			metrics.Initialize(nil, nil)
+		})
+
+		It("does not deadlock when flushing", func(done Done) {
+			defer close(done)
+
+			writer.Write([]byte("one"))
+			writer.Write([]byte("two"))
+			writer.Flush()
+			Expect(outputWriter.Data()).To(HaveLen(1))
+
+			writer.Flush()
+			Expect(outputWriter.Data()).To(HaveLen(2))
+
+			messages, err := batch.Split(outputWriter.Data()[1])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messages).To(HaveLen(1))
+
+			var envelope events.Envelope
+			Expect(proto.Unmarshal(messages[0], &envelope)).To(Succeed())
+			Expect(envelope.GetValueMetric().GetName()).To(Equal("batchWriter.batchSize"))
+			Expect(envelope.GetValueMetric().GetValue()).To(BeNumerically("==", 2))
+		})
+	})
+
+	Context("when the flush interval passes", func() {
+		BeforeEach(func() {
+			writer = batchwriter.New(outputWriter, 1024, 10*time.Millisecond, loggertesthelper.Logger())
+		})
+
+		It("flushes the pending batch", func() {
+			writer.Write([]byte("one"))
+
+			Eventually(outputWriter.Data).Should(HaveLen(1))
+			testhelpers.EventuallyExpectMetric(writer, "intervalFlushes", 1)
+		})
+	})
+})
@@ -0,0 +1,83 @@
+package compressor
+
+import (
+	"sync/atomic"
+
+	"common/compression"
+	"metron/writers"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// A Compressor compresses marshalled envelopes before they are signed and
+// sent to Doppler. Messages that don't get smaller, which is common for tiny
+// metrics, are sent uncompressed; Doppler accepts both.
+type Compressor struct {
+	compressor   *compression.Compressor
+	outputWriter writers.ByteArrayWriter
+	logger       *gosteno.Logger
+
+	compressedMessageCount   uint64
+	uncompressedMessageCount uint64
+	receivedByteCount        uint64
+	sentByteCount            uint64
+}
+
+func New(algorithm string, outputWriter writers.ByteArrayWriter, logger *gosteno.Logger) (*Compressor, error) {
+	compressor, err := compression.NewCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Compressor{
+		compressor:   compressor,
+		outputWriter: outputWriter,
+		logger:       logger,
+	}, nil
+}
+
+func (c *Compressor) Write(message []byte) {
+	atomic.AddUint64(&c.receivedByteCount, uint64(len(message)))
+	metrics.BatchAddCounter("compressor.receivedBytes", uint64(len(message)))
+
+	compressed := c.compressor.Compress(message)
+	if len(compressed) >= len(message) {
+		atomic.AddUint64(&c.uncompressedMessageCount, 1)
+		c.send(message)
+		return
+	}
+
+	atomic.AddUint64(&c.compressedMessageCount, 1)
+	c.send(compressed)
+}
+
+func (c *Compressor) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "compressor",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "compressedMessages", Value: atomic.LoadUint64(&c.compressedMessageCount)},
+			instrumentation.Metric{Name: "uncompressedMessages", Value: atomic.LoadUint64(&c.uncompressedMessageCount)},
+			instrumentation.Metric{Name: "receivedBytes", Value: atomic.LoadUint64(&c.receivedByteCount)},
+			instrumentation.Metric{Name: "sentBytes", Value: atomic.LoadUint64(&c.sentByteCount)},
+			instrumentation.Metric{Name: "compressionRatio", Value: c.CompressionRatio()},
+		},
+	}
+}
+
+// CompressionRatio returns how many bytes were received for every byte sent,
+// or 1 before anything was sent.
+func (c *Compressor) CompressionRatio() float64 {
+	sent := atomic.LoadUint64(&c.sentByteCount)
+	if sent == 0 {
+		return 1
+	}
+	return float64(atomic.LoadUint64(&c.receivedByteCount)) / float64(sent)
+}
+
+func (c *Compressor) send(message []byte) {
+	atomic.AddUint64(&c.sentByteCount, uint64(len(message)))
+	metrics.BatchAddCounter("compressor.sentBytes", uint64(len(message)))
+	c.outputWriter.Write(message)
+}
@@ -0,0 +1,68 @@
+package compressor_test
+
+import (
+	"bytes"
+
+	"common/compression"
+	"metron/writers/compressor"
+	"metron/writers/mocks"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compressor", func() {
+	var (
+		mockWriter *mocks.MockByteArrayWriter
+		writer     *compressor.Compressor
+	)
+
+	BeforeEach(func() {
+		mockWriter = &mocks.MockByteArrayWriter{}
+
+		var err error
+		writer, err = compressor.New(compression.Gzip, mockWriter, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("compresses messages", func() {
+		message := bytes.Repeat([]byte("log line "), 100)
+		writer.Write(message)
+
+		Expect(mockWriter.Data()).To(HaveLen(1))
+		Expect(compression.IsCompressed(mockWriter.Data()[0])).To(BeTrue())
+
+		decompressed, err := compression.Decompress(mockWriter.Data()[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decompressed).To(Equal(message))
+	})
+
+	It("sends messages that don't get smaller uncompressed", func() {
+		writer.Write([]byte("tiny"))
+
+		Expect(mockWriter.Data()).To(Equal([][]byte{[]byte("tiny")}))
+	})
+
+	It("reports the compression ratio", func() {
+		Expect(writer.CompressionRatio()).To(Equal(1.0))
+
+		writer.Write(bytes.Repeat([]byte("log line "), 100))
+		writer.Write([]byte("tiny"))
+
+		sent := len(mockWriter.Data()[0]) + len(mockWriter.Data()[1])
+		Expect(writer.CompressionRatio()).To(Equal(float64(900+4) / float64(sent)))
+
+		metrics := writer.Emit().Metrics
+		Expect(metrics[0].Value).To(BeEquivalentTo(1))
+		Expect(metrics[1].Value).To(BeEquivalentTo(1))
+		Expect(metrics[2].Value).To(BeEquivalentTo(904))
+		Expect(metrics[3].Value).To(BeEquivalentTo(sent))
+	})
+
+	It("rejects unknown algorithms", func() {
+		_, err := compressor.New("bogus", mockWriter, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,6 +1,10 @@
 package dopplerforwarder
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/loggregatorclient"
@@ -10,9 +14,34 @@ type ClientPool interface {
 	RandomClient() (loggregatorclient.LoggregatorClient, error)
 }
 
+type Spool interface {
+	Append(message []byte) error
+	Len() int
+	Replay(max int, send func(message []byte)) (int, error)
+}
+
+// ReplayBatchSize and ReplayInterval pace the replay of spooled messages:
+// at most ReplayBatchSize of them are sent every ReplayInterval, so a
+// Doppler that comes back is not flooded with the backlog.
+var (
+	ReplayBatchSize = 100
+	ReplayInterval  = 10 * time.Millisecond
+)
+
 type DopplerForwarder struct {
 	clientPool ClientPool
+	spool      Spool
 	logger     *gosteno.Logger
+	lock       sync.Mutex
+
+	replaying bool
+	stopped   bool
+	stopChan  chan struct{}
+	replayWg  sync.WaitGroup
+
+	sentMessageCount  uint64
+	forwardErrorCount uint64
+	lastSendTime      int64
 }
 
 func New(clientPool ClientPool, logger *gosteno.Logger) *DopplerForwarder {
@@ -22,12 +51,163 @@ func New(clientPool ClientPool, logger *gosteno.Logger) *DopplerForwarder {
 	}
 }
 
+// NewWithSpool returns a DopplerForwarder that buffers messages in spool
+// while no Doppler is available and replays them, in order, once one is.
+// The replay runs in the background; messages written meanwhile are queued
+// behind the spooled ones.
+func NewWithSpool(clientPool ClientPool, spool Spool, logger *gosteno.Logger) *DopplerForwarder {
+	return &DopplerForwarder{
+		clientPool: clientPool,
+		spool:      spool,
+		logger:     logger,
+		stopChan:   make(chan struct{}),
+	}
+}
+
 func (d *DopplerForwarder) Write(message []byte) {
+	if d.spool != nil {
+		d.writeWithSpool(message)
+		return
+	}
+
 	client, err := d.clientPool.RandomClient()
 	if err != nil {
 		d.logger.Errorf("can't forward message: %v", err)
+		atomic.AddUint64(&d.forwardErrorCount, 1)
+		return
+	}
+	d.send(client, message)
+}
+
+func (d *DopplerForwarder) writeWithSpool(message []byte) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.replaying {
+		d.appendToSpool(message)
+		return
+	}
+
+	client, err := d.clientPool.RandomClient()
+	if err != nil {
+		d.logger.Debugf("can't forward message, spooling it: %v", err)
+		atomic.AddUint64(&d.forwardErrorCount, 1)
+		d.appendToSpool(message)
+		return
+	}
+
+	if d.spool.Len() > 0 {
+		d.appendToSpool(message)
+		d.startReplay()
+		return
+	}
+
+	d.send(client, message)
+}
+
+// Stop ends a replay in progress. Messages not replayed yet stay in the
+// spool.
+func (d *DopplerForwarder) Stop() {
+	d.lock.Lock()
+	if d.stopChan != nil && !d.stopped {
+		d.stopped = true
+		close(d.stopChan)
+	}
+	d.lock.Unlock()
+
+	d.replayWg.Wait()
+}
+
+func (d *DopplerForwarder) appendToSpool(message []byte) {
+	if err := d.spool.Append(message); err != nil {
+		d.logger.Errorf("can't spool message: %v", err)
+	}
+}
+
+// startReplay must be called with d.lock held.
+func (d *DopplerForwarder) startReplay() {
+	if d.stopped {
 		return
 	}
+
+	d.logger.Infof("replaying %d spooled messages", d.spool.Len())
+	d.replaying = true
+	d.replayWg.Add(1)
+	go d.replay()
+}
+
+func (d *DopplerForwarder) replay() {
+	defer d.replayWg.Done()
+
+	ticker := time.NewTicker(ReplayInterval)
+	defer ticker.Stop()
+
+	for d.replayBatch() {
+		select {
+		case <-ticker.C:
+		case <-d.stopChan:
+			d.lock.Lock()
+			d.replaying = false
+			d.lock.Unlock()
+			return
+		}
+	}
+}
+
+// replayBatch sends the next batch of spooled messages. It returns false,
+// ending the replay, once the spool is empty or no Doppler is available.
+func (d *DopplerForwarder) replayBatch() bool {
+	d.lock.Lock()
+	if d.spool.Len() == 0 {
+		d.logger.Info("replayed all spooled messages")
+		d.replaying = false
+		d.lock.Unlock()
+		return false
+	}
+	d.lock.Unlock()
+
+	client, err := d.clientPool.RandomClient()
+	if err != nil {
+		d.logger.Debugf("can't replay spooled messages: %v", err)
+		d.lock.Lock()
+		d.replaying = false
+		d.lock.Unlock()
+		return false
+	}
+
+	_, err = d.spool.Replay(ReplayBatchSize, func(spooled []byte) {
+		d.send(client, spooled)
+	})
+	if err != nil {
+		d.logger.Errorf("error replaying spooled messages: %v", err)
+	}
+	return true
+}
+
+func (d *DopplerForwarder) send(client loggregatorclient.LoggregatorClient, message []byte) {
 	client.Send(message)
 	metrics.BatchIncrementCounter("DopplerForwarder.sentMessages")
+	atomic.AddUint64(&d.sentMessageCount, 1)
+	atomic.StoreInt64(&d.lastSendTime, time.Now().UnixNano())
+}
+
+// SentMessages returns the number of messages handed to a Doppler client.
+func (d *DopplerForwarder) SentMessages() uint64 {
+	return atomic.LoadUint64(&d.sentMessageCount)
+}
+
+// ForwardErrors returns the number of messages that could not be sent
+// because no Doppler was available, whether or not they were spooled.
+func (d *DopplerForwarder) ForwardErrors() uint64 {
+	return atomic.LoadUint64(&d.forwardErrorCount)
+}
+
+// LastSendTime returns when a message was last sent, or the zero time if
+// none has been.
+func (d *DopplerForwarder) LastSendTime() time.Time {
+	nanos := atomic.LoadInt64(&d.lastSendTime)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
 }
@@ -1,6 +1,9 @@
 package dopplerforwarder_test
 
 import (
+	"errors"
+	"sync"
+
 	"metron/writers/dopplerforwarder"
 
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
@@ -49,25 +52,197 @@ var _ = Describe("DopplerForwarder", func() {
 
 		Eventually(func() uint64 { return sender.GetCounter("DopplerForwarder.sentMessages") }).Should(BeEquivalentTo(1))
 	})
+
+	It("tracks sent messages, forward errors and the last send time", func() {
+		Expect(forwarder.LastSendTime().IsZero()).To(BeTrue())
+
+		forwarder.Write([]byte("Some message"))
+		clientPool.setErr(errors.New("no dopplers"))
+		forwarder.Write([]byte("Another message"))
+
+		Expect(forwarder.SentMessages()).To(Equal(uint64(1)))
+		Expect(forwarder.ForwardErrors()).To(Equal(uint64(1)))
+		Expect(forwarder.LastSendTime()).To(BeTemporally("~", time.Now(), time.Second))
+	})
+
+	Context("with a spool", func() {
+		var (
+			spool                   *mockSpool
+			originalReplayBatchSize int
+			originalReplayInterval  time.Duration
+		)
+
+		BeforeEach(func() {
+			originalReplayBatchSize = dopplerforwarder.ReplayBatchSize
+			originalReplayInterval = dopplerforwarder.ReplayInterval
+
+			spool = &mockSpool{}
+			forwarder = dopplerforwarder.NewWithSpool(clientPool, spool, logger)
+		})
+
+		AfterEach(func() {
+			forwarder.Stop()
+			dopplerforwarder.ReplayBatchSize = originalReplayBatchSize
+			dopplerforwarder.ReplayInterval = originalReplayInterval
+		})
+
+		It("spools messages while no doppler is available", func() {
+			clientPool.setErr(errors.New("no dopplers"))
+
+			forwarder.Write([]byte("one"))
+			forwarder.Write([]byte("two"))
+
+			Expect(spool.Messages()).To(Equal([][]byte{[]byte("one"), []byte("two")}))
+		})
+
+		It("replays spooled messages before sending new ones", func() {
+			clientPool.setErr(errors.New("no dopplers"))
+			forwarder.Write([]byte("one"))
+
+			clientPool.setErr(nil)
+			forwarder.Write([]byte("two"))
+			forwarder.Write([]byte("three"))
+
+			Eventually(spool.Messages).Should(BeEmpty())
+			Expect(clientPool.client().Data()).To(Equal([][]byte{[]byte("one"), []byte("two"), []byte("three")}))
+		})
+
+		It("replays in batches, leaving writers free to queue messages meanwhile", func() {
+			dopplerforwarder.ReplayBatchSize = 1
+			dopplerforwarder.ReplayInterval = 50 * time.Millisecond
+
+			clientPool.setErr(errors.New("no dopplers"))
+			forwarder.Write([]byte("one"))
+			forwarder.Write([]byte("two"))
+			clientPool.setErr(nil)
+
+			forwarder.Write([]byte("three"))
+			Expect(spool.Len()).To(BeNumerically(">=", 2))
+
+			Eventually(spool.Messages).Should(BeEmpty())
+			Expect(spool.maxBatch).To(Equal(1))
+			Expect(clientPool.client().Data()).To(Equal([][]byte{[]byte("one"), []byte("two"), []byte("three")}))
+		})
+
+		It("sends new messages directly once the spool is empty", func() {
+			dopplerforwarder.ReplayInterval = time.Millisecond
+
+			clientPool.setErr(errors.New("no dopplers"))
+			forwarder.Write([]byte("one"))
+			clientPool.setErr(nil)
+			forwarder.Write([]byte("two"))
+			Eventually(spool.Messages).Should(BeEmpty())
+			time.Sleep(10 * dopplerforwarder.ReplayInterval)
+
+			forwarder.Write([]byte("three"))
+
+			Expect(spool.Messages()).To(BeEmpty())
+			Expect(clientPool.client().Data()).To(Equal([][]byte{[]byte("one"), []byte("two"), []byte("three")}))
+		})
+
+		It("stops replaying when stopped, leaving the rest in the spool", func() {
+			dopplerforwarder.ReplayBatchSize = 1
+			dopplerforwarder.ReplayInterval = time.Hour
+
+			clientPool.setErr(errors.New("no dopplers"))
+			forwarder.Write([]byte("one"))
+			clientPool.setErr(nil)
+			forwarder.Write([]byte("two"))
+
+			forwarder.Stop()
+
+			Expect(spool.Messages()).To(Equal([][]byte{[]byte("two")}))
+		})
+	})
 })
 
+type mockSpool struct {
+	messages [][]byte
+	maxBatch int
+	sync.Mutex
+}
+
+func (m *mockSpool) Append(message []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *mockSpool) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.messages)
+}
+
+func (m *mockSpool) Messages() [][]byte {
+	m.Lock()
+	defer m.Unlock()
+	return m.messages
+}
+
+func (m *mockSpool) Replay(max int, send func(message []byte)) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	if max > m.maxBatch {
+		m.maxBatch = max
+	}
+	sent := 0
+	for len(m.messages) > 0 && sent < max {
+		send(m.messages[0])
+		m.messages = m.messages[1:]
+		sent++
+	}
+	return sent, nil
+}
+
 type mockClientPool struct {
 	randomClient *mockClient
+	err          error
+	sync.Mutex
 }
 
 func (m *mockClientPool) RandomClient() (loggregatorclient.LoggregatorClient, error) {
-	m.randomClient = &mockClient{}
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.randomClient == nil {
+		m.randomClient = &mockClient{}
+	}
 	return m.randomClient, nil
 }
 
+func (m *mockClientPool) setErr(err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.err = err
+}
+
+func (m *mockClientPool) client() *mockClient {
+	m.Lock()
+	defer m.Unlock()
+	return m.randomClient
+}
+
 type mockClient struct {
 	data [][]byte
+	sync.Mutex
 }
 
 func (m *mockClient) Send(p []byte) {
+	m.Lock()
+	defer m.Unlock()
 	m.data = append(m.data, p)
 }
 
+func (m *mockClient) Data() [][]byte {
+	m.Lock()
+	defer m.Unlock()
+	return m.data
+}
+
 func (m *mockClient) Emit() instrumentation.Context {
 	return instrumentation.Context{}
 }
@@ -0,0 +1,149 @@
+package envelopefilter
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"metron/writers"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// A Rule allows or denies the envelopes it matches. Every non-empty field
+// must match: Origin and EventType exactly, MetricPrefix against the name of
+// ValueMetrics and CounterEvents, and AppId against the app of LogMessages.
+// A rule with no fields set matches every envelope. Name identifies the rule
+// in metrics and defaults to its position in the list.
+type Rule struct {
+	Name         string
+	Action       string
+	Origin       string
+	EventType    string
+	MetricPrefix string
+	AppId        string
+}
+
+func (r Rule) matches(envelope *events.Envelope) bool {
+	if r.Origin != "" && r.Origin != envelope.GetOrigin() {
+		return false
+	}
+
+	if r.EventType != "" && r.EventType != envelope.GetEventType().String() {
+		return false
+	}
+
+	if r.MetricPrefix != "" {
+		name, ok := metricName(envelope)
+		if !ok || !strings.HasPrefix(name, r.MetricPrefix) {
+			return false
+		}
+	}
+
+	if r.AppId != "" {
+		if envelope.GetEventType() != events.Envelope_LogMessage || envelope.GetLogMessage().GetAppId() != r.AppId {
+			return false
+		}
+	}
+
+	return true
+}
+
+func metricName(envelope *events.Envelope) (string, bool) {
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric:
+		return envelope.GetValueMetric().GetName(), true
+	case events.Envelope_CounterEvent:
+		return envelope.GetCounterEvent().GetName(), true
+	}
+	return "", false
+}
+
+// An EnvelopeFilter drops envelopes according to an ordered list of rules.
+// The first matching rule decides; envelopes no rule matches are allowed.
+type EnvelopeFilter struct {
+	rules        []Rule
+	outputWriter writers.EnvelopeWriter
+	logger       *gosteno.Logger
+
+	matchCounts    []uint64
+	deniedCount    uint64
+	unmatchedCount uint64
+}
+
+func New(rules []Rule, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) (*EnvelopeFilter, error) {
+	named := make([]Rule, len(rules))
+	for i, rule := range rules {
+		if rule.Action != Allow && rule.Action != Deny {
+			return nil, fmt.Errorf("filter rule %d has invalid action %q, must be %s or %s", i, rule.Action, Allow, Deny)
+		}
+		if rule.EventType != "" {
+			if _, ok := events.Envelope_EventType_value[rule.EventType]; !ok {
+				return nil, fmt.Errorf("filter rule %d has unknown event type %q", i, rule.EventType)
+			}
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i)
+		}
+		named[i] = rule
+	}
+
+	return &EnvelopeFilter{
+		rules:        named,
+		outputWriter: outputWriter,
+		logger:       logger,
+		matchCounts:  make([]uint64, len(named)),
+	}, nil
+}
+
+func (f *EnvelopeFilter) Write(envelope *events.Envelope) {
+	for i, rule := range f.rules {
+		if !rule.matches(envelope) {
+			continue
+		}
+
+		atomic.AddUint64(&f.matchCounts[i], 1)
+		if rule.Action == Deny {
+			atomic.AddUint64(&f.deniedCount, 1)
+			metrics.BatchIncrementCounter("envelopeFilter.deniedMessages")
+			return
+		}
+		f.outputWriter.Write(envelope)
+		return
+	}
+
+	atomic.AddUint64(&f.unmatchedCount, 1)
+	f.outputWriter.Write(envelope)
+}
+
+// DeniedMessages returns the number of envelopes dropped by deny rules.
+func (f *EnvelopeFilter) DeniedMessages() uint64 {
+	return atomic.LoadUint64(&f.deniedCount)
+}
+
+func (f *EnvelopeFilter) Emit() instrumentation.Context {
+	metrics := []instrumentation.Metric{
+		instrumentation.Metric{Name: "deniedMessages", Value: atomic.LoadUint64(&f.deniedCount)},
+		instrumentation.Metric{Name: "unmatchedMessages", Value: atomic.LoadUint64(&f.unmatchedCount)},
+	}
+	for i, rule := range f.rules {
+		metrics = append(metrics, instrumentation.Metric{
+			Name:  rule.Name + ".matchedMessages",
+			Value: atomic.LoadUint64(&f.matchCounts[i]),
+			Tags:  map[string]interface{}{"action": rule.Action},
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "envelopeFilter",
+		Metrics: metrics,
+	}
+}
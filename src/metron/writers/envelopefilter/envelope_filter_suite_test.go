@@ -0,0 +1,13 @@
+package envelopefilter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestEnvelopeFilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "EnvelopeFilter Suite")
+}
@@ -0,0 +1,110 @@
+package envelopefilter_test
+
+import (
+	"metron/writers/envelopefilter"
+	"metron/writers/mocks"
+
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EnvelopeFilter", func() {
+	var (
+		mockWriter *mocks.MockEnvelopeWriter
+		filter     *envelopefilter.EnvelopeFilter
+		rules      []envelopefilter.Rule
+	)
+
+	JustBeforeEach(func() {
+		mockWriter = &mocks.MockEnvelopeWriter{}
+
+		var err error
+		filter, err = envelopefilter.New(rules, mockWriter, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("without rules", func() {
+		BeforeEach(func() {
+			rules = nil
+		})
+
+		It("passes every envelope through", func() {
+			filter.Write(logMessage("origin-a", "app-1"))
+			filter.Write(valueMetric("origin-a", "cpu"))
+
+			Expect(mockWriter.Events).To(HaveLen(2))
+			testhelpers.EventuallyExpectMetric(filter, "unmatchedMessages", 2)
+		})
+	})
+
+	Context("with ordered rules", func() {
+		BeforeEach(func() {
+			rules = []envelopefilter.Rule{
+				{Name: "keepImportantMetrics", Action: envelopefilter.Allow, MetricPrefix: "important."},
+				{Name: "dropNoisyOrigin", Action: envelopefilter.Deny, Origin: "noisy"},
+				{Action: envelopefilter.Deny, EventType: "LogMessage", AppId: "chatty-app"},
+			}
+		})
+
+		It("lets the first matching rule decide", func() {
+			filter.Write(valueMetric("noisy", "important.latency"))
+			filter.Write(valueMetric("noisy", "cpu"))
+
+			Expect(mockWriter.Events).To(HaveLen(1))
+			Expect(mockWriter.Events[0].GetValueMetric().GetName()).To(Equal("important.latency"))
+		})
+
+		It("matches log messages by app id", func() {
+			filter.Write(logMessage("origin-a", "chatty-app"))
+			filter.Write(logMessage("origin-a", "quiet-app"))
+
+			Expect(mockWriter.Events).To(HaveLen(1))
+			Expect(mockWriter.Events[0].GetLogMessage().GetAppId()).To(Equal("quiet-app"))
+		})
+
+		It("counts matches per rule", func() {
+			filter.Write(valueMetric("noisy", "important.latency"))
+			filter.Write(valueMetric("noisy", "cpu"))
+			filter.Write(logMessage("noisy", "app"))
+			filter.Write(logMessage("origin-a", "chatty-app"))
+
+			testhelpers.EventuallyExpectMetric(filter, "keepImportantMetrics.matchedMessages", 1)
+			testhelpers.EventuallyExpectMetric(filter, "dropNoisyOrigin.matchedMessages", 2)
+			testhelpers.EventuallyExpectMetric(filter, "rule2.matchedMessages", 1)
+			testhelpers.EventuallyExpectMetric(filter, "deniedMessages", 3)
+			Expect(filter.DeniedMessages()).To(BeEquivalentTo(3))
+		})
+	})
+
+	It("rejects rules with an invalid action", func() {
+		_, err := envelopefilter.New([]envelopefilter.Rule{{Action: "drop"}}, mockWriter, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects rules with an unknown event type", func() {
+		_, err := envelopefilter.New([]envelopefilter.Rule{{Action: envelopefilter.Deny, EventType: "Bogus"}}, mockWriter, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func logMessage(origin string, appId string) *events.Envelope {
+	return &events.Envelope{
+		Origin:     proto.String(origin),
+		EventType:  events.Envelope_LogMessage.Enum(),
+		LogMessage: factories.NewLogMessage(events.LogMessage_OUT, "message", appId, "App"),
+	}
+}
+
+func valueMetric(origin string, name string) *events.Envelope {
+	return &events.Envelope{
+		Origin:      proto.String(origin),
+		EventType:   events.Envelope_ValueMetric.Enum(),
+		ValueMetric: factories.NewValueMetric(name, 1, "units"),
+	}
+}
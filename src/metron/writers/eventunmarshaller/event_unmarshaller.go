@@ -107,6 +107,21 @@ func isUnknownEventType(eventType *events.Envelope_EventType) bool {
 	return false
 }
 
+// MessagesReceived returns the number of envelopes successfully unmarshalled.
+func (u *EventUnmarshaller) MessagesReceived() uint64 {
+	var total uint64
+	for _, counterPointer := range u.receiveCounts {
+		total += atomic.LoadUint64(counterPointer)
+	}
+	return total
+}
+
+// UnmarshalErrors returns the number of messages that could not be
+// unmarshalled or carried an unknown event type.
+func (u *EventUnmarshaller) UnmarshalErrors() uint64 {
+	return atomic.LoadUint64(&u.unmarshalErrorCount) + atomic.LoadUint64(&u.unknownEventTypeCount)
+}
+
 func (u *EventUnmarshaller) metrics() []instrumentation.Metric {
 	var metrics []instrumentation.Metric
 
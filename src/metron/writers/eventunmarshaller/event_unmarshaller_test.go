@@ -83,4 +83,15 @@ var _ = Describe("EventUnmarshaller", func() {
 			Expect(mockWriter.Events).To(HaveLen(0))
 		})
 	})
+
+	Context("MessagesReceived and UnmarshalErrors", func() {
+		It("count good and bad messages", func() {
+			unmarshaller.Write(message)
+			unmarshaller.Write(message)
+			unmarshaller.Write([]byte("Bad Message"))
+
+			Expect(unmarshaller.MessagesReceived()).To(Equal(uint64(2)))
+			Expect(unmarshaller.UnmarshalErrors()).To(Equal(uint64(1)))
+		})
+	})
 })
@@ -0,0 +1,232 @@
+package histogramaggregator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metron/writers"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+var DefaultPercentiles = []float64{50, 95, 99}
+
+// ReservoirSize bounds how many values of each histogram are kept during a
+// window. Beyond that, the percentiles are estimated from a uniform random
+// sample of the values; the count, min, max and mean stay exact.
+var ReservoirSize = 1028
+
+// A HistogramAggregator collects the ValueMetrics with the configured names
+// instead of passing them on. At the end of every window it writes, per
+// origin, name and unit, ValueMetrics for the count, min, max and mean of the
+// values it saw and for each percentile, named <name>.count, <name>.min,
+// <name>.max, <name>.mean and <name>.p<percentile>. Every other envelope is
+// passed through untouched.
+type HistogramAggregator struct {
+	metricNames  map[string]bool
+	percentiles  []float64
+	outputWriter writers.EnvelopeWriter
+	logger       *gosteno.Logger
+
+	histograms map[histogramKey]*histogram
+	random     *rand.Rand
+	lock       sync.Mutex
+
+	aggregatedSampleCount uint64
+	summaryCount          uint64
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type histogramKey struct {
+	origin string
+	name   string
+	unit   string
+}
+
+// A histogram keeps the exact count, min, max and sum of the values of a
+// window and a reservoir sample of at most ReservoirSize of them.
+type histogram struct {
+	count     uint64
+	min       float64
+	max       float64
+	sum       float64
+	reservoir []float64
+}
+
+// add must be called with the lock held, as it uses the aggregator's random
+// source.
+func (h *histogram) add(value float64, random *rand.Rand) {
+	if h.count == 0 || value < h.min {
+		h.min = value
+	}
+	if h.count == 0 || value > h.max {
+		h.max = value
+	}
+	h.count++
+	h.sum += value
+
+	if len(h.reservoir) < ReservoirSize {
+		h.reservoir = append(h.reservoir, value)
+		return
+	}
+	if i := random.Int63n(int64(h.count)); i < int64(len(h.reservoir)) {
+		h.reservoir[i] = value
+	}
+}
+
+func New(metricNames []string, percentiles []float64, window time.Duration, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) (*HistogramAggregator, error) {
+	if len(percentiles) == 0 {
+		percentiles = DefaultPercentiles
+	}
+	if err := ValidatePercentiles(percentiles); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(metricNames))
+	for _, name := range metricNames {
+		names[name] = true
+	}
+
+	h := &HistogramAggregator{
+		metricNames:  names,
+		percentiles:  percentiles,
+		outputWriter: outputWriter,
+		logger:       logger,
+		histograms:   make(map[histogramKey]*histogram),
+		random:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		stopChan:     make(chan struct{}),
+	}
+
+	if window > 0 && len(names) > 0 {
+		go h.runWindows(window)
+	}
+
+	return h, nil
+}
+
+func (h *HistogramAggregator) Write(envelope *events.Envelope) {
+	if envelope.GetEventType() != events.Envelope_ValueMetric || !h.metricNames[envelope.GetValueMetric().GetName()] {
+		h.outputWriter.Write(envelope)
+		return
+	}
+
+	valueMetric := envelope.GetValueMetric()
+	key := histogramKey{
+		origin: envelope.GetOrigin(),
+		name:   valueMetric.GetName(),
+		unit:   valueMetric.GetUnit(),
+	}
+
+	h.lock.Lock()
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{}
+		h.histograms[key] = hist
+	}
+	hist.add(valueMetric.GetValue(), h.random)
+	h.lock.Unlock()
+
+	atomic.AddUint64(&h.aggregatedSampleCount, 1)
+	metrics.BatchIncrementCounter("histogramAggregator.aggregatedSamples")
+}
+
+// Flush writes the summaries of the values collected since the previous
+// flush and starts a new window.
+func (h *HistogramAggregator) Flush() {
+	h.lock.Lock()
+	histograms := h.histograms
+	h.histograms = make(map[histogramKey]*histogram)
+	h.lock.Unlock()
+
+	timestamp := time.Now().UnixNano()
+	for key, hist := range histograms {
+		h.writeSummary(key, hist, timestamp)
+		atomic.AddUint64(&h.summaryCount, 1)
+	}
+}
+
+func (h *HistogramAggregator) Stop() {
+	h.stopOnce.Do(func() { close(h.stopChan) })
+}
+
+func (h *HistogramAggregator) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "histogramAggregator",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "aggregatedSamples", Value: atomic.LoadUint64(&h.aggregatedSampleCount)},
+			instrumentation.Metric{Name: "summariesWritten", Value: atomic.LoadUint64(&h.summaryCount)},
+		},
+	}
+}
+
+func (h *HistogramAggregator) writeSummary(key histogramKey, hist *histogram, timestamp int64) {
+	values := hist.reservoir
+	sort.Float64s(values)
+
+	h.write(key, "count", float64(hist.count), "count", timestamp)
+	h.write(key, "min", hist.min, key.unit, timestamp)
+	h.write(key, "max", hist.max, key.unit, timestamp)
+	h.write(key, "mean", hist.sum/float64(hist.count), key.unit, timestamp)
+	for _, percentile := range h.percentiles {
+		h.write(key, "p"+strconv.FormatFloat(percentile, 'f', -1, 64), Percentile(values, percentile), key.unit, timestamp)
+	}
+}
+
+func (h *HistogramAggregator) write(key histogramKey, statistic string, value float64, unit string, timestamp int64) {
+	h.outputWriter.Write(&events.Envelope{
+		Origin:    proto.String(key.origin),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		Timestamp: proto.Int64(timestamp),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(key.name + "." + statistic),
+			Value: proto.Float64(value),
+			Unit:  proto.String(unit),
+		},
+	})
+}
+
+func (h *HistogramAggregator) runWindows(window time.Duration) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.Flush()
+		case <-h.stopChan:
+			return
+		}
+	}
+}
+
+// ValidatePercentiles checks that every percentile is in (0, 100].
+func ValidatePercentiles(percentiles []float64) error {
+	for _, percentile := range percentiles {
+		if percentile <= 0 || percentile > 100 {
+			return fmt.Errorf("percentile %v must be greater than 0 and at most 100", percentile)
+		}
+	}
+	return nil
+}
+
+// Percentile returns the smallest of the sorted values that is at least as
+// large as percentile percent of them, i.e. the nearest-rank percentile.
+func Percentile(sorted []float64, percentile float64) float64 {
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,13 @@
+package histogramaggregator_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHistogramAggregator(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HistogramAggregator Suite")
+}
@@ -0,0 +1,182 @@
+package histogramaggregator_test
+
+import (
+	"metron/writers/histogramaggregator"
+	"metron/writers/mocks"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HistogramAggregator", func() {
+	var (
+		mockWriter  *mocks.MockEnvelopeWriter
+		aggregator  *histogramaggregator.HistogramAggregator
+		percentiles []float64
+	)
+
+	BeforeEach(func() {
+		percentiles = nil
+	})
+
+	JustBeforeEach(func() {
+		mockWriter = &mocks.MockEnvelopeWriter{}
+
+		var err error
+		aggregator, err = histogramaggregator.New([]string{"latency"}, percentiles, 0, mockWriter, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		aggregator.Stop()
+	})
+
+	It("passes through envelopes other than the configured ValueMetrics", func() {
+		aggregator.Write(valueMetric("router", "cpu", 1))
+		aggregator.Write(&events.Envelope{
+			Origin:       proto.String("router"),
+			EventType:    events.Envelope_CounterEvent.Enum(),
+			CounterEvent: &events.CounterEvent{Name: proto.String("latency"), Delta: proto.Uint64(1)},
+		})
+
+		Expect(mockWriter.Events).To(HaveLen(2))
+	})
+
+	It("holds back the configured ValueMetrics until it is flushed", func() {
+		aggregator.Write(valueMetric("router", "latency", 1))
+		Expect(mockWriter.Events).To(BeEmpty())
+
+		aggregator.Flush()
+		Expect(mockWriter.Events).NotTo(BeEmpty())
+	})
+
+	It("summarises the values of a window", func() {
+		for i := 1; i <= 100; i++ {
+			aggregator.Write(valueMetric("router", "latency", float64(i)))
+		}
+
+		aggregator.Flush()
+
+		Expect(summary(mockWriter.Events)).To(Equal(map[string]float64{
+			"latency.count": 100,
+			"latency.min":   1,
+			"latency.max":   100,
+			"latency.mean":  50.5,
+			"latency.p50":   50,
+			"latency.p95":   95,
+			"latency.p99":   99,
+		}))
+		for _, envelope := range mockWriter.Events {
+			Expect(envelope.GetOrigin()).To(Equal("router"))
+			if envelope.GetValueMetric().GetName() != "latency.count" {
+				Expect(envelope.GetValueMetric().GetUnit()).To(Equal("ms"))
+			}
+		}
+	})
+
+	It("keeps a histogram per origin", func() {
+		aggregator.Write(valueMetric("router", "latency", 1))
+		aggregator.Write(valueMetric("other", "latency", 2))
+
+		aggregator.Flush()
+
+		Expect(mockWriter.Events).To(HaveLen(14))
+	})
+
+	It("starts a new window after a flush", func() {
+		aggregator.Write(valueMetric("router", "latency", 1))
+		aggregator.Flush()
+		mockWriter.Events = nil
+
+		aggregator.Flush()
+		Expect(mockWriter.Events).To(BeEmpty())
+	})
+
+	It("counts the samples it aggregated", func() {
+		aggregator.Write(valueMetric("router", "latency", 1))
+		aggregator.Write(valueMetric("router", "latency", 2))
+		aggregator.Flush()
+
+		metrics := aggregator.Emit().Metrics
+		Expect(metrics[0].Name).To(Equal("aggregatedSamples"))
+		Expect(metrics[0].Value).To(BeEquivalentTo(2))
+		Expect(metrics[1].Name).To(Equal("summariesWritten"))
+		Expect(metrics[1].Value).To(BeEquivalentTo(1))
+	})
+
+	Context("with more values in a window than it keeps", func() {
+		var originalReservoirSize int
+
+		BeforeEach(func() {
+			originalReservoirSize = histogramaggregator.ReservoirSize
+			histogramaggregator.ReservoirSize = 10
+		})
+
+		AfterEach(func() {
+			histogramaggregator.ReservoirSize = originalReservoirSize
+		})
+
+		It("keeps the count, min, max and mean exact and estimates the percentiles", func() {
+			for i := 1; i <= 1000; i++ {
+				aggregator.Write(valueMetric("router", "latency", float64(i)))
+			}
+
+			aggregator.Flush()
+
+			values := summary(mockWriter.Events)
+			Expect(values["latency.count"]).To(Equal(1000.0))
+			Expect(values["latency.min"]).To(Equal(1.0))
+			Expect(values["latency.max"]).To(Equal(1000.0))
+			Expect(values["latency.mean"]).To(Equal(500.5))
+			Expect(values["latency.p50"]).To(BeNumerically(">=", 1))
+			Expect(values["latency.p50"]).To(BeNumerically("<=", values["latency.p99"]))
+			Expect(values["latency.p99"]).To(BeNumerically("<=", 1000))
+		})
+	})
+
+	Context("with configured percentiles", func() {
+		BeforeEach(func() {
+			percentiles = []float64{99.9}
+		})
+
+		It("names them after the percentile", func() {
+			aggregator.Write(valueMetric("router", "latency", 3))
+			aggregator.Flush()
+
+			Expect(summary(mockWriter.Events)).To(HaveKeyWithValue("latency.p99.9", 3.0))
+			Expect(summary(mockWriter.Events)).NotTo(HaveKey("latency.p50"))
+		})
+	})
+
+	It("rejects percentiles outside of (0, 100]", func() {
+		_, err := histogramaggregator.New([]string{"latency"}, []float64{0}, 0, &mocks.MockEnvelopeWriter{}, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+
+		_, err = histogramaggregator.New([]string{"latency"}, []float64{101}, 0, &mocks.MockEnvelopeWriter{}, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func valueMetric(origin string, name string, value float64) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(origin),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(name),
+			Value: proto.Float64(value),
+			Unit:  proto.String("ms"),
+		},
+	}
+}
+
+func summary(envelopes []*events.Envelope) map[string]float64 {
+	values := make(map[string]float64)
+	for _, envelope := range envelopes {
+		values[envelope.GetValueMetric().GetName()] = envelope.GetValueMetric().GetValue()
+	}
+	return values
+}
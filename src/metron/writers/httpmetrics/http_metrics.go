@@ -0,0 +1,260 @@
+package httpmetrics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metron/writers"
+	"metron/writers/histogramaggregator"
+
+	"github.com/cloudfoundry/dropsonde/envelope_extensions"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+const metricsOrigin = "MetronAgent"
+
+var statusClasses = []string{"2xx", "3xx", "4xx", "5xx"}
+
+// IdleIntervals is how many intervals without requests an application and
+// peer type are remembered for. After that their totals are forgotten and
+// start again from zero if they serve requests later.
+var IdleIntervals = 10
+
+// HTTPMetrics derives request metrics per application from the HttpStartStop
+// events passing through it, so consumers don't have to compute them from the
+// firehose. Every interval it writes, for each application ID and peer type
+// that served requests, CounterEvents named http.requests and
+// http.responses.<2xx|3xx|4xx|5xx> and ValueMetrics named
+// http.latency.p<percentile> in milliseconds. They are tagged with app_id and
+// peer_type. Every envelope is passed on unchanged; events without an
+// application ID are not counted.
+type HTTPMetrics struct {
+	percentiles  []float64
+	outputWriter writers.EnvelopeWriter
+	logger       *gosteno.Logger
+
+	windows map[appKey]*window
+	totals  map[appKey]*totals
+	lock    sync.Mutex
+
+	observedRequestCount uint64
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type appKey struct {
+	appId    string
+	peerType events.PeerType
+}
+
+type window struct {
+	requests    uint64
+	statuses    map[string]uint64
+	latenciesMs []float64
+}
+
+type totals struct {
+	requests      uint64
+	statuses      map[string]uint64
+	idleIntervals int
+}
+
+func New(percentiles []float64, interval time.Duration, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) (*HTTPMetrics, error) {
+	if len(percentiles) == 0 {
+		percentiles = histogramaggregator.DefaultPercentiles
+	}
+	if err := histogramaggregator.ValidatePercentiles(percentiles); err != nil {
+		return nil, err
+	}
+
+	h := &HTTPMetrics{
+		percentiles:  percentiles,
+		outputWriter: outputWriter,
+		logger:       logger,
+		windows:      make(map[appKey]*window),
+		totals:       make(map[appKey]*totals),
+		stopChan:     make(chan struct{}),
+	}
+
+	if interval > 0 {
+		go h.run(interval)
+	}
+
+	return h, nil
+}
+
+func (h *HTTPMetrics) Write(envelope *events.Envelope) {
+	h.outputWriter.Write(envelope)
+
+	if envelope.GetEventType() != events.Envelope_HttpStartStop {
+		return
+	}
+	startStop := envelope.GetHttpStartStop()
+	if startStop.GetApplicationId() == nil {
+		return
+	}
+
+	key := appKey{appId: envelope_extensions.GetAppId(envelope), peerType: startStop.GetPeerType()}
+	latencyMs := float64(startStop.GetStopTimestamp()-startStop.GetStartTimestamp()) / float64(time.Millisecond)
+
+	h.lock.Lock()
+	w, ok := h.windows[key]
+	if !ok {
+		w = &window{statuses: make(map[string]uint64)}
+		h.windows[key] = w
+	}
+	w.requests++
+	if class, ok := statusClass(startStop.GetStatusCode()); ok {
+		w.statuses[class]++
+	}
+	w.latenciesMs = append(w.latenciesMs, latencyMs)
+	h.lock.Unlock()
+
+	atomic.AddUint64(&h.observedRequestCount, 1)
+}
+
+// Flush writes the metrics for the requests seen since the previous flush.
+func (h *HTTPMetrics) Flush() {
+	h.lock.Lock()
+	windows := h.windows
+	h.windows = make(map[appKey]*window)
+
+	envelopes := []*events.Envelope{}
+	timestamp := time.Now().UnixNano()
+	for key, w := range windows {
+		envelopes = append(envelopes, h.summarize(key, w, timestamp)...)
+	}
+	h.forgetIdle(windows)
+	h.lock.Unlock()
+
+	for _, envelope := range envelopes {
+		h.outputWriter.Write(envelope)
+	}
+}
+
+func (h *HTTPMetrics) Stop() {
+	h.stopOnce.Do(func() { close(h.stopChan) })
+}
+
+func (h *HTTPMetrics) Emit() instrumentation.Context {
+	h.lock.Lock()
+	trackedApps := len(h.totals)
+	h.lock.Unlock()
+
+	return instrumentation.Context{
+		Name: "httpMetrics",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "observedRequests", Value: atomic.LoadUint64(&h.observedRequestCount)},
+			instrumentation.Metric{Name: "trackedApps", Value: trackedApps},
+		},
+	}
+}
+
+// summarize must be called with the lock held, as it updates the totals.
+func (h *HTTPMetrics) summarize(key appKey, w *window, timestamp int64) []*events.Envelope {
+	t, ok := h.totals[key]
+	if !ok {
+		t = &totals{statuses: make(map[string]uint64)}
+		h.totals[key] = t
+	}
+
+	tags := map[string]string{
+		"app_id":    key.appId,
+		"peer_type": key.peerType.String(),
+	}
+
+	t.idleIntervals = 0
+	t.requests += w.requests
+	envelopes := []*events.Envelope{counterEvent("http.requests", w.requests, t.requests, tags, timestamp)}
+
+	for _, class := range statusClasses {
+		t.statuses[class] += w.statuses[class]
+		envelopes = append(envelopes, counterEvent("http.responses."+class, w.statuses[class], t.statuses[class], tags, timestamp))
+	}
+
+	sort.Float64s(w.latenciesMs)
+	for _, percentile := range h.percentiles {
+		name := "http.latency.p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+		envelopes = append(envelopes, valueMetric(name, histogramaggregator.Percentile(w.latenciesMs, percentile), tags, timestamp))
+	}
+
+	return envelopes
+}
+
+// forgetIdle must be called with the lock held. It drops the totals of the
+// apps that served no requests for IdleIntervals intervals.
+func (h *HTTPMetrics) forgetIdle(windows map[appKey]*window) {
+	for key, t := range h.totals {
+		if _, ok := windows[key]; ok {
+			continue
+		}
+
+		t.idleIntervals++
+		if t.idleIntervals >= IdleIntervals {
+			delete(h.totals, key)
+		}
+	}
+}
+
+func (h *HTTPMetrics) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.Flush()
+		case <-h.stopChan:
+			return
+		}
+	}
+}
+
+func statusClass(statusCode int32) (string, bool) {
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return "2xx", true
+	case statusCode >= 300 && statusCode < 400:
+		return "3xx", true
+	case statusCode >= 400 && statusCode < 500:
+		return "4xx", true
+	case statusCode >= 500 && statusCode < 600:
+		return "5xx", true
+	}
+	return "", false
+}
+
+func counterEvent(name string, delta uint64, total uint64, tags map[string]string, timestamp int64) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(metricsOrigin),
+		EventType: events.Envelope_CounterEvent.Enum(),
+		Timestamp: proto.Int64(timestamp),
+		CounterEvent: &events.CounterEvent{
+			Name:  proto.String(name),
+			Delta: proto.Uint64(delta),
+			Total: proto.Uint64(total),
+		},
+		Tags: tags,
+	}
+}
+
+func valueMetric(name string, value float64, tags map[string]string, timestamp int64) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(metricsOrigin),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		Timestamp: proto.Int64(timestamp),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(name),
+			Value: proto.Float64(value),
+			Unit:  proto.String("ms"),
+		},
+		Tags: tags,
+	}
+}
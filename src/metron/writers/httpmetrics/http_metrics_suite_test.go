@@ -0,0 +1,13 @@
+package httpmetrics_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HTTPMetrics Suite")
+}
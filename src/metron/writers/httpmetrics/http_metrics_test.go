@@ -0,0 +1,170 @@
+package httpmetrics_test
+
+import (
+	"time"
+
+	"metron/writers/httpmetrics"
+	"metron/writers/mocks"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPMetrics", func() {
+	var (
+		mockWriter  *mocks.MockEnvelopeWriter
+		httpMetrics *httpmetrics.HTTPMetrics
+		appId       *events.UUID
+	)
+
+	BeforeEach(func() {
+		mockWriter = &mocks.MockEnvelopeWriter{}
+		appId = &events.UUID{Low: proto.Uint64(1), High: proto.Uint64(2)}
+
+		var err error
+		httpMetrics, err = httpmetrics.New(nil, 0, mockWriter, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		httpMetrics.Stop()
+	})
+
+	It("passes every envelope on", func() {
+		httpMetrics.Write(startStop(appId, events.PeerType_Server, 200, time.Millisecond))
+		httpMetrics.Write(&events.Envelope{Origin: proto.String("origin"), EventType: events.Envelope_Error.Enum()})
+
+		Expect(mockWriter.Events).To(HaveLen(2))
+	})
+
+	It("writes request, status and latency metrics per app and peer type", func() {
+		for i := 1; i <= 10; i++ {
+			httpMetrics.Write(startStop(appId, events.PeerType_Server, 200, time.Duration(i)*time.Millisecond))
+		}
+		httpMetrics.Write(startStop(appId, events.PeerType_Server, 503, 100*time.Millisecond))
+		httpMetrics.Write(startStop(appId, events.PeerType_Client, 404, time.Millisecond))
+		mockWriter.Events = nil
+
+		httpMetrics.Flush()
+
+		server := derived(mockWriter.Events, "Server")
+		Expect(server["http.requests"].GetCounterEvent().GetDelta()).To(BeEquivalentTo(11))
+		Expect(server["http.responses.2xx"].GetCounterEvent().GetDelta()).To(BeEquivalentTo(10))
+		Expect(server["http.responses.3xx"].GetCounterEvent().GetDelta()).To(BeEquivalentTo(0))
+		Expect(server["http.responses.5xx"].GetCounterEvent().GetDelta()).To(BeEquivalentTo(1))
+		Expect(server["http.latency.p50"].GetValueMetric().GetValue()).To(Equal(6.0))
+		Expect(server["http.latency.p99"].GetValueMetric().GetValue()).To(Equal(100.0))
+		Expect(server["http.latency.p99"].GetValueMetric().GetUnit()).To(Equal("ms"))
+
+		client := derived(mockWriter.Events, "Client")
+		Expect(client["http.requests"].GetCounterEvent().GetDelta()).To(BeEquivalentTo(1))
+		Expect(client["http.responses.4xx"].GetCounterEvent().GetDelta()).To(BeEquivalentTo(1))
+
+		for _, envelope := range mockWriter.Events {
+			Expect(envelope.GetOrigin()).To(Equal("MetronAgent"))
+			Expect(envelope.GetTags()).To(HaveKeyWithValue("app_id", "01000000-0000-0000-0200-000000000000"))
+		}
+	})
+
+	It("keeps running totals across intervals", func() {
+		httpMetrics.Write(startStop(appId, events.PeerType_Server, 200, time.Millisecond))
+		httpMetrics.Flush()
+		httpMetrics.Write(startStop(appId, events.PeerType_Server, 200, time.Millisecond))
+		mockWriter.Events = nil
+
+		httpMetrics.Flush()
+
+		requests := derived(mockWriter.Events, "Server")["http.requests"].GetCounterEvent()
+		Expect(requests.GetDelta()).To(BeEquivalentTo(1))
+		Expect(requests.GetTotal()).To(BeEquivalentTo(2))
+	})
+
+	It("writes nothing for an app without requests in the interval", func() {
+		httpMetrics.Write(startStop(appId, events.PeerType_Server, 200, time.Millisecond))
+		httpMetrics.Flush()
+		mockWriter.Events = nil
+
+		httpMetrics.Flush()
+
+		Expect(mockWriter.Events).To(BeEmpty())
+	})
+
+	Context("when an app stops serving requests", func() {
+		var originalIdleIntervals int
+
+		BeforeEach(func() {
+			originalIdleIntervals = httpmetrics.IdleIntervals
+			httpmetrics.IdleIntervals = 2
+		})
+
+		AfterEach(func() {
+			httpmetrics.IdleIntervals = originalIdleIntervals
+		})
+
+		It("forgets its totals after IdleIntervals intervals", func() {
+			httpMetrics.Write(startStop(appId, events.PeerType_Server, 200, time.Millisecond))
+			httpMetrics.Flush()
+			testhelpers.EventuallyExpectMetric(httpMetrics, "trackedApps", 1)
+
+			httpMetrics.Flush()
+			testhelpers.EventuallyExpectMetric(httpMetrics, "trackedApps", 1)
+			httpMetrics.Flush()
+			testhelpers.EventuallyExpectMetric(httpMetrics, "trackedApps", 0)
+
+			httpMetrics.Write(startStop(appId, events.PeerType_Server, 200, time.Millisecond))
+			mockWriter.Events = nil
+			httpMetrics.Flush()
+
+			requests := derived(mockWriter.Events, "Server")["http.requests"].GetCounterEvent()
+			Expect(requests.GetTotal()).To(BeEquivalentTo(1))
+		})
+	})
+
+	It("ignores requests without an app", func() {
+		httpMetrics.Write(startStop(nil, events.PeerType_Server, 200, time.Millisecond))
+		mockWriter.Events = nil
+
+		httpMetrics.Flush()
+
+		Expect(mockWriter.Events).To(BeEmpty())
+	})
+
+	It("rejects invalid percentiles", func() {
+		_, err := httpmetrics.New([]float64{150}, 0, mockWriter, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func startStop(appId *events.UUID, peerType events.PeerType, statusCode int32, latency time.Duration) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String("router"),
+		EventType: events.Envelope_HttpStartStop.Enum(),
+		HttpStartStop: &events.HttpStartStop{
+			StartTimestamp: proto.Int64(1000),
+			StopTimestamp:  proto.Int64(1000 + latency.Nanoseconds()),
+			PeerType:       peerType.Enum(),
+			StatusCode:     proto.Int32(statusCode),
+			ApplicationId:  appId,
+		},
+	}
+}
+
+func derived(envelopes []*events.Envelope, peerType string) map[string]*events.Envelope {
+	byName := make(map[string]*events.Envelope)
+	for _, envelope := range envelopes {
+		if envelope.GetTags()["peer_type"] != peerType {
+			continue
+		}
+		name := envelope.GetCounterEvent().GetName()
+		if envelope.GetEventType() == events.Envelope_ValueMetric {
+			name = envelope.GetValueMetric().GetName()
+		}
+		byName[name] = envelope
+	}
+	return byName
+}
@@ -16,14 +16,36 @@ import (
 
 const legacyDropsondeOrigin = "legacy"
 
+// Config controls how much a LegacyUnmarshaller trusts incoming envelopes.
+// With a SharedSecret, envelopes must carry a valid signature. With
+// VerifyRoutingKey, their routing key must be the app ID of their message.
+// Origin is stamped on every converted envelope and defaults to "legacy".
+type Config struct {
+	Origin           string
+	SharedSecret     string
+	VerifyRoutingKey bool
+}
+
 type LegacyUnmarshaller struct {
-	unmarshalErrorCount uint64
-	outputWriter        writers.EnvelopeWriter
-	logger              *gosteno.Logger
+	unmarshalErrorCount     uint64
+	invalidSignatureCount   uint64
+	routingKeyMismatchCount uint64
+	config                  Config
+	outputWriter            writers.EnvelopeWriter
+	logger                  *gosteno.Logger
 }
 
 func New(outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) *LegacyUnmarshaller {
+	return NewWithConfig(Config{}, outputWriter, logger)
+}
+
+func NewWithConfig(config Config, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) *LegacyUnmarshaller {
+	if config.Origin == "" {
+		config.Origin = legacyDropsondeOrigin
+	}
+
 	return &LegacyUnmarshaller{
+		config:       config,
 		outputWriter: outputWriter,
 		logger:       logger,
 	}
@@ -35,10 +57,32 @@ func (u *LegacyUnmarshaller) Write(message []byte) {
 		return
 	}
 
-	dropsondeEnvelope := convertMessage(legacyEnvelope)
+	if !u.validate(legacyEnvelope) {
+		return
+	}
+
+	dropsondeEnvelope := convertMessage(legacyEnvelope, u.config.Origin)
 	u.outputWriter.Write(dropsondeEnvelope)
 }
 
+func (u *LegacyUnmarshaller) validate(envelope *logmessage.LogEnvelope) bool {
+	if u.config.SharedSecret != "" && !envelope.VerifySignature(u.config.SharedSecret) {
+		u.logger.Debugf("legacyUnmarshaller: invalid signature for app %s", envelope.GetLogMessage().GetAppId())
+		incrementCount(&u.invalidSignatureCount)
+		metrics.BatchIncrementCounter("legacyUnmarshaller.invalidSignatures")
+		return false
+	}
+
+	if u.config.VerifyRoutingKey && envelope.GetRoutingKey() != envelope.GetLogMessage().GetAppId() {
+		u.logger.Debugf("legacyUnmarshaller: routing key %s does not match app %s", envelope.GetRoutingKey(), envelope.GetLogMessage().GetAppId())
+		incrementCount(&u.routingKeyMismatchCount)
+		metrics.BatchIncrementCounter("legacyUnmarshaller.routingKeyMismatches")
+		return false
+	}
+
+	return true
+}
+
 func (u *LegacyUnmarshaller) unmarshalMessage(message []byte) (*logmessage.LogEnvelope, error) {
 	envelope := &logmessage.LogEnvelope{}
 	err := proto.Unmarshal(message, envelope)
@@ -54,10 +98,10 @@ func (u *LegacyUnmarshaller) unmarshalMessage(message []byte) (*logmessage.LogEn
 	return envelope, nil
 }
 
-func convertMessage(legacyEnvelope *logmessage.LogEnvelope) *events.Envelope {
+func convertMessage(legacyEnvelope *logmessage.LogEnvelope, origin string) *events.Envelope {
 	legacyMessage := legacyEnvelope.GetLogMessage()
 	return &events.Envelope{
-		Origin:    proto.String(legacyDropsondeOrigin),
+		Origin:    proto.String(origin),
 		EventType: events.Envelope_LogMessage.Enum(),
 		LogMessage: &events.LogMessage{
 			Message:        legacyMessage.Message,
@@ -89,5 +133,15 @@ func (u *LegacyUnmarshaller) metrics() []instrumentation.Metric {
 		Value: atomic.LoadUint64(&u.unmarshalErrorCount),
 	})
 
+	metrics = append(metrics, instrumentation.Metric{
+		Name:  "invalidSignatures",
+		Value: atomic.LoadUint64(&u.invalidSignatureCount),
+	})
+
+	metrics = append(metrics, instrumentation.Metric{
+		Name:  "routingKeyMismatches",
+		Value: atomic.LoadUint64(&u.routingKeyMismatchCount),
+	})
+
 	return metrics
 }
@@ -58,6 +58,58 @@ var _ = Describe("LegacyUnmarshaller", func() {
 		})
 	})
 
+	Context("with validation configured", func() {
+		var envelope *logmessage.LogEnvelope
+
+		BeforeEach(func() {
+			writer = mocks.MockEnvelopeWriter{}
+			config := legacyunmarshaller.Config{
+				Origin:           "legacy-dea",
+				SharedSecret:     "secret",
+				VerifyRoutingKey: true,
+			}
+			unmarshaller = legacyunmarshaller.NewWithConfig(config, &writer, loggertesthelper.Logger())
+
+			envelope = &logmessage.LogEnvelope{
+				RoutingKey: proto.String("fake-app-id"),
+				LogMessage: &logmessage.LogMessage{
+					Message:     []byte("message"),
+					MessageType: logmessage.LogMessage_OUT.Enum(),
+					Timestamp:   proto.Int64(123),
+					AppId:       proto.String("fake-app-id"),
+				},
+			}
+		})
+
+		It("accepts signed envelopes routed to their app and stamps the configured origin", func() {
+			envelope.SignEnvelope("secret")
+			message, _ := proto.Marshal(envelope)
+
+			unmarshaller.Write(message)
+			Expect(writer.Events).To(HaveLen(1))
+			Expect(writer.Events[0].GetOrigin()).To(Equal("legacy-dea"))
+		})
+
+		It("rejects envelopes with an invalid signature", func() {
+			envelope.SignEnvelope("other-secret")
+			message, _ := proto.Marshal(envelope)
+
+			unmarshaller.Write(message)
+			Expect(writer.Events).To(BeEmpty())
+			testhelpers.EventuallyExpectMetric(unmarshaller, "invalidSignatures", 1)
+		})
+
+		It("rejects envelopes whose routing key is not their app id", func() {
+			envelope.RoutingKey = proto.String("other-app-id")
+			envelope.SignEnvelope("secret")
+			message, _ := proto.Marshal(envelope)
+
+			unmarshaller.Write(message)
+			Expect(writer.Events).To(BeEmpty())
+			testhelpers.EventuallyExpectMetric(unmarshaller, "routingKeyMismatches", 1)
+		})
+	})
+
 	Context("metrics", func() {
 		BeforeEach(func() {
 			unmarshaller = legacyunmarshaller.New(&writer, loggertesthelper.Logger())
@@ -0,0 +1,44 @@
+package messageaggregator
+
+import "container/list"
+
+// counterCache keeps counter totals in least recently updated order so that
+// the oldest counter can be evicted once the cache is full.
+type counterCache struct {
+	entries map[counterID]*list.Element
+	order   *list.List
+}
+
+type counterTotal struct {
+	id    counterID
+	total uint64
+}
+
+func newCounterCache() *counterCache {
+	return &counterCache{
+		entries: make(map[counterID]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// add increases the total of the given counter by delta and returns the new
+// total. It reports whether another counter had to be evicted to make room.
+func (c *counterCache) add(id counterID, delta uint64, maxEntries int) (uint64, bool) {
+	if element, ok := c.entries[id]; ok {
+		c.order.MoveToFront(element)
+		entry := element.Value.(*counterTotal)
+		entry.total += delta
+		return entry.total, false
+	}
+
+	evicted := false
+	for maxEntries > 0 && c.order.Len() >= maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*counterTotal).id)
+		evicted = true
+	}
+
+	c.entries[id] = c.order.PushFront(&counterTotal{id: id, total: delta})
+	return delta, evicted
+}
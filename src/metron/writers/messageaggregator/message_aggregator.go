@@ -1,6 +1,9 @@
 package messageaggregator
 
 import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"metron/writers"
@@ -10,14 +13,24 @@ import (
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/davecgh/go-spew/spew"
-	"sync/atomic"
 )
 
 var MaxTTL = time.Minute
 
+// MaxCounters bounds the number of (origin, name) pairs whose totals are
+// tracked. When it is exceeded, the least recently updated counter is
+// forgotten and its total starts again from zero if it shows up later.
+var MaxCounters = 10000
+
+// A MessageAggregator combines HTTP start and stop events into HttpStartStop
+// events and keeps running totals for CounterEvents. It is safe for use by
+// multiple concurrent writers.
 type MessageAggregator struct {
-	startEventsByEventID            map[eventID]startEventEntry
-	counterTotals                   map[counterID]uint64
+	startEventsByEventID map[eventID]*startEventEntry
+	startEventQueue      startEventQueue
+	counterTotals        *counterCache
+	lock                 sync.Mutex
+
 	httpStartReceivedCount          uint64
 	httpStopReceivedCount           uint64
 	httpStartStopEmittedCount       uint64
@@ -25,6 +38,7 @@ type MessageAggregator struct {
 	httpUnmatchedStartReceivedCount uint64
 	httpUnmatchedStopReceivedCount  uint64
 	counterEventReceivedCount       uint64
+	counterEvictedCount             uint64
 
 	logger       *gosteno.Logger
 	outputWriter writers.EnvelopeWriter
@@ -34,15 +48,12 @@ func New(outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) *MessageAg
 	return &MessageAggregator{
 		logger:               logger,
 		outputWriter:         outputWriter,
-		startEventsByEventID: make(map[eventID]startEventEntry),
-		counterTotals:        make(map[counterID]uint64),
+		startEventsByEventID: make(map[eventID]*startEventEntry),
+		counterTotals:        newCounterCache(),
 	}
 }
 
 func (m *MessageAggregator) Write(envelope *events.Envelope) {
-	// TODO: don't call for every message if throughput becomes a problem
-	m.cleanupOrphanedHTTPStart()
-
 	if envelope.EventType == nil {
 		m.outputWriter.Write(envelope)
 		return
@@ -82,7 +93,20 @@ func (m *MessageAggregator) handleHTTPStart(envelope *events.Envelope) {
 
 	requestID := startEvent.RequestId.String()
 	event := eventID{requestID: requestID, peerType: startEvent.GetPeerType()}
-	m.startEventsByEventID[event] = startEventEntry{startEvent: startEvent, entryTime: time.Now()}
+	now := time.Now()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.expireOrphanedHTTPStart(now)
+
+	if existing, ok := m.startEventsByEventID[event]; ok {
+		heap.Remove(&m.startEventQueue, existing.index)
+	}
+
+	entry := &startEventEntry{eventID: event, startEvent: startEvent, entryTime: now}
+	m.startEventsByEventID[event] = entry
+	heap.Push(&m.startEventQueue, entry)
 }
 
 func (m *MessageAggregator) handleHTTPStop(envelope *events.Envelope) *events.Envelope {
@@ -95,7 +119,15 @@ func (m *MessageAggregator) handleHTTPStop(envelope *events.Envelope) *events.En
 	requestID := stopEvent.RequestId.String()
 	event := eventID{requestID: requestID, peerType: stopEvent.GetPeerType()}
 
+	m.lock.Lock()
+	m.expireOrphanedHTTPStart(time.Now())
 	startEventEntry, ok := m.startEventsByEventID[event]
+	if ok {
+		delete(m.startEventsByEventID, event)
+		heap.Remove(&m.startEventQueue, startEventEntry.index)
+	}
+	m.lock.Unlock()
+
 	if !ok {
 		m.logger.Warnf("no matching HTTP start message found for %v", event)
 		metrics.BatchIncrementCounter("MessageAggregator.httpUnmatchedStopReceived")
@@ -106,7 +138,6 @@ func (m *MessageAggregator) handleHTTPStop(envelope *events.Envelope) *events.En
 	metrics.BatchIncrementCounter("MessageAggregator.httpStartStopEmitted")
 	atomic.AddUint64(&m.httpStartStopEmittedCount, 1)
 
-	delete(m.startEventsByEventID, event)
 	startEvent := startEventEntry.startEvent
 
 	return &events.Envelope{
@@ -141,21 +172,33 @@ func (m *MessageAggregator) handleCounter(envelope *events.Envelope) *events.Env
 		origin: envelope.GetOrigin(),
 	}
 
-	newVal := m.counterTotals[countID] + envelope.GetCounterEvent().GetDelta()
-	m.counterTotals[countID] = newVal
+	m.lock.Lock()
+	newVal, evicted := m.counterTotals.add(countID, envelope.GetCounterEvent().GetDelta(), MaxCounters)
+	m.lock.Unlock()
+
+	if evicted {
+		metrics.BatchIncrementCounter("MessageAggregator.counterEvicted")
+		atomic.AddUint64(&m.counterEvictedCount, 1)
+	}
 
 	envelope.GetCounterEvent().Total = &newVal
 	return envelope
 }
 
-func (m *MessageAggregator) cleanupOrphanedHTTPStart() {
-	currentTime := time.Now()
-	for key, eventEntry := range m.startEventsByEventID {
-		if currentTime.Sub(eventEntry.entryTime) > MaxTTL {
-			metrics.BatchIncrementCounter("MessageAggregator.httpUnmatchedStartReceived")
-			atomic.AddUint64(&m.httpUnmatchedStartReceivedCount, 1)
-			delete(m.startEventsByEventID, key)
+// expireOrphanedHTTPStart drops start events that have waited longer than
+// MaxTTL for their stop event. The queue is ordered by arrival, so only the
+// expired entries are visited. Callers must hold m.lock.
+func (m *MessageAggregator) expireOrphanedHTTPStart(currentTime time.Time) {
+	for m.startEventQueue.Len() > 0 {
+		oldest := m.startEventQueue[0]
+		if currentTime.Sub(oldest.entryTime) <= MaxTTL {
+			return
 		}
+
+		heap.Pop(&m.startEventQueue)
+		delete(m.startEventsByEventID, oldest.eventID)
+		metrics.BatchIncrementCounter("MessageAggregator.httpUnmatchedStartReceived")
+		atomic.AddUint64(&m.httpUnmatchedStartReceivedCount, 1)
 	}
 }
 
@@ -168,6 +211,7 @@ func (m *MessageAggregator) metrics() []instrumentation.Metric {
 		instrumentation.Metric{Name: "httpUnmatchedStartReceived", Value: atomic.LoadUint64(&m.httpUnmatchedStartReceivedCount)},
 		instrumentation.Metric{Name: "httpUnmatchedStopReceived", Value: atomic.LoadUint64(&m.httpUnmatchedStopReceivedCount)},
 		instrumentation.Metric{Name: "counterEventReceived", Value: atomic.LoadUint64(&m.counterEventReceivedCount)},
+		instrumentation.Metric{Name: "counterEvicted", Value: atomic.LoadUint64(&m.counterEvictedCount)},
 	}
 }
 
@@ -182,6 +226,8 @@ type eventID struct {
 }
 
 type startEventEntry struct {
+	eventID    eventID
 	startEvent *events.HttpStart
 	entryTime  time.Time
+	index      int
 }
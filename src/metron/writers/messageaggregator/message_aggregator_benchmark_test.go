@@ -0,0 +1,64 @@
+package messageaggregator_test
+
+import (
+	"testing"
+
+	"metron/writers/messageaggregator"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func BenchmarkHTTPStartStopWith100InFlight(b *testing.B) {
+	benchmarkHTTPStartStop(b, 100)
+}
+
+func BenchmarkHTTPStartStopWith10000InFlight(b *testing.B) {
+	benchmarkHTTPStartStop(b, 10000)
+}
+
+func BenchmarkHTTPStartStopWith100000InFlight(b *testing.B) {
+	benchmarkHTTPStartStop(b, 100000)
+}
+
+func BenchmarkCounterEventWith10000Counters(b *testing.B) {
+	aggregator := messageaggregator.New(nullWriter{}, loggertesthelper.Logger())
+
+	names := make([]string, 10000)
+	for i := range names {
+		names[i] = string(rune('a'+i%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i/676))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aggregator.Write(createCounterMessage(names[i%len(names)], "fake-origin-1"))
+	}
+}
+
+// benchmarkHTTPStartStop measures a start/stop pair while inFlight other
+// requests are still waiting for their stop event. The cost per pair should
+// not depend on inFlight.
+func benchmarkHTTPStartStop(b *testing.B, inFlight int) {
+	aggregator := messageaggregator.New(nullWriter{}, loggertesthelper.Logger())
+	for i := 0; i < inFlight; i++ {
+		aggregator.Write(createStartMessage(uint64(i), events.PeerType_Client))
+	}
+
+	starts := make([]*events.Envelope, b.N)
+	stops := make([]*events.Envelope, b.N)
+	for i := 0; i < b.N; i++ {
+		id := uint64(inFlight + i)
+		starts[i] = createStartMessage(id, events.PeerType_Server)
+		stops[i] = createStopMessage(id, events.PeerType_Server)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aggregator.Write(starts[i])
+		aggregator.Write(stops[i])
+	}
+}
+
+type nullWriter struct{}
+
+func (nullWriter) Write(*events.Envelope) {}
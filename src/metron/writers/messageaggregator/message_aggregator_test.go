@@ -3,6 +3,7 @@ package messageaggregator_test
 import (
 	"fmt"
 	"metron/writers/messageaggregator"
+	"sync"
 	"time"
 
 	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
@@ -20,16 +21,19 @@ var _ = Describe("MessageAggregator", func() {
 		mockWriter        *mocks.MockEnvelopeWriter
 		messageAggregator *messageaggregator.MessageAggregator
 		originalTTL       time.Duration
+		originalCounters  int
 	)
 
 	BeforeEach(func() {
 		mockWriter = &mocks.MockEnvelopeWriter{}
 		messageAggregator = messageaggregator.New(mockWriter, loggertesthelper.Logger())
 		originalTTL = messageaggregator.MaxTTL
+		originalCounters = messageaggregator.MaxCounters
 	})
 
 	AfterEach(func() {
 		messageaggregator.MaxTTL = originalTTL
+		messageaggregator.MaxCounters = originalCounters
 	})
 
 	It("passes non-marshallable messages through", func() {
@@ -105,6 +109,26 @@ var _ = Describe("MessageAggregator", func() {
 		})
 	})
 
+	Describe("counter eviction", func() {
+		BeforeEach(func() {
+			messageaggregator.MaxCounters = 2
+		})
+
+		It("forgets the least recently updated counter when full", func() {
+			messageAggregator.Write(createCounterMessage("counter1", "fake-origin-1"))
+			messageAggregator.Write(createCounterMessage("counter2", "fake-origin-1"))
+			messageAggregator.Write(createCounterMessage("counter1", "fake-origin-1"))
+			messageAggregator.Write(createCounterMessage("counter3", "fake-origin-1"))
+
+			messageAggregator.Write(createCounterMessage("counter1", "fake-origin-1"))
+			messageAggregator.Write(createCounterMessage("counter2", "fake-origin-1"))
+
+			Expect(mockWriter.Events).To(HaveLen(6))
+			expectCorrectCounterNameDeltaAndTotal(mockWriter.Events[4], "counter1", 4, 12)
+			expectCorrectCounterNameDeltaAndTotal(mockWriter.Events[5], "counter2", 4, 4)
+		})
+	})
+
 	Context("single StartStop message", func() {
 		var outputMessage *events.Envelope
 		BeforeEach(func() {
@@ -165,6 +189,32 @@ var _ = Describe("MessageAggregator", func() {
 		})
 	})
 
+	Context("concurrent writers", func() {
+		It("combines every start and stop event", func() {
+			writer := &countingWriter{}
+			messageAggregator = messageaggregator.New(writer, loggertesthelper.Logger())
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(offset uint64) {
+					defer wg.Done()
+					for j := uint64(0); j < 100; j++ {
+						id := offset*1000 + j*2
+						messageAggregator.Write(createStartMessage(id, events.PeerType_Client))
+						messageAggregator.Write(createStopMessage(id, events.PeerType_Client))
+						messageAggregator.Write(createCounterMessage("counter", "fake-origin-1"))
+					}
+				}(uint64(i))
+			}
+			wg.Wait()
+
+			Expect(writer.count(events.Envelope_HttpStartStop)).To(Equal(1000))
+			Expect(writer.count(events.Envelope_CounterEvent)).To(Equal(1000))
+			Expect(writer.maxCounterTotal()).To(BeEquivalentTo(4000))
+		})
+	})
+
 	var metricValue = func(name string) interface{} {
 		for _, metric := range messageAggregator.Emit().Metrics {
 			if metric.Name == name {
@@ -226,6 +276,36 @@ var _ = Describe("MessageAggregator", func() {
 	})
 })
 
+type countingWriter struct {
+	counts   map[events.Envelope_EventType]int
+	maxTotal uint64
+	sync.Mutex
+}
+
+func (w *countingWriter) Write(envelope *events.Envelope) {
+	w.Lock()
+	defer w.Unlock()
+	if w.counts == nil {
+		w.counts = make(map[events.Envelope_EventType]int)
+	}
+	w.counts[envelope.GetEventType()]++
+	if total := envelope.GetCounterEvent().GetTotal(); total > w.maxTotal {
+		w.maxTotal = total
+	}
+}
+
+func (w *countingWriter) count(eventType events.Envelope_EventType) int {
+	w.Lock()
+	defer w.Unlock()
+	return w.counts[eventType]
+}
+
+func (w *countingWriter) maxCounterTotal() uint64 {
+	w.Lock()
+	defer w.Unlock()
+	return w.maxTotal
+}
+
 func createStartMessage(requestId uint64, peerType events.PeerType) *events.Envelope {
 	return &events.Envelope{
 		Origin:    proto.String("fake-origin-1"),
@@ -0,0 +1,34 @@
+package messageaggregator
+
+// startEventQueue is a min-heap of pending start events ordered by arrival
+// time. Each entry tracks its position so that a matched start event can be
+// removed in O(log n).
+type startEventQueue []*startEventEntry
+
+func (q startEventQueue) Len() int { return len(q) }
+
+func (q startEventQueue) Less(i, j int) bool {
+	return q[i].entryTime.Before(q[j].entryTime)
+}
+
+func (q startEventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *startEventQueue) Push(x interface{}) {
+	entry := x.(*startEventEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *startEventQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*q = old[:n-1]
+	return entry
+}
@@ -0,0 +1,287 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"metron/writers"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	summaryOrigin  = "MetronAgent"
+	overflowOrigin = "overflow"
+)
+
+// MaxOrigins bounds how many buckets and per-origin drop counts a RateLimiter
+// keeps, as origins are chosen by the local emitters. Buckets that have
+// refilled are forgotten with every summary, and only then, so that writing
+// an envelope never scans them. Once the bound is reached, further origins
+// share one bucket per limit and their drops are counted under the origin
+// "overflow".
+var MaxOrigins = 10000
+
+// A Limit caps how many envelopes per second each origin may send. Origin and
+// EventType narrow down which envelopes the limit applies to; left empty they
+// match anything. Every origin gets its own bucket, so a limit without an
+// Origin is a default quota for every origin rather than a shared one.
+type Limit struct {
+	Origin            string
+	EventType         string
+	MessagesPerSecond float64
+	Burst             int
+}
+
+func (l Limit) String() string {
+	origin := l.Origin
+	if origin == "" {
+		origin = "*"
+	}
+	eventType := l.EventType
+	if eventType == "" {
+		eventType = "*"
+	}
+	return fmt.Sprintf("origin=%s,eventType=%s", origin, eventType)
+}
+
+func (l Limit) matches(origin string, eventType string) bool {
+	return (l.Origin == "" || l.Origin == origin) && (l.EventType == "" || l.EventType == eventType)
+}
+
+// specificity ranks limits so that the most specific one wins: origin and
+// event type, then origin only, then event type only, then the default.
+func (l Limit) specificity() int {
+	specificity := 0
+	if l.Origin != "" {
+		specificity += 2
+	}
+	if l.EventType != "" {
+		specificity++
+	}
+	return specificity
+}
+
+// A RateLimiter applies token-bucket limits to envelopes before they reach
+// the rest of the writer chain. Envelopes over their limit are dropped and
+// counted per origin. Every summary interval it writes a CounterEvent for
+// each origin and limit that caused drops, tagged with what was dropped and
+// why.
+type RateLimiter struct {
+	limits       []Limit
+	outputWriter writers.EnvelopeWriter
+	logger       *gosteno.Logger
+
+	buckets         map[bucketID]*tokenBucket
+	droppedByOrigin map[string]uint64
+	pendingSummary  map[dropReason]uint64
+	lock            sync.Mutex
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type bucketID struct {
+	origin    string
+	eventType string
+	limit     int
+}
+
+type dropReason struct {
+	origin    string
+	eventType string
+	limit     string
+}
+
+func New(limits []Limit, summaryInterval time.Duration, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) (*RateLimiter, error) {
+	for _, limit := range limits {
+		if limit.MessagesPerSecond <= 0 {
+			return nil, fmt.Errorf("rate limit %s must allow a positive number of messages per second", limit)
+		}
+		if limit.EventType != "" {
+			if _, ok := events.Envelope_EventType_value[limit.EventType]; !ok {
+				return nil, fmt.Errorf("rate limit %s has unknown event type", limit)
+			}
+		}
+	}
+
+	sorted := make([]Limit, len(limits))
+	copy(sorted, limits)
+	sort.Stable(bySpecificity(sorted))
+
+	r := &RateLimiter{
+		limits:          sorted,
+		outputWriter:    outputWriter,
+		logger:          logger,
+		buckets:         make(map[bucketID]*tokenBucket),
+		droppedByOrigin: make(map[string]uint64),
+		pendingSummary:  make(map[dropReason]uint64),
+		stopChan:        make(chan struct{}),
+	}
+
+	if summaryInterval > 0 {
+		go r.runSummaries(summaryInterval)
+	}
+
+	return r, nil
+}
+
+func (r *RateLimiter) Write(envelope *events.Envelope) {
+	origin := envelope.GetOrigin()
+	eventType := envelope.GetEventType().String()
+
+	if r.admit(origin, eventType) {
+		r.outputWriter.Write(envelope)
+	}
+}
+
+// WriteSummary writes the drops since the previous summary as CounterEvents
+// and forgets the buckets that have refilled. The events are named
+// rateLimiter.droppedMessagesSummary so that they aren't counted together
+// with the rateLimiter.droppedMessages counter Metron already emits.
+func (r *RateLimiter) WriteSummary() {
+	r.lock.Lock()
+	pending := r.pendingSummary
+	r.pendingSummary = make(map[dropReason]uint64)
+	r.forgetIdleBuckets(time.Now())
+	r.lock.Unlock()
+
+	for reason, count := range pending {
+		r.logger.Infof("RateLimiter: dropped %d %s envelopes from %s due to limit %s", count, reason.eventType, reason.origin, reason.limit)
+		r.outputWriter.Write(&events.Envelope{
+			Origin:    proto.String(summaryOrigin),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			Timestamp: proto.Int64(time.Now().UnixNano()),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String("rateLimiter.droppedMessagesSummary"),
+				Delta: proto.Uint64(count),
+			},
+			Tags: map[string]string{
+				"droppedOrigin":    reason.origin,
+				"droppedEventType": reason.eventType,
+				"limit":            reason.limit,
+			},
+		})
+	}
+}
+
+func (r *RateLimiter) Stop() {
+	r.stopOnce.Do(func() { close(r.stopChan) })
+}
+
+// DroppedMessages returns the number of envelopes dropped for every origin.
+func (r *RateLimiter) DroppedMessages() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var total uint64
+	for _, count := range r.droppedByOrigin {
+		total += count
+	}
+	return total
+}
+
+func (r *RateLimiter) Emit() instrumentation.Context {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	metrics := []instrumentation.Metric{}
+	for origin, count := range r.droppedByOrigin {
+		metrics = append(metrics, instrumentation.Metric{
+			Name:  fmt.Sprintf("%s.droppedMessages", origin),
+			Value: count,
+			Tags:  map[string]interface{}{"origin": origin},
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "rateLimiter",
+		Metrics: metrics,
+	}
+}
+
+func (r *RateLimiter) admit(origin string, eventType string) bool {
+	index := r.matchingLimit(origin, eventType)
+	if index < 0 {
+		return true
+	}
+	limit := r.limits[index]
+
+	id := bucketID{origin: origin, limit: index}
+	if limit.EventType != "" {
+		id.eventType = eventType
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	bucket, ok := r.buckets[id]
+	if !ok {
+		if len(r.buckets) >= MaxOrigins {
+			id.origin = overflowOrigin
+			bucket = r.buckets[id]
+		}
+		if bucket == nil {
+			bucket = newTokenBucket(limit.MessagesPerSecond, limit.Burst)
+			r.buckets[id] = bucket
+		}
+	}
+
+	if bucket.take(now) {
+		return true
+	}
+
+	if _, ok := r.droppedByOrigin[origin]; !ok && len(r.droppedByOrigin) >= MaxOrigins {
+		origin = overflowOrigin
+	}
+	r.droppedByOrigin[origin]++
+	r.pendingSummary[dropReason{origin: origin, eventType: eventType, limit: limit.String()}]++
+	metrics.BatchIncrementCounter("rateLimiter.droppedMessages")
+	return false
+}
+
+// forgetIdleBuckets must be called with the lock held. A bucket that has
+// refilled is no different from a new one, so it can be dropped.
+func (r *RateLimiter) forgetIdleBuckets(now time.Time) {
+	for id, bucket := range r.buckets {
+		if bucket.full(now) {
+			delete(r.buckets, id)
+		}
+	}
+}
+
+func (r *RateLimiter) matchingLimit(origin string, eventType string) int {
+	for i, limit := range r.limits {
+		if limit.matches(origin, eventType) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *RateLimiter) runSummaries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.WriteSummary()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+type bySpecificity []Limit
+
+func (b bySpecificity) Len() int           { return len(b) }
+func (b bySpecificity) Less(i, j int) bool { return b[i].specificity() > b[j].specificity() }
+func (b bySpecificity) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,13 @@
+package ratelimiter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RateLimiter Suite")
+}
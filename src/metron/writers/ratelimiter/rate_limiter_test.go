@@ -0,0 +1,236 @@
+package ratelimiter_test
+
+import (
+	"time"
+
+	"metron/writers/mocks"
+	"metron/writers/ratelimiter"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var (
+		mockWriter *mocks.MockEnvelopeWriter
+		limiter    *ratelimiter.RateLimiter
+		limits     []ratelimiter.Limit
+	)
+
+	JustBeforeEach(func() {
+		mockWriter = &mocks.MockEnvelopeWriter{}
+
+		var err error
+		limiter, err = ratelimiter.New(limits, 0, mockWriter, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		limiter.Stop()
+	})
+
+	Context("without limits", func() {
+		BeforeEach(func() {
+			limits = nil
+		})
+
+		It("passes every envelope through", func() {
+			for i := 0; i < 100; i++ {
+				limiter.Write(logMessage("origin-a"))
+			}
+			Expect(mockWriter.Events).To(HaveLen(100))
+		})
+	})
+
+	Context("with a default limit", func() {
+		BeforeEach(func() {
+			limits = []ratelimiter.Limit{
+				{MessagesPerSecond: 0.001, Burst: 2},
+			}
+		})
+
+		It("gives every origin its own bucket", func() {
+			for i := 0; i < 5; i++ {
+				limiter.Write(logMessage("origin-a"))
+				limiter.Write(logMessage("origin-b"))
+			}
+
+			Expect(mockWriter.Events).To(HaveLen(4))
+		})
+
+		It("counts drops per origin", func() {
+			for i := 0; i < 5; i++ {
+				limiter.Write(logMessage("origin-a"))
+			}
+
+			metrics := limiter.Emit().Metrics
+			Expect(metrics).To(HaveLen(1))
+			Expect(metrics[0].Name).To(Equal("origin-a.droppedMessages"))
+			Expect(metrics[0].Value).To(BeEquivalentTo(3))
+			Expect(limiter.DroppedMessages()).To(BeEquivalentTo(3))
+		})
+
+		It("writes a summary of the drops as a CounterEvent", func() {
+			for i := 0; i < 5; i++ {
+				limiter.Write(logMessage("origin-a"))
+			}
+
+			limiter.WriteSummary()
+
+			Expect(mockWriter.Events).To(HaveLen(3))
+			summary := mockWriter.Events[2]
+			Expect(summary.GetOrigin()).To(Equal("MetronAgent"))
+			Expect(summary.GetCounterEvent().GetName()).To(Equal("rateLimiter.droppedMessagesSummary"))
+			Expect(summary.GetCounterEvent().GetDelta()).To(BeEquivalentTo(3))
+			Expect(summary.GetTags()).To(Equal(map[string]string{
+				"droppedOrigin":    "origin-a",
+				"droppedEventType": "LogMessage",
+				"limit":            "origin=*,eventType=*",
+			}))
+
+			limiter.WriteSummary()
+			Expect(mockWriter.Events).To(HaveLen(3))
+		})
+	})
+
+	Context("with more origins than it keeps track of", func() {
+		var originalMaxOrigins int
+
+		BeforeEach(func() {
+			originalMaxOrigins = ratelimiter.MaxOrigins
+			ratelimiter.MaxOrigins = 2
+			limits = []ratelimiter.Limit{
+				{MessagesPerSecond: 0.001, Burst: 1},
+			}
+		})
+
+		AfterEach(func() {
+			ratelimiter.MaxOrigins = originalMaxOrigins
+		})
+
+		It("makes further origins share a bucket and counts their drops together", func() {
+			for _, origin := range []string{"origin-a", "origin-b", "origin-c", "origin-d", "origin-e"} {
+				limiter.Write(logMessage(origin))
+				limiter.Write(logMessage(origin))
+			}
+
+			Expect(mockWriter.Events).To(HaveLen(3))
+
+			names := []string{}
+			for _, metric := range limiter.Emit().Metrics {
+				names = append(names, metric.Name)
+			}
+			Expect(names).To(ConsistOf("origin-a.droppedMessages", "origin-b.droppedMessages", "overflow.droppedMessages"))
+			Expect(limiter.DroppedMessages()).To(BeEquivalentTo(7))
+		})
+	})
+
+	Context("with origins that stop sending", func() {
+		var originalMaxOrigins int
+
+		BeforeEach(func() {
+			originalMaxOrigins = ratelimiter.MaxOrigins
+			ratelimiter.MaxOrigins = 1
+			limits = []ratelimiter.Limit{
+				{MessagesPerSecond: 1000, Burst: 1},
+			}
+		})
+
+		AfterEach(func() {
+			ratelimiter.MaxOrigins = originalMaxOrigins
+		})
+
+		It("makes new origins share a bucket until a summary forgets refilled buckets", func() {
+			limiter.Write(logMessage("origin-a"))
+			time.Sleep(10 * time.Millisecond)
+
+			limiter.Write(logMessage("origin-b"))
+			limiter.Write(logMessage("origin-c"))
+
+			Expect(mockWriter.Events).To(HaveLen(2))
+		})
+
+		It("forgets their buckets once they have refilled with every summary", func() {
+			limiter.Write(logMessage("origin-a"))
+			time.Sleep(10 * time.Millisecond)
+			limiter.WriteSummary()
+
+			limiter.Write(logMessage("origin-b"))
+			limiter.Write(logMessage("origin-c"))
+
+			Expect(mockWriter.Events).To(HaveLen(3))
+		})
+	})
+
+	Context("with origin and event type limits", func() {
+		BeforeEach(func() {
+			limits = []ratelimiter.Limit{
+				{MessagesPerSecond: 0.001, Burst: 1},
+				{Origin: "router", MessagesPerSecond: 1000, Burst: 1000},
+				{Origin: "router", EventType: "LogMessage", MessagesPerSecond: 0.001, Burst: 2},
+			}
+		})
+
+		It("applies the most specific matching limit", func() {
+			for i := 0; i < 5; i++ {
+				limiter.Write(logMessage("router"))
+				limiter.Write(valueMetric("router"))
+				limiter.Write(valueMetric("other"))
+			}
+
+			var logs, routerMetrics, otherMetrics int
+			for _, envelope := range mockWriter.Events {
+				switch {
+				case envelope.GetEventType() == events.Envelope_LogMessage:
+					logs++
+				case envelope.GetOrigin() == "router":
+					routerMetrics++
+				default:
+					otherMetrics++
+				}
+			}
+
+			Expect(logs).To(Equal(2))
+			Expect(routerMetrics).To(Equal(5))
+			Expect(otherMetrics).To(Equal(1))
+		})
+	})
+
+	It("rejects limits with an unknown event type", func() {
+		_, err := ratelimiter.New([]ratelimiter.Limit{{EventType: "Bogus", MessagesPerSecond: 1}}, 0, &mocks.MockEnvelopeWriter{}, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects limits without a positive rate", func() {
+		_, err := ratelimiter.New([]ratelimiter.Limit{{Origin: "router"}}, 0, &mocks.MockEnvelopeWriter{}, loggertesthelper.Logger())
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func logMessage(origin string) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(origin),
+		EventType: events.Envelope_LogMessage.Enum(),
+		LogMessage: &events.LogMessage{
+			Message:     []byte("hello"),
+			MessageType: events.LogMessage_OUT.Enum(),
+			Timestamp:   proto.Int64(1),
+		},
+	}
+}
+
+func valueMetric(origin string) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(origin),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String("metric"),
+			Value: proto.Float64(1),
+			Unit:  proto.String("unit"),
+		},
+	}
+}
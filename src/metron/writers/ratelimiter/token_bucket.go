@@ -0,0 +1,49 @@
+package ratelimiter
+
+import "time"
+
+type tokenBucket struct {
+	rate       float64
+	capacity   float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+// newTokenBucket returns a full bucket. A burst below one still lets a
+// single message through at a time.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	capacity := float64(burst)
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &tokenBucket{
+		rate:       rate,
+		capacity:   capacity,
+		tokens:     capacity,
+		lastRefill: time.Now(),
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	if elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.lastRefill = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket will have refilled completely by now.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.lastRefill).Seconds()*b.rate >= b.capacity
+}
@@ -0,0 +1,216 @@
+package statsdunmarshaller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metron/writers"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+// MaxGauges bounds how many gauges a StatsdUnmarshaller remembers for
+// relative updates. When the bound is reached, gauges not set for GaugeTTL
+// are forgotten first; if that frees no room, the new gauge isn't
+// remembered, and a relative update to it starts from 0 every time.
+var (
+	MaxGauges = 10000
+	GaugeTTL  = 10 * time.Minute
+)
+
+// A StatsdUnmarshaller translates StatsD datagrams into dropsonde envelopes.
+// Counters become CounterEvents, scaled up by their sample rate; gauges and
+// timers become ValueMetrics with the units "gauge" and "ms". Gauges with a
+// leading sign are applied to the last value seen for that name.
+type StatsdUnmarshaller struct {
+	origin       string
+	outputWriter writers.EnvelopeWriter
+	logger       *gosteno.Logger
+
+	gauges map[string]*gauge
+	lock   sync.Mutex
+
+	receivedMetricCount uint64
+	parseErrorCount     uint64
+}
+
+func New(origin string, outputWriter writers.EnvelopeWriter, logger *gosteno.Logger) *StatsdUnmarshaller {
+	return &StatsdUnmarshaller{
+		origin:       origin,
+		outputWriter: outputWriter,
+		logger:       logger,
+		gauges:       make(map[string]*gauge),
+	}
+}
+
+func (u *StatsdUnmarshaller) Write(message []byte) {
+	for _, line := range strings.Split(string(message), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		envelope, err := u.parseLine(line)
+		if err != nil {
+			u.logger.Debugf("statsdUnmarshaller: unable to parse %q: %v", line, err)
+			atomic.AddUint64(&u.parseErrorCount, 1)
+			metrics.BatchIncrementCounter("statsdUnmarshaller.parseErrors")
+			continue
+		}
+
+		atomic.AddUint64(&u.receivedMetricCount, 1)
+		metrics.BatchIncrementCounter("statsdUnmarshaller.receivedMetrics")
+		u.outputWriter.Write(envelope)
+	}
+}
+
+func (u *StatsdUnmarshaller) Emit() instrumentation.Context {
+	u.lock.Lock()
+	trackedGauges := len(u.gauges)
+	u.lock.Unlock()
+
+	return instrumentation.Context{
+		Name: "statsdUnmarshaller",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "receivedMetrics", Value: atomic.LoadUint64(&u.receivedMetricCount)},
+			instrumentation.Metric{Name: "parseErrors", Value: atomic.LoadUint64(&u.parseErrorCount)},
+			instrumentation.Metric{Name: "trackedGauges", Value: trackedGauges},
+		},
+	}
+}
+
+// parseLine parses a single "name:value|type[|@rate]" metric.
+func (u *StatsdUnmarshaller) parseLine(line string) (*events.Envelope, error) {
+	separator := strings.LastIndex(line, ":")
+	if separator <= 0 {
+		return nil, errors.New("missing metric name")
+	}
+	name := line[:separator]
+
+	fields := strings.Split(line[separator+1:], "|")
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, errors.New("expected value|type[|@rate]")
+	}
+	rawValue, metricType := fields[0], fields[1]
+
+	sampleRate := 1.0
+	if len(fields) == 3 {
+		if !strings.HasPrefix(fields[2], "@") {
+			return nil, fmt.Errorf("invalid sample rate %q", fields[2])
+		}
+		var err error
+		sampleRate, err = strconv.ParseFloat(fields[2][1:], 64)
+		if err != nil || sampleRate <= 0 || sampleRate > 1 {
+			return nil, fmt.Errorf("invalid sample rate %q", fields[2])
+		}
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", rawValue)
+	}
+
+	switch metricType {
+	case "c":
+		if value < 0 {
+			return nil, errors.New("negative counter deltas are not supported")
+		}
+		return u.counterEvent(name, uint64(math.Floor(value/sampleRate+0.5))), nil
+	case "g":
+		if strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-") {
+			value = u.adjustGauge(name, value)
+		} else {
+			u.setGauge(name, value)
+		}
+		return u.valueMetric(name, value, "gauge"), nil
+	case "ms":
+		return u.valueMetric(name, value, "ms"), nil
+	default:
+		return nil, fmt.Errorf("unsupported metric type %q", metricType)
+	}
+}
+
+type gauge struct {
+	value       float64
+	lastUpdated time.Time
+}
+
+func (u *StatsdUnmarshaller) setGauge(name string, value float64) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.updateGauge(name, value, time.Now())
+}
+
+func (u *StatsdUnmarshaller) adjustGauge(name string, delta float64) float64 {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	value := delta
+	if g, ok := u.gauges[name]; ok {
+		value += g.value
+	}
+	u.updateGauge(name, value, time.Now())
+	return value
+}
+
+// updateGauge must be called with the lock held.
+func (u *StatsdUnmarshaller) updateGauge(name string, value float64, now time.Time) {
+	if g, ok := u.gauges[name]; ok {
+		g.value = value
+		g.lastUpdated = now
+		return
+	}
+
+	if len(u.gauges) >= MaxGauges {
+		u.forgetIdleGauges(now)
+		if len(u.gauges) >= MaxGauges {
+			metrics.BatchIncrementCounter("statsdUnmarshaller.untrackedGauges")
+			return
+		}
+	}
+	u.gauges[name] = &gauge{value: value, lastUpdated: now}
+}
+
+// forgetIdleGauges must be called with the lock held.
+func (u *StatsdUnmarshaller) forgetIdleGauges(now time.Time) {
+	for name, g := range u.gauges {
+		if now.Sub(g.lastUpdated) > GaugeTTL {
+			delete(u.gauges, name)
+		}
+	}
+}
+
+func (u *StatsdUnmarshaller) counterEvent(name string, delta uint64) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(u.origin),
+		Timestamp: proto.Int64(time.Now().UnixNano()),
+		EventType: events.Envelope_CounterEvent.Enum(),
+		CounterEvent: &events.CounterEvent{
+			Name:  proto.String(name),
+			Delta: proto.Uint64(delta),
+		},
+	}
+}
+
+func (u *StatsdUnmarshaller) valueMetric(name string, value float64, unit string) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(u.origin),
+		Timestamp: proto.Int64(time.Now().UnixNano()),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(name),
+			Value: proto.Float64(value),
+			Unit:  proto.String(unit),
+		},
+	}
+}
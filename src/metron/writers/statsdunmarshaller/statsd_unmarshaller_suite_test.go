@@ -0,0 +1,13 @@
+package statsdunmarshaller_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStatsdUnmarshaller(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "StatsdUnmarshaller Suite")
+}
@@ -0,0 +1,126 @@
+package statsdunmarshaller_test
+
+import (
+	"time"
+
+	"metron/writers/mocks"
+	"metron/writers/statsdunmarshaller"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation/testhelpers"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StatsdUnmarshaller", func() {
+	var (
+		writer       *mocks.MockEnvelopeWriter
+		unmarshaller *statsdunmarshaller.StatsdUnmarshaller
+	)
+
+	BeforeEach(func() {
+		writer = &mocks.MockEnvelopeWriter{}
+		unmarshaller = statsdunmarshaller.New("statsd-origin", writer, loggertesthelper.Logger())
+	})
+
+	It("translates counters into CounterEvents", func() {
+		unmarshaller.Write([]byte("requests:3|c"))
+
+		Expect(writer.Events).To(HaveLen(1))
+		envelope := writer.Events[0]
+		Expect(envelope.GetOrigin()).To(Equal("statsd-origin"))
+		Expect(envelope.GetEventType()).To(Equal(events.Envelope_CounterEvent))
+		Expect(envelope.GetCounterEvent().GetName()).To(Equal("requests"))
+		Expect(envelope.GetCounterEvent().GetDelta()).To(BeEquivalentTo(3))
+		Expect(envelope.GetTimestamp()).NotTo(BeZero())
+	})
+
+	It("scales counters by their sample rate", func() {
+		unmarshaller.Write([]byte("requests:3|c|@0.1"))
+
+		Expect(writer.Events[0].GetCounterEvent().GetDelta()).To(BeEquivalentTo(30))
+	})
+
+	It("translates gauges into ValueMetrics", func() {
+		unmarshaller.Write([]byte("queue.depth:42|g"))
+
+		envelope := writer.Events[0]
+		Expect(envelope.GetEventType()).To(Equal(events.Envelope_ValueMetric))
+		Expect(envelope.GetValueMetric().GetName()).To(Equal("queue.depth"))
+		Expect(envelope.GetValueMetric().GetValue()).To(Equal(42.0))
+		Expect(envelope.GetValueMetric().GetUnit()).To(Equal("gauge"))
+	})
+
+	It("applies signed gauges to the previous value", func() {
+		unmarshaller.Write([]byte("queue.depth:42|g\nqueue.depth:-2|g\nqueue.depth:+10|g"))
+
+		Expect(writer.Events).To(HaveLen(3))
+		Expect(writer.Events[1].GetValueMetric().GetValue()).To(Equal(40.0))
+		Expect(writer.Events[2].GetValueMetric().GetValue()).To(Equal(50.0))
+	})
+
+	Context("when the number of remembered gauges is bounded", func() {
+		var (
+			originalMaxGauges int
+			originalGaugeTTL  time.Duration
+		)
+
+		BeforeEach(func() {
+			originalMaxGauges = statsdunmarshaller.MaxGauges
+			originalGaugeTTL = statsdunmarshaller.GaugeTTL
+			statsdunmarshaller.MaxGauges = 2
+			statsdunmarshaller.GaugeTTL = time.Hour
+		})
+
+		AfterEach(func() {
+			statsdunmarshaller.MaxGauges = originalMaxGauges
+			statsdunmarshaller.GaugeTTL = originalGaugeTTL
+		})
+
+		It("does not remember gauges beyond the bound", func() {
+			unmarshaller.Write([]byte("a:1|g\nb:2|g\nc:3|g\nc:+1|g\na:+1|g"))
+
+			Expect(writer.Events).To(HaveLen(5))
+			Expect(writer.Events[3].GetValueMetric().GetValue()).To(Equal(1.0))
+			Expect(writer.Events[4].GetValueMetric().GetValue()).To(Equal(2.0))
+			testhelpers.EventuallyExpectMetric(unmarshaller, "trackedGauges", 2)
+		})
+
+		It("forgets gauges that were not set for GaugeTTL to make room", func() {
+			statsdunmarshaller.GaugeTTL = 10 * time.Millisecond
+			unmarshaller.Write([]byte("a:1|g\nb:2|g"))
+			time.Sleep(20 * time.Millisecond)
+
+			unmarshaller.Write([]byte("c:3|g\nc:+1|g\na:+1|g"))
+
+			Expect(writer.Events[3].GetValueMetric().GetValue()).To(Equal(4.0))
+			Expect(writer.Events[4].GetValueMetric().GetValue()).To(Equal(1.0))
+			testhelpers.EventuallyExpectMetric(unmarshaller, "trackedGauges", 2)
+		})
+	})
+
+	It("translates timers into ValueMetrics in milliseconds", func() {
+		unmarshaller.Write([]byte("response.time:320|ms|@0.5"))
+
+		envelope := writer.Events[0]
+		Expect(envelope.GetValueMetric().GetValue()).To(Equal(320.0))
+		Expect(envelope.GetValueMetric().GetUnit()).To(Equal("ms"))
+	})
+
+	It("handles several metrics in one datagram", func() {
+		unmarshaller.Write([]byte("a:1|c\nb:2|g\n"))
+
+		Expect(writer.Events).To(HaveLen(2))
+		testhelpers.EventuallyExpectMetric(unmarshaller, "receivedMetrics", 2)
+	})
+
+	It("counts and skips malformed metrics", func() {
+		unmarshaller.Write([]byte("no-value\na:x|c\na:1|h\na:-1|c\na:1|c|0.5\na:1|c|@2\ngood:1|c"))
+
+		Expect(writer.Events).To(HaveLen(1))
+		Expect(writer.Events[0].GetCounterEvent().GetName()).To(Equal("good"))
+		testhelpers.EventuallyExpectMetric(unmarshaller, "parseErrors", 6)
+	})
+})
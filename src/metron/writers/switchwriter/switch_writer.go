@@ -0,0 +1,63 @@
+package switchwriter
+
+import (
+	"sync/atomic"
+
+	"metron/writers"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// A ByteArrayWriter forwards messages to a writer that can be replaced while
+// messages are flowing, so that a part of the writer chain can be rebuilt
+// without touching what sits in front of it.
+type ByteArrayWriter struct {
+	current atomic.Value
+}
+
+type byteArrayWriterHolder struct {
+	writer writers.ByteArrayWriter
+}
+
+func NewByteArrayWriter(writer writers.ByteArrayWriter) *ByteArrayWriter {
+	s := &ByteArrayWriter{}
+	s.current.Store(byteArrayWriterHolder{writer})
+	return s
+}
+
+func (s *ByteArrayWriter) Write(message []byte) {
+	s.current.Load().(byteArrayWriterHolder).writer.Write(message)
+}
+
+// Switch makes writer receive every later message and returns the writer it
+// replaces.
+func (s *ByteArrayWriter) Switch(writer writers.ByteArrayWriter) writers.ByteArrayWriter {
+	old := s.current.Load().(byteArrayWriterHolder).writer
+	s.current.Store(byteArrayWriterHolder{writer})
+	return old
+}
+
+// An EnvelopeWriter is the envelope counterpart of ByteArrayWriter.
+type EnvelopeWriter struct {
+	current atomic.Value
+}
+
+type envelopeWriterHolder struct {
+	writer writers.EnvelopeWriter
+}
+
+func NewEnvelopeWriter(writer writers.EnvelopeWriter) *EnvelopeWriter {
+	s := &EnvelopeWriter{}
+	s.current.Store(envelopeWriterHolder{writer})
+	return s
+}
+
+func (s *EnvelopeWriter) Write(envelope *events.Envelope) {
+	s.current.Load().(envelopeWriterHolder).writer.Write(envelope)
+}
+
+func (s *EnvelopeWriter) Switch(writer writers.EnvelopeWriter) writers.EnvelopeWriter {
+	old := s.current.Load().(envelopeWriterHolder).writer
+	s.current.Store(envelopeWriterHolder{writer})
+	return old
+}
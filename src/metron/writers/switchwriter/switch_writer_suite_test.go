@@ -0,0 +1,13 @@
+package switchwriter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSwitchWriter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SwitchWriter Suite")
+}
@@ -0,0 +1,66 @@
+package switchwriter_test
+
+import (
+	"sync"
+
+	"metron/writers/mocks"
+	"metron/writers/switchwriter"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SwitchWriter", func() {
+	Describe("ByteArrayWriter", func() {
+		It("writes to the writer it was last switched to", func() {
+			first := &mocks.MockByteArrayWriter{}
+			second := &mocks.MockByteArrayWriter{}
+			writer := switchwriter.NewByteArrayWriter(first)
+
+			writer.Write([]byte("one"))
+			Expect(writer.Switch(second)).To(Equal(first))
+			writer.Write([]byte("two"))
+
+			Expect(first.Data()).To(Equal([][]byte{[]byte("one")}))
+			Expect(second.Data()).To(Equal([][]byte{[]byte("two")}))
+		})
+
+		It("can be switched while messages are being written", func() {
+			first := &mocks.MockByteArrayWriter{}
+			second := &mocks.MockByteArrayWriter{}
+			writer := switchwriter.NewByteArrayWriter(first)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					writer.Write([]byte("message"))
+				}
+			}()
+			writer.Switch(second)
+			wg.Wait()
+
+			Expect(len(first.Data()) + len(second.Data())).To(Equal(1000))
+		})
+	})
+
+	Describe("EnvelopeWriter", func() {
+		It("writes to the writer it was last switched to", func() {
+			first := &mocks.MockEnvelopeWriter{}
+			second := &mocks.MockEnvelopeWriter{}
+			writer := switchwriter.NewEnvelopeWriter(first)
+
+			envelope := &events.Envelope{Origin: proto.String("origin"), EventType: events.Envelope_LogMessage.Enum()}
+			writer.Write(envelope)
+			writer.Switch(second)
+			writer.Write(envelope)
+
+			Expect(first.Events).To(HaveLen(1))
+			Expect(second.Events).To(HaveLen(1))
+		})
+	})
+})
@@ -15,16 +15,18 @@ type Tagger struct {
 	job            string
 	index          uint
 	ip             string
+	tags           map[string]string
 	outputWriter   writers.EnvelopeWriter
 }
 
-func New(deploymentName string, job string, index uint, outputWriter writers.EnvelopeWriter) *Tagger {
+func New(deploymentName string, job string, index uint, tags map[string]string, outputWriter writers.EnvelopeWriter) *Tagger {
 	ip, _ := localip.LocalIP()
 	return &Tagger{
 		deploymentName: deploymentName,
 		job:            job,
 		index:          index,
 		ip:             ip,
+		tags:           tags,
 		outputWriter:   outputWriter,
 	}
 }
@@ -37,5 +39,22 @@ func (t *Tagger) Write(envelope *events.Envelope) {
 	newEnvelope.Index = proto.String(strconv.Itoa(int(t.index)))
 	newEnvelope.Ip = proto.String(t.ip)
 
+	if len(t.tags) > 0 {
+		newEnvelope.Tags = t.mergeTags(envelope.GetTags())
+	}
+
 	t.outputWriter.Write(&newEnvelope)
 }
+
+// Tags configured on Metron take precedence over tags set by the emitter, the
+// same way the deployment, job and index do.
+func (t *Tagger) mergeTags(envelopeTags map[string]string) map[string]string {
+	tags := make(map[string]string, len(envelopeTags)+len(t.tags))
+	for key, value := range envelopeTags {
+		tags[key] = value
+	}
+	for key, value := range t.tags {
+		tags[key] = value
+	}
+	return tags
+}
@@ -16,7 +16,7 @@ import (
 var _ = Describe("Tagger", func() {
 	It("tags events with the given deployment name, job, index and IP address", func() {
 		mockWriter := &mocks.MockEnvelopeWriter{}
-		t := tagger.New("test-deployment", "test-job", 2, mockWriter)
+		t := tagger.New("test-deployment", "test-job", 2, nil, mockWriter)
 
 		envelope := basicHttpStartStopMessage()
 		t.Write(envelope)
@@ -25,6 +25,19 @@ var _ = Describe("Tagger", func() {
 		expectedEnvelope := basicTaggedHttpStartStopMessage(*envelope)
 		Eventually(mockWriter.Events[0]).Should(Equal(expectedEnvelope))
 	})
+
+	It("adds the configured tags to events", func() {
+		mockWriter := &mocks.MockEnvelopeWriter{}
+		t := tagger.New("test-deployment", "test-job", 2, map[string]string{"az": "z1", "env": "prod"}, mockWriter)
+
+		envelope := basicHttpStartStopMessage()
+		envelope.Tags = map[string]string{"env": "dev", "team": "logging"}
+		t.Write(envelope)
+
+		Expect(mockWriter.Events).To(HaveLen(1))
+		Expect(mockWriter.Events[0].GetTags()).To(Equal(map[string]string{"az": "z1", "env": "prod", "team": "logging"}))
+		Expect(envelope.GetTags()).To(Equal(map[string]string{"env": "dev", "team": "logging"}))
+	})
 })
 
 func basicHttpStartStopMessage() *events.Envelope {
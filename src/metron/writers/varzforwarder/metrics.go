@@ -8,6 +8,7 @@ import (
 
 type metrics struct {
 	metricsByName map[string]float64
+	counters      map[string]bool
 	timer         *time.Timer
 }
 
@@ -30,26 +31,30 @@ func (metrics *metrics) processCounterEvent(metric *events.Envelope) {
 	eventName := metric.GetCounterEvent().GetName()
 	count := metrics.metricsByName[eventName]
 	metrics.metricsByName[eventName] = count + float64(metric.GetCounterEvent().GetDelta())
+	metrics.counters[eventName] = true
 }
 
 func (metrics *metrics) processHTTPStartStop(metric *events.Envelope) {
-	eventName := "requestCount"
-	count := metrics.metricsByName[eventName]
-	metrics.metricsByName[eventName] = count + 1
+	metrics.incrementCounter("requestCount")
 
 	startStop := metric.GetHttpStartStop()
 	status := startStop.GetStatusCode()
 	switch {
 	case status >= 100 && status < 200:
-		metrics.metricsByName["responseCount1XX"] = metrics.metricsByName["responseCount1XX"] + 1
+		metrics.incrementCounter("responseCount1XX")
 	case status >= 200 && status < 300:
-		metrics.metricsByName["responseCount2XX"] = metrics.metricsByName["responseCount2XX"] + 1
+		metrics.incrementCounter("responseCount2XX")
 	case status >= 300 && status < 400:
-		metrics.metricsByName["responseCount3XX"] = metrics.metricsByName["responseCount3XX"] + 1
+		metrics.incrementCounter("responseCount3XX")
 	case status >= 400 && status < 500:
-		metrics.metricsByName["responseCount4XX"] = metrics.metricsByName["responseCount4XX"] + 1
+		metrics.incrementCounter("responseCount4XX")
 	case status >= 500 && status < 600:
-		metrics.metricsByName["responseCount5XX"] = metrics.metricsByName["responseCount5XX"] + 1
+		metrics.incrementCounter("responseCount5XX")
 	default:
 	}
 }
+
+func (metrics *metrics) incrementCounter(name string) {
+	metrics.metricsByName[name] = metrics.metricsByName[name] + 1
+	metrics.counters[name] = true
+}
@@ -12,6 +12,16 @@ import (
 	"github.com/cloudfoundry/sonde-go/events"
 )
 
+// A ForwardedMetric is the latest value of a metric seen from an origin.
+// Counter is set for metrics accumulated from CounterEvents and HTTP
+// requests, as opposed to ValueMetrics, which are gauges.
+type ForwardedMetric struct {
+	Origin  string
+	Name    string
+	Value   float64
+	Counter bool
+}
+
 type VarzForwarder struct {
 	metricsByOrigin map[string]*metrics
 	componentName   string
@@ -60,6 +70,25 @@ func (vf *VarzForwarder) Emit() instrumentation.Context {
 	return c
 }
 
+// Metrics returns the latest value of every metric, per origin.
+func (vf *VarzForwarder) Metrics() []ForwardedMetric {
+	vf.lock.RLock()
+	defer vf.lock.RUnlock()
+
+	forwarded := []ForwardedMetric{}
+	for origin, originMetrics := range vf.metricsByOrigin {
+		for name, value := range originMetrics.metricsByName {
+			forwarded = append(forwarded, ForwardedMetric{
+				Origin:  origin,
+				Name:    name,
+				Value:   value,
+				Counter: originMetrics.counters[name],
+			})
+		}
+	}
+	return forwarded
+}
+
 func (vf *VarzForwarder) addMetric(metric *events.Envelope) {
 	vf.lock.Lock()
 	defer vf.lock.Unlock()
@@ -77,6 +106,7 @@ func (vf *VarzForwarder) createMetrics(origin string) *metrics {
 	vf.logger.Debugf("creating metrics for origin %v", origin)
 	return &metrics{
 		metricsByName: make(map[string]float64),
+		counters:      make(map[string]bool),
 		timer:         time.AfterFunc(vf.ttl, func() { vf.deleteMetrics(origin) }),
 	}
 }
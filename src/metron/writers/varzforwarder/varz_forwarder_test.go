@@ -112,6 +112,17 @@ var _ = Describe("VarzForwarder", func() {
 			Expect(metric2.Value).To(BeNumerically("==", 1))
 		})
 
+		It("marks counters apart from gauges", func() {
+			forwarder.Write(metric("origin", "gauge", 1))
+			forwarder.Write(counterEvent("origin", "counter", 1))
+
+			Eventually(forwarder.Metrics).Should(HaveLen(2))
+			Expect(forwarder.Metrics()).To(ConsistOf(
+				varzforwarder.ForwardedMetric{Origin: "origin", Name: "gauge", Value: 1},
+				varzforwarder.ForwardedMetric{Origin: "origin", Name: "counter", Value: 1, Counter: true},
+			))
+		})
+
 		It("includes the VM name as a tag on each metric", func() {
 			forwarder.Write(metric("origin", "metric", 1))
 
@@ -1,12 +1,14 @@
 package doppler_endpoint
 
 import (
+	"common/logfilter"
 	"fmt"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/server/handlers"
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/gogo/protobuf/proto"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -20,6 +22,7 @@ type DopplerEndpoint struct {
 	Reconnect bool
 	Timeout   time.Duration
 	HProvider HandlerProvider
+	Query     url.Values
 }
 
 func NewDopplerEndpoint(endpoint string,
@@ -62,12 +65,50 @@ func ContainerMetricHandlerProvider(messages <-chan []byte, logger *gosteno.Logg
 	return handlers.NewHttpHandler(outputChan, logger)
 }
 
+// RecentLogsHandlerProvider returns a HandlerProvider that applies the filter
+// to the recent logs of all Dopplers together, so that its limit holds for the
+// whole response rather than for the logs of each Doppler.
+func RecentLogsHandlerProvider(filter *logfilter.Filter) HandlerProvider {
+	return func(messages <-chan []byte, logger *gosteno.Logger) http.Handler {
+		return handlers.NewHttpHandler(ApplyFilter(messages, filter), logger)
+	}
+}
+
 func (endpoint *DopplerEndpoint) GetPath() string {
+	var path string
 	if endpoint.Endpoint == "firehose" {
-		return "/firehose/" + endpoint.StreamId
+		path = "/firehose/" + endpoint.StreamId
 	} else {
-		return fmt.Sprintf("/apps/%s/%s", endpoint.StreamId, endpoint.Endpoint)
+		path = fmt.Sprintf("/apps/%s/%s", endpoint.StreamId, endpoint.Endpoint)
+	}
+
+	if len(endpoint.Query) > 0 {
+		path += "?" + endpoint.Query.Encode()
+	}
+	return path
+}
+
+// ApplyFilter collects the recent logs of all Dopplers and returns those the
+// filter keeps, oldest first.
+func ApplyFilter(input <-chan []byte, filter *logfilter.Filter) <-chan []byte {
+	envelopes := []*events.Envelope{}
+	for message := range input {
+		var envelope events.Envelope
+		if err := proto.Unmarshal(message, &envelope); err != nil {
+			continue
+		}
+		envelopes = append(envelopes, &envelope)
 	}
+
+	envelopes = filter.Apply(envelopes)
+
+	output := make(chan []byte, len(envelopes))
+	for _, envelope := range envelopes {
+		bytes, _ := proto.Marshal(envelope)
+		output <- bytes
+	}
+	close(output)
+	return output
 }
 
 func DeDupe(input <-chan []byte) <-chan []byte {
@@ -1,10 +1,14 @@
 package doppler_endpoint_test
 
 import (
+	"common/logfilter"
+
 	"github.com/cloudfoundry/dropsonde/emitter"
 	"github.com/cloudfoundry/dropsonde/factories"
 	"github.com/cloudfoundry/loggregatorlib/server/handlers"
+	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/gogo/protobuf/proto"
+	"net/url"
 	"time"
 	"trafficcontroller/doppler_endpoint"
 
@@ -59,6 +63,12 @@ var _ = Describe("GetPath", func() {
 		dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("recentlogs", "abc123", true)
 		Expect(dopplerEndpoint.GetPath()).To(Equal("/apps/abc123/recentlogs"))
 	})
+
+	It("appends the query", func() {
+		dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("recentlogs", "abc123", true)
+		dopplerEndpoint.Query = url.Values{"limit": {"10"}}
+		Expect(dopplerEndpoint.GetPath()).To(Equal("/apps/abc123/recentlogs?limit=10"))
+	})
 })
 
 var _ = Describe("ContainerMetricsHandler", func() {
@@ -86,3 +96,27 @@ var _ = Describe("ContainerMetricsHandler", func() {
 	})
 
 })
+
+var _ = Describe("ApplyFilter", func() {
+	It("limits the merged logs of all Dopplers to the most recent ones, oldest first", func() {
+		messagesChan := make(chan []byte, 4)
+		for _, timestamp := range []int64{30, 10, 40, 20} {
+			envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "message", "abc123", "App"), "origin")
+			envelope.LogMessage.Timestamp = proto.Int64(timestamp)
+			envelope.Timestamp = proto.Int64(timestamp)
+			bytes, _ := proto.Marshal(envelope)
+			messagesChan <- bytes
+		}
+		close(messagesChan)
+
+		outputChan := doppler_endpoint.ApplyFilter(messagesChan, &logfilter.Filter{Limit: 2})
+
+		timestamps := []int64{}
+		for bytes := range outputChan {
+			var envelope events.Envelope
+			Expect(proto.Unmarshal(bytes, &envelope)).To(Succeed())
+			timestamps = append(timestamps, envelope.GetTimestamp())
+		}
+		Expect(timestamps).To(Equal([]int64{30, 40}))
+	})
+})
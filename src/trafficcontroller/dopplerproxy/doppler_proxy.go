@@ -1,6 +1,7 @@
 package dopplerproxy
 
 import (
+	"common/logfilter"
 	"fmt"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/logmessage"
@@ -106,6 +107,13 @@ func (proxy *Proxy) serveFirehose(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
+	if _, err := logfilter.ParseFirehose(request.URL.Query()); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(writer, "Invalid firehose query. %s", err.Error())
+		return
+	}
+	dopplerEndpoint.Query = filterParameters(request, logfilter.FirehoseParameters)
+
 	proxy.serveWithDoppler(writer, request, dopplerEndpoint)
 }
 
@@ -145,6 +153,27 @@ func (proxy *Proxy) serveAppLogs(writer http.ResponseWriter, request *http.Reque
 
 	dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint(endpoint_type, appId, reconnect)
 
+	switch endpoint_type {
+	case "recentlogs":
+		filter, err := logfilter.Parse(request.URL.Query())
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(writer, "Invalid recent logs query. %s", err.Error())
+			return
+		}
+		dopplerEndpoint.Query = filterParameters(request, logfilter.Parameters)
+		if filter.Limit > 0 {
+			dopplerEndpoint.HProvider = doppler_endpoint.RecentLogsHandlerProvider(filter)
+		}
+	case "stream":
+		if _, err := logfilter.ParseStream(request.URL.Query()); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(writer, "Invalid stream query. %s", err.Error())
+			return
+		}
+		dopplerEndpoint.Query = filterParameters(request, logfilter.StreamParameters)
+	}
+
 	proxy.serveWithDoppler(writer, request, dopplerEndpoint)
 }
 
@@ -181,6 +210,21 @@ func (proxy *Proxy) isAuthorized(authorizer Authorizer, appId, authToken string,
 	return true, nil
 }
 
+// filterParameters returns the filter parameters of a request for passing on
+// to every Doppler, which evaluate them. A recent logs limit is applied again
+// to the merged logs of all Dopplers.
+func filterParameters(request *http.Request, parameters []string) url.Values {
+	requestQuery := request.URL.Query()
+
+	query := url.Values{}
+	for _, name := range parameters {
+		if values, ok := requestQuery[name]; ok {
+			query[name] = values
+		}
+	}
+	return query
+}
+
 func getAuthToken(req *http.Request) string {
 	authToken := req.Header.Get("Authorization")
 
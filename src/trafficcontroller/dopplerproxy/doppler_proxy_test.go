@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -155,6 +156,43 @@ var _ = Describe("ServeHTTP", func() {
 			Eventually(channelGroupConnector.getReconnect).Should(BeFalse())
 		})
 
+		It("passes the recentlogs filter parameters to doppler", func() {
+			close(channelGroupConnector.messages)
+			req, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?message_type=ERR&limit=10&other=x", nil)
+			req.Header.Add("Authorization", "token")
+
+			proxy.ServeHTTP(recorder, req)
+
+			Eventually(channelGroupConnector.getQuery).Should(Equal(url.Values{"message_type": {"ERR"}, "limit": {"10"}}))
+		})
+
+		It("returns a 400 for an invalid recentlogs filter", func() {
+			req, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?limit=none", nil)
+			req.Header.Add("Authorization", "token")
+
+			proxy.ServeHTTP(recorder, req)
+
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("passes the stream filter parameters to doppler", func() {
+			req, _ := http.NewRequest("GET", "/apps/abc123/stream?event_type=LogMessage&instance=0&instance=1&other=x", nil)
+			req.Header.Add("Authorization", "token")
+
+			proxy.ServeHTTP(recorder, req)
+
+			Eventually(channelGroupConnector.getQuery).Should(Equal(url.Values{"event_type": {"LogMessage"}, "instance": {"0", "1"}}))
+		})
+
+		It("returns a 400 for an invalid stream filter", func() {
+			req, _ := http.NewRequest("GET", "/apps/abc123/stream?message_type=DEBUG", nil)
+			req.Header.Add("Authorization", "token")
+
+			proxy.ServeHTTP(recorder, req)
+
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		})
+
 		It("connects to doppler servers without reconnecting for containermetrics", func() {
 			close(channelGroupConnector.messages)
 			req, _ := http.NewRequest("GET", "/apps/abc123/containermetrics", nil)
@@ -204,6 +242,28 @@ var _ = Describe("ServeHTTP", func() {
 				Eventually(channelGroupConnector.getReconnect).Should(BeTrue())
 			})
 
+			It("passes the firehose filter parameters to doppler", func() {
+				req, _ := http.NewRequest("GET", "/firehose/abc-123?event_type=ValueMetric&origin=gorouter,uaa&metric_prefix=gorouter.", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Eventually(channelGroupConnector.getQuery).Should(Equal(url.Values{
+					"event_type":    {"ValueMetric"},
+					"origin":        {"gorouter,uaa"},
+					"metric_prefix": {"gorouter."},
+				}))
+			})
+
+			It("returns a 400 for an invalid firehose filter", func() {
+				req, _ := http.NewRequest("GET", "/firehose/abc-123?event_type=Unknown", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			})
+
 			It("returns an unauthorized status and sets the WWW-Authenticate header if authorization fails", func() {
 				adminAuth.Result = AuthorizerResult{Authorized: false, ErrorMessage: "Error: Invalid authorization"}
 
@@ -433,6 +493,12 @@ func (f *fakeChannelGroupConnector) getPath() string {
 	return f.dopplerEndpoint.Endpoint
 }
 
+func (f *fakeChannelGroupConnector) getQuery() url.Values {
+	f.Lock()
+	defer f.Unlock()
+	return f.dopplerEndpoint.Query
+}
+
 func (f *fakeChannelGroupConnector) getStreamId() string {
 	f.Lock()
 	defer f.Unlock()